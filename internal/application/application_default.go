@@ -1,14 +1,24 @@
 package application
 
 import (
+	"app/internal"
 	"app/internal/handler"
 	"app/internal/loader"
 	"app/internal/repository"
 	"app/internal/service"
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
 	"net/http"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	_ "modernc.org/sqlite"
 )
 
 // ConfigServerChi is a struct that represents the configuration for ServerChi
@@ -16,14 +26,85 @@ type ConfigServerChi struct {
 	// ServerAddress is the address where the server will be listening
 	ServerAddress string
 	// LoaderFilePath is the path to the file that contains the vehicles
+	// - a file with the .csv extension holds them in CSV format, any other file in JSON format
 	LoaderFilePath string
+	// Storage is the kind of repository used for the vehicles
+	// - StorageMemory (default): vehicles are kept in memory, changes are lost on restart
+	// - StorageFile: every change is written back to the loader file
+	// - StorageJournal: vehicles are kept in memory, changes are appended to a journal replayed at startup
+	// and periodically compacted into the loader file
+	// - StorageSQL: vehicles are stored in the database, seeded from the loader file when empty
+	Storage string
+	// JournalFilePath is the path to the journal used by StorageJournal (default LoaderFilePath + ".journal")
+	// - while compacting, the entries being compacted are kept in JournalFilePath + ".prev"
+	JournalFilePath string
+	// CompactInterval is how often the journal is compacted into the loader file (default 5 minutes)
+	CompactInterval time.Duration
+	// DatabaseDriver is the database/sql driver used by StorageSQL (default "sqlite")
+	DatabaseDriver string
+	// DatabaseDSN is the data source name used by StorageSQL
+	// - with sqlite, an empty DSN or ":memory:" keeps the database in memory, a file path persists it
+	DatabaseDSN string
+	// VehicleRules are the rules vehicles must follow to be created or updated (default service.DefaultVehicleRules)
+	VehicleRules *service.VehicleRules
+	// TrashRetention is how long deleted vehicles are kept in the trash before they are purged (default 30 days)
+	TrashRetention time.Duration
+	// PurgeInterval is how often the vehicles deleted for longer than TrashRetention are purged,
+	// and the versions superseded for longer than HistoryRetention pruned (default 1 hour)
+	PurgeInterval time.Duration
+	// EventBufferSize is how many vehicle events are kept for clients resuming GET /vehicles/events (default 1000)
+	EventBufferSize int
+	// WebhookMaxAttempts is how many times an event is posted to a webhook before it is dead-lettered (default 6)
+	WebhookMaxAttempts int
+	// WebhookBackoff is the delay before the second attempt of a webhook delivery, doubled for each next one (default 1 second)
+	WebhookBackoff time.Duration
+	// WebhookMaxBackoff is the maximum delay between two attempts of a webhook delivery (default 5 minutes)
+	WebhookMaxBackoff time.Duration
+	// WebhookTimeout is how long a webhook has to respond to an attempt (default 10 seconds)
+	WebhookTimeout time.Duration
+	// WebhookAllowedNetworks are the internal networks the webhooks may reach, e.g. 10.0.0.0/8 (default none)
+	// - loopback, private, link-local, multicast and unspecified addresses are refused otherwise
+	WebhookAllowedNetworks []netip.Prefix
+	// WebhookWorkers is how many webhook deliveries are attempted at once (default 8)
+	WebhookWorkers int
+	// WebhookLogSize is how many succeeded deliveries are kept per webhook, the webhooks are kept in memory (default 100)
+	WebhookLogSize int
+	// HistoryRetention is how far back the vehicles can be read as they were, see GET /vehicles?as_of= (default 90 days)
+	// - the history of StorageMemory, StorageFile and StorageJournal is kept in memory and starts at startup
+	HistoryRetention time.Duration
 }
 
+const (
+	// StorageMemory keeps the vehicles in memory only
+	StorageMemory = "memory"
+	// StorageFile persists the vehicles to the loader file
+	StorageFile = "file"
+	// StorageJournal keeps the vehicles in memory backed by a journal
+	StorageJournal = "journal"
+	// StorageSQL stores the vehicles in a database
+	StorageSQL = "sql"
+)
+
 // NewServerChi is a function that returns a new instance of ServerChi
 func NewServerChi(cfg *ConfigServerChi) *ServerChi {
 	// default values
+	defaultRules := service.DefaultVehicleRules()
 	defaultConfig := &ConfigServerChi{
-		ServerAddress: ":8080",
+		ServerAddress:      ":8080",
+		Storage:            StorageMemory,
+		DatabaseDriver:     "sqlite",
+		CompactInterval:    5 * time.Minute,
+		VehicleRules:       &defaultRules,
+		TrashRetention:     30 * 24 * time.Hour,
+		PurgeInterval:      time.Hour,
+		HistoryRetention:   90 * 24 * time.Hour,
+		EventBufferSize:    1000,
+		WebhookMaxAttempts: 6,
+		WebhookBackoff:     time.Second,
+		WebhookMaxBackoff:  5 * time.Minute,
+		WebhookTimeout:     10 * time.Second,
+		WebhookWorkers:     8,
+		WebhookLogSize:     100,
 	}
 	if cfg != nil {
 		if cfg.ServerAddress != "" {
@@ -32,11 +113,85 @@ func NewServerChi(cfg *ConfigServerChi) *ServerChi {
 		if cfg.LoaderFilePath != "" {
 			defaultConfig.LoaderFilePath = cfg.LoaderFilePath
 		}
+		if cfg.Storage != "" {
+			defaultConfig.Storage = cfg.Storage
+		}
+		if cfg.JournalFilePath != "" {
+			defaultConfig.JournalFilePath = cfg.JournalFilePath
+		}
+		if cfg.CompactInterval > 0 {
+			defaultConfig.CompactInterval = cfg.CompactInterval
+		}
+		if cfg.DatabaseDriver != "" {
+			defaultConfig.DatabaseDriver = cfg.DatabaseDriver
+		}
+		if cfg.DatabaseDSN != "" {
+			defaultConfig.DatabaseDSN = cfg.DatabaseDSN
+		}
+		if cfg.VehicleRules != nil {
+			defaultConfig.VehicleRules = cfg.VehicleRules
+		}
+		if cfg.TrashRetention > 0 {
+			defaultConfig.TrashRetention = cfg.TrashRetention
+		}
+		if cfg.PurgeInterval > 0 {
+			defaultConfig.PurgeInterval = cfg.PurgeInterval
+		}
+		if cfg.HistoryRetention > 0 {
+			defaultConfig.HistoryRetention = cfg.HistoryRetention
+		}
+		if cfg.EventBufferSize > 0 {
+			defaultConfig.EventBufferSize = cfg.EventBufferSize
+		}
+		if cfg.WebhookMaxAttempts > 0 {
+			defaultConfig.WebhookMaxAttempts = cfg.WebhookMaxAttempts
+		}
+		if cfg.WebhookBackoff > 0 {
+			defaultConfig.WebhookBackoff = cfg.WebhookBackoff
+		}
+		if cfg.WebhookMaxBackoff > 0 {
+			defaultConfig.WebhookMaxBackoff = cfg.WebhookMaxBackoff
+		}
+		if cfg.WebhookTimeout > 0 {
+			defaultConfig.WebhookTimeout = cfg.WebhookTimeout
+		}
+		if cfg.WebhookAllowedNetworks != nil {
+			defaultConfig.WebhookAllowedNetworks = cfg.WebhookAllowedNetworks
+		}
+		if cfg.WebhookWorkers > 0 {
+			defaultConfig.WebhookWorkers = cfg.WebhookWorkers
+		}
+		if cfg.WebhookLogSize > 0 {
+			defaultConfig.WebhookLogSize = cfg.WebhookLogSize
+		}
+	}
+	if defaultConfig.JournalFilePath == "" {
+		defaultConfig.JournalFilePath = defaultConfig.LoaderFilePath + ".journal"
 	}
 
 	return &ServerChi{
-		serverAddress:  defaultConfig.ServerAddress,
-		loaderFilePath: defaultConfig.LoaderFilePath,
+		serverAddress:    defaultConfig.ServerAddress,
+		loaderFilePath:   defaultConfig.LoaderFilePath,
+		storage:          defaultConfig.Storage,
+		journalFilePath:  defaultConfig.JournalFilePath,
+		compactInterval:  defaultConfig.CompactInterval,
+		databaseDriver:   defaultConfig.DatabaseDriver,
+		databaseDSN:      defaultConfig.DatabaseDSN,
+		vehicleRules:     *defaultConfig.VehicleRules,
+		trashRetention:   defaultConfig.TrashRetention,
+		purgeInterval:    defaultConfig.PurgeInterval,
+		historyRetention: defaultConfig.HistoryRetention,
+		eventBufferSize:  defaultConfig.EventBufferSize,
+		webhookConfig: service.WebhookConfig{
+			Timeout:         defaultConfig.WebhookTimeout,
+			AllowedNetworks: defaultConfig.WebhookAllowedNetworks,
+			Workers:         defaultConfig.WebhookWorkers,
+			MaxAttempts:     defaultConfig.WebhookMaxAttempts,
+			Backoff:         defaultConfig.WebhookBackoff,
+			MaxBackoff:      defaultConfig.WebhookMaxBackoff,
+			Encode:          handler.EncodeVehicleEvent,
+		},
+		webhookLogSize: defaultConfig.WebhookLogSize,
 	}
 }
 
@@ -46,28 +201,89 @@ type ServerChi struct {
 	serverAddress string
 	// loaderFilePath is the path to the file that contains the vehicles
 	loaderFilePath string
+	// storage is the kind of repository used for the vehicles
+	storage string
+	// journalFilePath is the path to the journal used by StorageJournal
+	journalFilePath string
+	// compactInterval is how often the journal is compacted into the loader file
+	compactInterval time.Duration
+	// databaseDriver is the database/sql driver used by StorageSQL
+	databaseDriver string
+	// databaseDSN is the data source name used by StorageSQL
+	databaseDSN string
+	// vehicleRules are the rules vehicles must follow to be created or updated
+	vehicleRules service.VehicleRules
+	// trashRetention is how long deleted vehicles are kept in the trash
+	trashRetention time.Duration
+	// purgeInterval is how often the trash is purged and the history pruned
+	purgeInterval time.Duration
+	// historyRetention is how long superseded versions of the vehicles are kept
+	historyRetention time.Duration
+	// eventBufferSize is how many vehicle events are kept for replay
+	eventBufferSize int
+	// webhookConfig is the configuration of the webhook deliveries
+	webhookConfig service.WebhookConfig
+	// webhookLogSize is how many succeeded deliveries are kept per webhook
+	webhookLogSize int
 }
 
 // Run is a method that runs the application
 func (a *ServerChi) Run() (err error) {
 	// dependencies
 	// - loader
-	ld := loader.NewVehicleJSONFile(a.loaderFilePath)
+	ld := newVehicleLoader(a.loaderFilePath)
 	db, err := ld.Load()
 	if err != nil {
 		return
 	}
 	// - repository
-	rp := repository.NewVehicleMap(db)
+	var rp internal.VehicleRepository
+	var au internal.AuditRepository = repository.NewAuditMap()
+	switch a.storage {
+	case StorageMemory:
+		rp = repository.NewVehicleMap(db)
+	case StorageFile:
+		rp = repository.NewVehicleFile(db, ld)
+	case StorageJournal:
+		jr := repository.NewVehicleJournal(a.journalFilePath)
+		defer jr.Close()
+		if err = jr.Replay(db); err != nil {
+			return
+		}
+		rpJournal := repository.NewVehicleMapWithJournal(db, jr)
+		go a.compact(rpJournal, ld)
+		rp = rpJournal
+	case StorageSQL:
+		var rpSQL *repository.VehicleSQL
+		rpSQL, au, err = a.newVehicleSQL(db)
+		if err != nil {
+			return
+		}
+		defer rpSQL.Close()
+		rp = rpSQL
+	default:
+		err = fmt.Errorf("unknown storage %q", a.storage)
+		return
+	}
 	// - service
-	sv := service.NewVehicleDefault(rp)
+	vl := service.NewVehicleRulesValidator(a.vehicleRules)
+	ev := service.NewVehicleBroker(a.eventBufferSize)
+	sv := service.NewVehicleDefault(rp, vl, au, ev)
+	go a.purge(sv)
+	go a.prune(rp)
+	svWebhook := service.NewWebhookDefault(repository.NewWebhookMap(a.webhookLogSize), a.webhookConfig)
+	go svWebhook.Run(ev)
 	// - handler
 	hd := handler.NewVehicleDefault(sv)
+	hdWebhook := handler.NewWebhookDefault(svWebhook)
 	// router
 	rt := chi.NewRouter()
+	rt.NotFound(handler.NotFound)
+	rt.MethodNotAllowed(handler.MethodNotAllowed)
 	// - middlewares
 	rt.Use(middleware.Logger)
 	rt.Use(middleware.Recoverer)
+	rt.Use(handler.Actor)
 	// - endpoints
 	rt.Route("/vehicles", func(rt chi.Router) {
 		// - GET /vehicles
@@ -77,14 +293,146 @@ func (a *ServerChi) Run() (err error) {
 		rt.Get("/brand/{brand}/between/{start_year}/{end_year}", hd.GetByBrandBetweenYears())
 		rt.Get("/average_speed/brand/{brand}", hd.GetSpeedAvgByBrand())
 		rt.Post("/batch", hd.CreateMultiple())
+		rt.Post("/import", hd.Import())
 		rt.Get("/weight", hd.ListByWeightRange())
 		rt.Get("/dimensions", hd.ListByDimensions())
 		rt.Put("/{id}/update_speed", hd.Update())
+		rt.Get("/{id}", hd.GetById())
+		rt.Get("/registration/{plate}", hd.GetByRegistration())
+		rt.Patch("/{id}", hd.Patch())
 		rt.Delete("/{id}", hd.Delete())
+		rt.Get("/trash", hd.GetTrash())
+		rt.Get("/events", hd.Events())
+		rt.Post("/{id}/restore", hd.Restore())
+		rt.Get("/{id}/history", hd.History())
 		rt.Get("/average_capacity/brand/{brand}", hd.GetAverageCapacityByBrand())
+		rt.Get("/brands/stats", hd.GetBrandAggregates())
+		rt.Get("/stats", hd.GetStatistics())
+		rt.Get("/aggregate", hd.Aggregate())
+	})
+	rt.Get("/audit", hd.GetAudit())
+	rt.Route("/webhooks", func(rt chi.Router) {
+		rt.Get("/", hdWebhook.GetAll())
+		rt.Post("/", hdWebhook.Create())
+		rt.Get("/dead_letters", hdWebhook.GetDeadLetters())
+		rt.Post("/deliveries/{id}/retry", hdWebhook.Redeliver())
+		rt.Get("/{id}", hdWebhook.GetById())
+		rt.Delete("/{id}", hdWebhook.Delete())
+		rt.Get("/{id}/deliveries", hdWebhook.GetDeliveries())
 	})
 
 	// run server
 	err = http.ListenAndServe(a.serverAddress, rt)
 	return
 }
+
+// vehicleLoaderStorer is an interface that represents a file the vehicles are loaded from and saved to
+type vehicleLoaderStorer interface {
+	internal.VehicleLoader
+	internal.VehicleStorer
+}
+
+// newVehicleLoader is a function that returns the loader of the file, chosen by its extension
+func newVehicleLoader(path string) vehicleLoaderStorer {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return loader.NewVehicleCSVFile(path, nil)
+	}
+	return loader.NewVehicleJSONFile(path)
+}
+
+// newVehicleSQL is a method that opens the database, applies the migrations and seeds it with db when it is empty
+// - the audit trail is stored in the same database
+// - sqlite is used through a single connection: it allows one writer at a time, and each connection
+// to an in-memory database opens a database of its own
+// - the database is closed when anything fails, otherwise by closing rp
+func (a *ServerChi) newVehicleSQL(db map[int]internal.Vehicle) (rp *repository.VehicleSQL, au *repository.AuditSQL, err error) {
+	conn, err := sql.Open(a.databaseDriver, a.databaseDSN)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+			rp, au = nil, nil
+		}
+	}()
+	if a.databaseDriver == "sqlite" {
+		conn.SetMaxOpenConns(1)
+	}
+	if err = conn.Ping(); err != nil {
+		return
+	}
+
+	rp = repository.NewVehicleSQL(conn)
+	au = repository.NewAuditSQL(conn)
+	if err = rp.Migrate(); err != nil {
+		return
+	}
+
+	// seed
+	v, err := rp.FindAll()
+	if err != nil {
+		return
+	}
+	deleted, err := rp.FindDeleted()
+	if err != nil {
+		return
+	}
+	if len(v) == 0 && len(deleted) == 0 && len(db) > 0 {
+		seed := make([]internal.Vehicle, 0, len(db))
+		for _, value := range db {
+			seed = append(seed, value)
+		}
+		err = rp.Seed(seed)
+	}
+	return
+}
+
+// compact is a method that periodically compacts the journal of rp into the loader file
+func (a *ServerChi) compact(rp *repository.VehicleMap, st internal.VehicleStorer) {
+	ticker := time.NewTicker(a.compactInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := rp.Compact(st); err != nil {
+			log.Printf("compact: %s", err)
+		}
+	}
+}
+
+// purge is a method that periodically purges the vehicles deleted for longer than the trash retention
+func (a *ServerChi) purge(sv internal.VehicleService) {
+	ticker := time.NewTicker(a.purgeInterval)
+	defer ticker.Stop()
+
+	// purges are made by the application itself
+	ctx := internal.WithActor(context.Background(), internal.SystemActor)
+
+	for range ticker.C {
+		ids, err := sv.Purge(ctx, time.Now().Add(-a.trashRetention))
+		if err != nil {
+			log.Printf("purge: %s", err)
+			continue
+		}
+		if len(ids) > 0 {
+			log.Printf("purge: purged %d vehicles from the trash", len(ids))
+		}
+	}
+}
+
+// prune is a method that periodically drops the versions of the vehicles superseded for longer than the history retention
+func (a *ServerChi) prune(rp internal.VehicleRepository) {
+	ticker := time.NewTicker(a.purgeInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		n, err := rp.PruneHistory(time.Now().Add(-a.historyRetention))
+		if err != nil {
+			log.Printf("prune: %s", err)
+			continue
+		}
+		if n > 0 {
+			log.Printf("prune: pruned %d versions from the history", n)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"app/internal"
+	"net/http"
+	"strings"
+)
+
+// ActorHeader is the header identifying who makes the request, recorded in the audit trail
+const ActorHeader = "X-Actor"
+
+// Actor is a middleware that carries the actor of the request in its context, see internal.ActorFrom
+// - requests without the header are made by internal.AnonymousActor
+func Actor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := strings.TrimSpace(r.Header.Get(ActorHeader)); actor != "" {
+			r = r.WithContext(internal.WithActor(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
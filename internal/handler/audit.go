@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"app/internal"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	// defaultAuditLimit is the number of audit entries returned when the request has no limit
+	defaultAuditLimit = 100
+	// maxAuditLimit is the maximum number of audit entries returned at once
+	maxAuditLimit = 1000
+)
+
+// ErrAuditQuery is returned when the from, to, actor or limit query parameters are invalid
+var ErrAuditQuery = errors.New("invalid audit query")
+
+// AuditChangeJSON is a struct that represents the change of a field in JSON format
+type AuditChangeJSON struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// AuditEntryJSON is a struct that represents an audit entry in JSON format
+type AuditEntryJSON struct {
+	ID        int               `json:"id"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	Operation string            `json:"operation"`
+	VehicleID int               `json:"vehicle_id"`
+	Version   int               `json:"version,omitempty"`
+	Changes   []AuditChangeJSON `json:"changes"`
+}
+
+// AuditMeta is a struct that represents the metadata of a list of audit entries
+type AuditMeta struct {
+	// Count is the number of entries returned
+	Count int `json:"count"`
+	// Limit is the maximum number of entries returned, the latest ones are kept
+	Limit int `json:"limit"`
+}
+
+// newAuditEntriesJSON is a function that returns the JSON representation of the audit entries
+func newAuditEntriesJSON(e []internal.AuditEntry) (data []AuditEntryJSON) {
+	data = make([]AuditEntryJSON, 0, len(e))
+	for _, entry := range e {
+		changes := make([]AuditChangeJSON, 0, len(entry.Changes))
+		for _, c := range entry.Changes {
+			changes = append(changes, AuditChangeJSON{Field: c.Field, Before: c.Before, After: c.After})
+		}
+		data = append(data, AuditEntryJSON{
+			ID:        entry.Id,
+			Time:      entry.Time,
+			Actor:     entry.Actor,
+			Operation: entry.Operation,
+			VehicleID: entry.VehicleId,
+			Version:   entry.Version,
+			Changes:   changes,
+		})
+	}
+	return
+}
+
+// parseAuditQuery is a function that parses the query parameters from, to, actor and limit
+// - from and to are RFC 3339 times, from is inclusive and to exclusive
+// - limit=n between 1 and maxAuditLimit (default defaultAuditLimit)
+func parseAuditQuery(r *http.Request) (q internal.AuditQuery, err error) {
+	query := r.URL.Query()
+
+	// time range
+	if s := query.Get("from"); s != "" {
+		if q.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("%w: from must be an RFC 3339 time", ErrAuditQuery)
+		}
+	}
+	if s := query.Get("to"); s != "" {
+		if q.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return q, fmt.Errorf("%w: to must be an RFC 3339 time", ErrAuditQuery)
+		}
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && !q.From.Before(q.To) {
+		return q, fmt.Errorf("%w: from must be before to", ErrAuditQuery)
+	}
+
+	// actor
+	q.Actor = query.Get("actor")
+
+	// limit
+	q.Limit = defaultAuditLimit
+	if s := query.Get("limit"); s != "" {
+		q.Limit, err = strconv.Atoi(s)
+		if err != nil || q.Limit < 1 || q.Limit > maxAuditLimit {
+			return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrAuditQuery, maxAuditLimit)
+		}
+	}
+
+	return q, nil
+}
+
+// History is a method that returns a handler for the route GET /vehicles/{id}/history
+// - the entries of the vehicle are filtered like GET /audit, oldest first
+// - purged vehicles keep their history, so an unknown id returns an empty list rather than 404
+func (h *VehicleDefault) History() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil || id <= 0 {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+		q, err := parseAuditQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		q.VehicleId = id
+
+		// process
+		e, err := h.sv.FindAudit(q)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newAuditEntriesJSON(e),
+			Meta:    AuditMeta{Count: len(e), Limit: q.Limit},
+		})
+	}
+}
+
+// GetAudit is a method that returns a handler for the route GET /audit
+// - ?from=&to= filter by time range, ?actor= by who made the changes, see parseAuditQuery
+// - the latest entries matching are returned, oldest first
+func (h *VehicleDefault) GetAudit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		q, err := parseAuditQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// process
+		e, err := h.sv.FindAudit(q)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newAuditEntriesJSON(e),
+			Meta:    AuditMeta{Count: len(e), Limit: q.Limit},
+		})
+	}
+}
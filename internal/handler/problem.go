@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"app/internal"
+	"app/internal/loader"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// internalProblemDetail is the detail of the server errors without a more specific one
+const internalProblemDetail = "The request could not be completed due to an internal error"
+
+// Stable codes of the problems returned by the handlers, clients should rely on them rather than on the messages
+const (
+	CodeInvalidRequest        = "invalid_request"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeRouteNotFound         = "route_not_found"
+	CodeMethodNotAllowed      = "method_not_allowed"
+	CodeVehicleNotFound       = "vehicle_not_found"
+	CodeVehiclesNotFound      = "vehicles_not_found"
+	CodeVehicleAlreadyExists  = "vehicle_already_exists"
+	CodeRegistrationExists    = "registration_already_exists"
+	CodeVehicleNotDeleted     = "vehicle_not_deleted"
+	CodeVehicleInvalid        = "vehicle_invalid"
+	CodeVersionConflict       = "version_conflict"
+	CodePreconditionFailed    = "precondition_failed"
+	CodeInvalidFilter         = "invalid_filter"
+	CodeInvalidPagination     = "invalid_pagination"
+	CodeInvalidPercentile     = "invalid_percentile"
+	CodeInvalidAggregation    = "invalid_aggregation"
+	CodeInvalidPatch          = "invalid_patch"
+	CodePatchTestFailed       = "patch_test_failed"
+	CodeInvalidPatchedVehicle = "invalid_patched_vehicle"
+	CodeInvalidAuditQuery     = "invalid_audit_query"
+	CodeInvalidAsOf           = "invalid_as_of"
+	CodeHistoryUnavailable    = "history_unavailable"
+	CodeWebhookNotFound       = "webhook_not_found"
+	CodeWebhookInvalid        = "webhook_invalid"
+	CodeDeliveryNotFound      = "delivery_not_found"
+	CodeDeliveryNotDead       = "delivery_not_dead"
+	CodeInvalidFormat         = "invalid_format"
+	CodeInvalidCSV            = "invalid_csv"
+	CodeInvalidCSVRow         = "invalid_csv_row"
+	CodeStorageUnavailable    = "storage_unavailable"
+	CodeAuditUnrecorded       = "audit_unrecorded"
+	CodeNotFound              = "not_found"
+	CodeConflict              = "conflict"
+	CodeValidationFailed      = "validation_failed"
+	CodeServiceUnavailable    = "service_unavailable"
+	CodeInternal              = "internal_error"
+)
+
+// problemTypePrefix is the prefix of the type of every problem, followed by its code
+const problemTypePrefix = "/problems/"
+
+// problemMapping is a struct that represents how errors wrapping a sentinel are rendered
+type problemMapping struct {
+	// err is the sentinel
+	err error
+	// status is the HTTP status of the problem
+	status int
+	// code is the stable code of the problem
+	code string
+	// detail is the fixed detail of a server error (5xx), whose error is logged rather than returned
+	detail string
+}
+
+// problemMappings are the sentinels known to the handlers, the first one wrapped by an error decides its problem
+// - the classes of the service errors come last, so they only apply to errors without a more specific sentinel
+var problemMappings = []problemMapping{
+	{err: internal.ErrVehicleNotFoundService, status: http.StatusNotFound, code: CodeVehicleNotFound},
+	{err: internal.ErrVehiclesNotFoundByCriteria, status: http.StatusNotFound, code: CodeVehiclesNotFound},
+	{err: internal.ErrNoVehiclesByBrandService, status: http.StatusNotFound, code: CodeVehiclesNotFound},
+	{err: internal.ErrVehicleAlreadyExistsService, status: http.StatusConflict, code: CodeVehicleAlreadyExists},
+	{err: internal.ErrVehicleRegistrationExistsService, status: http.StatusConflict, code: CodeRegistrationExists},
+	{err: internal.ErrVehicleNotDeletedService, status: http.StatusConflict, code: CodeVehicleNotDeleted},
+	{err: internal.ErrVehicleHistoryUnavailableService, status: http.StatusUnprocessableEntity, code: CodeHistoryUnavailable},
+	{err: internal.ErrVehicleInvalidService, status: http.StatusUnprocessableEntity, code: CodeVehicleInvalid},
+	{err: internal.ErrVehicleVersionConflict, status: http.StatusPreconditionFailed, code: CodeVersionConflict},
+	{err: internal.ErrInvalidFilterService, status: http.StatusBadRequest, code: CodeInvalidFilter},
+	{err: internal.ErrInvalidPercentileService, status: http.StatusBadRequest, code: CodeInvalidPercentile},
+	{err: internal.ErrInvalidAggregationService, status: http.StatusBadRequest, code: CodeInvalidAggregation},
+	{err: internal.ErrWebhookNotFoundService, status: http.StatusNotFound, code: CodeWebhookNotFound},
+	{err: internal.ErrWebhookInvalidService, status: http.StatusUnprocessableEntity, code: CodeWebhookInvalid},
+	{err: internal.ErrWebhookDeliveryNotFoundService, status: http.StatusNotFound, code: CodeDeliveryNotFound},
+	{err: internal.ErrWebhookDeliveryNotDeadService, status: http.StatusConflict, code: CodeDeliveryNotDead},
+	{err: internal.ErrVehicleAuditUnrecordedService, status: http.StatusInternalServerError, code: CodeAuditUnrecorded, detail: "The change was done but could not be recorded in the audit trail"},
+	{err: internal.ErrVehicleStorageRepo, status: http.StatusServiceUnavailable, code: CodeStorageUnavailable, detail: "The vehicles could not be stored, try again later"},
+	{err: ErrFilterSyntax, status: http.StatusBadRequest, code: CodeInvalidFilter},
+	{err: ErrPageQuery, status: http.StatusBadRequest, code: CodeInvalidPagination},
+	{err: ErrPatchSyntax, status: http.StatusBadRequest, code: CodeInvalidPatch},
+	{err: ErrPatchTest, status: http.StatusConflict, code: CodePatchTestFailed},
+	{err: ErrPatchResult, status: http.StatusUnprocessableEntity, code: CodeInvalidPatchedVehicle},
+	{err: ErrAuditQuery, status: http.StatusBadRequest, code: CodeInvalidAuditQuery},
+	{err: ErrAsOfQuery, status: http.StatusBadRequest, code: CodeInvalidAsOf},
+	{err: ErrFormatQuery, status: http.StatusBadRequest, code: CodeInvalidFormat},
+	{err: loader.ErrVehicleCSV, status: http.StatusBadRequest, code: CodeInvalidCSV},
+	{err: loader.ErrVehicleCSVRow, status: http.StatusBadRequest, code: CodeInvalidCSVRow},
+	{err: ErrPreconditionFailed, status: http.StatusPreconditionFailed, code: CodePreconditionFailed},
+	{err: internal.ErrClassNotFound, status: http.StatusNotFound, code: CodeNotFound},
+	{err: internal.ErrClassConflict, status: http.StatusConflict, code: CodeConflict},
+	{err: internal.ErrClassValidation, status: http.StatusUnprocessableEntity, code: CodeValidationFailed},
+	{err: internal.ErrClassUnavailable, status: http.StatusServiceUnavailable, code: CodeServiceUnavailable, detail: "The service is temporarily unavailable, try again later"},
+	{err: internal.ErrClassInternal, status: http.StatusInternalServerError, code: CodeInternal, detail: internalProblemDetail},
+}
+
+// Problem is a struct that represents an error response in the problem details format (RFC 7807)
+type Problem struct {
+	// Type identifies the kind of problem, it is derived from Code
+	Type string `json:"type"`
+	// Title is the text of the HTTP status
+	Title string `json:"title"`
+	// Status is the HTTP status
+	Status int `json:"status"`
+	// Detail is the explanation of this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+	// Instance is the path of the request
+	Instance string `json:"instance,omitempty"`
+	// Code is the stable, machine-readable code of the problem
+	Code string `json:"code"`
+	// Errors are the rules broken by the vehicles, for vehicle_invalid
+	Errors []VehicleFieldErrorJSON `json:"errors,omitempty"`
+	// ExistingIds are the ids already present, for vehicle_already_exists on batches
+	ExistingIds []int `json:"existing_ids,omitempty"`
+	// DuplicatedIds are the ids repeated within the batch, for vehicle_already_exists on batches
+	DuplicatedIds []int `json:"duplicated_ids,omitempty"`
+	// ExistingRegistrations are the registrations held by other vehicles, for registration_already_exists
+	ExistingRegistrations []string `json:"existing_registrations,omitempty"`
+	// DuplicatedRegistrations are the registrations repeated within the batch, for registration_already_exists
+	DuplicatedRegistrations []string `json:"duplicated_registrations,omitempty"`
+}
+
+// VehicleFieldErrorJSON is a struct that represents a rule broken by a field of a vehicle in JSON format
+type VehicleFieldErrorJSON struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Envelope is a struct that represents the body of every successful response
+type Envelope struct {
+	// Message is the human readable description of the result
+	Message string `json:"message"`
+	// Data is the result
+	Data any `json:"data"`
+	// Meta is the metadata of the result, e.g. pagination
+	Meta any `json:"meta,omitempty"`
+}
+
+// respondEnvelope is a function that writes a successful response
+func respondEnvelope(w http.ResponseWriter, status int, e Envelope) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
+
+// respondProblem is a function that writes a problem that is not the result of an error, e.g. a malformed request
+func respondProblem(w http.ResponseWriter, r *http.Request, status int, code, detail string) {
+	writeProblem(w, Problem{Status: status, Code: code, Detail: detail, Instance: r.URL.Path})
+}
+
+// respondError is a function that writes the problem the error maps to
+// - the error is mapped after the first sentinel of problemMappings it wraps
+// - validation, batch conflict and registration conflict errors carry their details as extension members
+// - server errors are logged, internal ones and errors wrapping no known sentinel are written without their message
+func respondError(w http.ResponseWriter, r *http.Request, err error) {
+	writeProblem(w, newProblem(r, err))
+}
+
+// newProblem is a function that returns the problem the error maps to, see respondError
+func newProblem(r *http.Request, err error) (p Problem) {
+	p = Problem{Status: http.StatusInternalServerError, Code: CodeInternal, Detail: internalProblemDetail, Instance: r.URL.Path}
+	for _, m := range problemMappings {
+		if errors.Is(err, m.err) {
+			p.Status, p.Code, p.Detail = m.status, m.code, err.Error()
+			if p.Status >= http.StatusInternalServerError {
+				p.Detail = m.detail
+			}
+			break
+		}
+	}
+
+	// the error of a server error may expose internals (paths, queries), it is only logged
+	if p.Status >= http.StatusInternalServerError {
+		if p.Detail == "" {
+			p.Detail = internalProblemDetail
+		}
+		log.Printf("%s %s: %s", r.Method, r.URL.Path, err)
+	}
+
+	// extensions
+	var invalid *internal.VehicleValidationError
+	if errors.As(err, &invalid) {
+		p.Detail = internal.ErrVehicleInvalidService.Error()
+		for _, fe := range invalid.Errors {
+			p.Errors = append(p.Errors, VehicleFieldErrorJSON{Field: fe.Field, Rule: fe.Rule, Message: fe.Message})
+		}
+	}
+	var conflict *internal.VehicleConflictError
+	if errors.As(err, &conflict) {
+		p.Detail = "No vehicle was created, some ids are already present or repeated in the batch"
+		p.ExistingIds, p.DuplicatedIds = conflict.Existing, conflict.Duplicated
+	}
+	var registrationConflict *internal.VehicleRegistrationConflictError
+	if errors.As(err, &registrationConflict) {
+		p.Detail = "Some registrations are already held by other vehicles or repeated in the batch"
+		p.ExistingRegistrations, p.DuplicatedRegistrations = registrationConflict.Existing, registrationConflict.Duplicated
+	}
+
+	p.Type = problemTypePrefix + p.Code
+	p.Title = http.StatusText(p.Status)
+	return
+}
+
+// writeProblem is a function that writes the problem as application/problem+json
+func writeProblem(w http.ResponseWriter, p Problem) {
+	p.Type = problemTypePrefix + p.Code
+	p.Title = http.StatusText(p.Status)
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// NotFound is a function that handles requests to unknown routes
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	respondProblem(w, r, http.StatusNotFound, CodeRouteNotFound, "No route matches "+r.URL.Path)
+}
+
+// MethodNotAllowed is a function that handles requests with a method not supported by the route
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondProblem(w, r, http.StatusMethodNotAllowed, CodeMethodNotAllowed, r.Method+" is not supported by "+r.URL.Path)
+}
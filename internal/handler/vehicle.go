@@ -4,36 +4,104 @@ import (
 	"app/internal"
 	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bootcamp-go/web/request"
-	"github.com/bootcamp-go/web/response"
 	"github.com/go-chi/chi/v5"
 )
 
-const (
-	maxSpeed = 400.0
-	minSpeed = 0.0
-)
+// defaultPercentiles are the percentiles returned by GET /vehicles/stats when the request has none
+var defaultPercentiles = []float64{25, 75, 90, 95, 99}
 
 // VehicleJSON is a struct that represents a vehicle in JSON format
 type VehicleJSON struct {
-	ID              int     `json:"id"`
-	Brand           string  `json:"brand"`
-	Model           string  `json:"model"`
-	Registration    string  `json:"registration"`
-	Color           string  `json:"color"`
-	FabricationYear int     `json:"year"`
-	Capacity        int     `json:"passengers"`
-	MaxSpeed        float64 `json:"max_speed"`
-	FuelType        string  `json:"fuel_type"`
-	Transmission    string  `json:"transmission"`
-	Weight          float64 `json:"weight"`
-	Height          float64 `json:"height"`
-	Length          float64 `json:"length"`
-	Width           float64 `json:"width"`
+	ID              int        `json:"id"`
+	Version         int        `json:"version,omitempty"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	Brand           string     `json:"brand"`
+	Model           string     `json:"model"`
+	Registration    string     `json:"registration"`
+	Color           string     `json:"color"`
+	FabricationYear int        `json:"year"`
+	Capacity        int        `json:"passengers"`
+	MaxSpeed        float64    `json:"max_speed"`
+	FuelType        string     `json:"fuel_type"`
+	Transmission    string     `json:"transmission"`
+	Weight          float64    `json:"weight"`
+	Height          float64    `json:"height"`
+	Length          float64    `json:"length"`
+	Width           float64    `json:"width"`
+}
+
+// newVehicleJSON is a function that returns the JSON representation of a vehicle
+func newVehicleJSON(v internal.Vehicle) VehicleJSON {
+	var updatedAt, deletedAt *time.Time
+	if !v.UpdatedAt.IsZero() {
+		updatedAt = &v.UpdatedAt
+	}
+	if !v.DeletedAt.IsZero() {
+		deletedAt = &v.DeletedAt
+	}
+	return VehicleJSON{
+		ID:              v.Id,
+		Version:         v.Version,
+		UpdatedAt:       updatedAt,
+		DeletedAt:       deletedAt,
+		Brand:           v.Brand,
+		Model:           v.Model,
+		Registration:    v.Registration,
+		Color:           v.Color,
+		FabricationYear: v.FabricationYear,
+		Capacity:        v.Capacity,
+		MaxSpeed:        v.MaxSpeed,
+		FuelType:        v.FuelType,
+		Transmission:    v.Transmission,
+		Weight:          v.Weight,
+		Height:          v.Height,
+		Length:          v.Length,
+		Width:           v.Width,
+	}
+}
+
+// MetricAggregateJSON is a struct that represents the aggregates of a metric in JSON format
+type MetricAggregateJSON struct {
+	Count int     `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+// BrandAggregateJSON is a struct that represents the aggregates of a brand in JSON format
+type BrandAggregateJSON struct {
+	Brand    string              `json:"brand"`
+	Count    int                 `json:"count"`
+	MaxSpeed MetricAggregateJSON `json:"max_speed"`
+	Capacity MetricAggregateJSON `json:"passengers"`
+	Weight   MetricAggregateJSON `json:"weight"`
+}
+
+// newMetricAggregateJSON is a function that returns the JSON representation of the aggregates of a metric
+func newMetricAggregateJSON(a internal.MetricAggregate) MetricAggregateJSON {
+	return MetricAggregateJSON{Count: a.Count, Sum: a.Sum, Min: a.Min, Max: a.Max, Avg: a.Avg()}
+}
+
+// VehicleStatisticsJSON is a struct that represents the statistics of a field in JSON format
+type VehicleStatisticsJSON struct {
+	Count       int                `json:"count"`
+	Min         float64            `json:"min"`
+	Max         float64            `json:"max"`
+	Mean        float64            `json:"mean"`
+	Median      float64            `json:"median"`
+	StdDev      float64            `json:"std_dev"`
+	Percentiles map[string]float64 `json:"percentiles"`
 }
 
 type VehicleJSONBatch struct {
@@ -52,42 +120,152 @@ type VehicleDefault struct {
 }
 
 // GetAll is a method that returns a handler for the route GET /vehicles
+// - the optional query parameter filter narrows the vehicles, see parseVehicleFilter for its syntax
+// - like every list route, the vehicles are sorted and paginated, see parseVehiclePageQuery
+// - the optional query parameter as_of returns the vehicles as they were at that time, see parseAsOf,
+// it cannot be combined with filter
+// - format=csv, or Accept: text/csv, exports every vehicle as CSV in the requested order, limit and cursor
+// are ignored, see parseExportFormat
 func (h *VehicleDefault) GetAll() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// request
-		// ...
+		filter, err := parseVehicleFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		asOf, past, err := parseAsOf(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		if past && !filter.IsZero() {
+			respondError(w, r, fmt.Errorf("%w: as_of cannot be combined with filter", ErrAsOfQuery))
+			return
+		}
+		export, err := parseExportFormat(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
 
 		// process
-		// - get all vehicles
-		v, err := h.sv.FindAll()
+		// - get all vehicles, the ones matching the filter or the ones at as_of
+		var v map[int]internal.Vehicle
+		switch {
+		case past:
+			v, err = h.sv.FindAllAsOf(asOf)
+		case filter.IsZero():
+			v, err = h.sv.FindAll()
+		default:
+			v, err = h.sv.FindByFilter(filter)
+		}
 		if err != nil {
-			response.JSON(w, http.StatusInternalServerError, nil)
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		data := make(map[int]VehicleJSON)
-		for key, value := range v {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
+		w.Header().Add("Vary", "Accept")
+		if export {
+			respondCSV(w, sortVehicles(v, pq))
+			return
+		}
+		data, meta := paginateVehicles(v, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    data,
+			Meta:    meta,
+		})
+	}
+}
+
+// GetById is a method that returns a handler for the route GET /vehicles/{id}
+// - the response carries the ETag and Last-Modified of the vehicle and must be revalidated before reuse
+// - If-None-Match with a matching tag, or If-Modified-Since not older than the vehicle, returns 304 Not Modified
+// - the optional query parameter as_of returns the vehicle as it was at that time, without validators
+func (h *VehicleDefault) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+		asOf, past, err := parseAsOf(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// past version, the validators only apply to the current one
+		if past {
+			vehicle, err := h.sv.FindByIdAsOf(id, asOf)
+			if err != nil {
+				respondError(w, r, err)
+				return
 			}
+			respondEnvelope(w, http.StatusOK, Envelope{
+				Message: "success",
+				Data:    newVehicleJSON(vehicle),
+			})
+			return
+		}
+
+		// process
+		vehicle, err := h.sv.FindById(id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		setVehicleValidators(w, vehicle)
+		w.Header().Set("Cache-Control", "no-cache")
+		if notModified(r, vehicle) {
+			w.WriteHeader(http.StatusNotModified)
+			return
 		}
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success",
-			"data":    data,
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newVehicleJSON(vehicle),
+		})
+	}
+}
+
+// GetByRegistration is a method that returns a handler for the route GET /vehicles/registration/{plate}
+// - the plate is compared normalized, so "ab-123" finds "AB 123"
+func (h *VehicleDefault) GetByRegistration() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		plate := chi.URLParam(r, "plate")
+		if internal.NormalizeRegistration(plate) == "" {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid registration provided")
+			return
+		}
+
+		// process
+		vehicle, err := h.sv.FindByRegistration(plate)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		setVehicleValidators(w, vehicle)
+		w.Header().Set("Cache-Control", "no-cache")
+		if notModified(r, vehicle) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newVehicleJSON(vehicle),
 		})
 	}
 }
@@ -97,7 +275,7 @@ func (h *VehicleDefault) Create() http.HandlerFunc {
 		var body VehicleJSON
 
 		if err := request.JSON(r, &body); err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid JSON Body")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON Body")
 			return
 		}
 
@@ -122,35 +300,17 @@ func (h *VehicleDefault) Create() http.HandlerFunc {
 			},
 		}
 
-		if err := h.sv.Create(vehicle); err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehicleAlreadyExistsService):
-				fmt.Print(err.Error())
-				response.Error(w, http.StatusConflict, "Vehicle already exists")
-			}
+		if err := h.sv.Create(r.Context(), &vehicle); err != nil {
+			respondError(w, r, err)
 			return
 		}
 
-		data := VehicleJSON{
-			ID:              vehicle.Id,
-			Brand:           vehicle.Brand,
-			Model:           vehicle.Model,
-			Registration:    vehicle.Registration,
-			Color:           vehicle.Color,
-			FabricationYear: vehicle.FabricationYear,
-			Capacity:        vehicle.Capacity,
-			MaxSpeed:        vehicle.MaxSpeed,
-			FuelType:        vehicle.FuelType,
-			Transmission:    vehicle.Transmission,
-			Weight:          vehicle.Weight,
-			Height:          vehicle.Height,
-			Length:          vehicle.Length,
-			Width:           vehicle.Width,
-		}
-
-		response.JSON(w, http.StatusCreated, map[string]any{
-			"Message": "successful vehicle creation",
-			"Data":    data,
+		// the id may have been assigned by the repository
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.Itoa(vehicle.Id))
+		setVehicleValidators(w, vehicle)
+		respondEnvelope(w, http.StatusCreated, Envelope{
+			Message: "successful vehicle creation",
+			Data:    newVehicleJSON(vehicle),
 		})
 
 	}
@@ -160,61 +320,48 @@ func (h *VehicleDefault) Create() http.HandlerFunc {
 func (h *VehicleDefault) GetByColorAndYear() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
 		color := chi.URLParam(r, "color")
 		yearString := chi.URLParam(r, "year")
 
 		if color == "" {
-			response.Error(w, http.StatusBadRequest, "Color cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Color cannot be empty")
 			return
 		}
 
 		if yearString == "" {
-			response.Error(w, http.StatusBadRequest, "Year cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Year cannot be empty")
 			return
 		}
 
 		year, err := strconv.Atoi(yearString)
 
 		if err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid year provided")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid year provided")
 			return
 		}
 
-		vehicles, err := h.sv.GetByColorAndYear(color, year)
+		vehicles, err := h.findByCriteria(internal.VehicleFilter{Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+			{Field: "color", Operator: internal.FilterEq, Values: []any{color}},
+			{Field: "year", Operator: internal.FilterEq, Values: []any{year}},
+		}})
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		data := make(map[int]VehicleJSON)
-
-		for key, value := range vehicles {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
-			}
-		}
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicles by color and year",
-			"data":    data,
+		data, meta := paginateVehicles(vehicles, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicles by color and year",
+			Data:    data,
+			Meta:    meta,
 		})
 	}
 }
@@ -222,74 +369,62 @@ func (h *VehicleDefault) GetByColorAndYear() http.HandlerFunc {
 func (h *VehicleDefault) GetByBrandBetweenYears() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
 		brand := chi.URLParam(r, "brand")
 		yearStartString := chi.URLParam(r, "start_year")
 		yearEndString := chi.URLParam(r, "end_year")
 
 		if brand == "" {
-			response.Error(w, http.StatusBadRequest, "Brand cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Brand cannot be empty")
 			return
 		}
 
 		if yearStartString == "" {
-			response.Error(w, http.StatusBadRequest, "Start year cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Start year cannot be empty")
 			return
 		}
 
 		if yearEndString == "" {
-			response.Error(w, http.StatusBadRequest, "End year cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "End year cannot be empty")
 			return
 		}
 
 		yearStart, err := strconv.Atoi(yearStartString)
 
 		if err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid start year provided")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid start year provided")
 			return
 		}
 
 		yearEnd, err := strconv.Atoi(yearEndString)
 
 		if err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid end year provided")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid end year provided")
 			return
 		}
 
-		vehicles, err := h.sv.GetByBrandBetweenYears(brand, yearStart, yearEnd)
+		vehicles, err := h.findByCriteria(internal.VehicleFilter{Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+			{Field: "brand", Operator: internal.FilterEq, Values: []any{brand}},
+			{Field: "year", Operator: internal.FilterGte, Values: []any{yearStart}},
+			{Field: "year", Operator: internal.FilterLte, Values: []any{yearEnd}},
+		}})
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		data := make(map[int]VehicleJSON)
-
-		for key, value := range vehicles {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
-			}
-		}
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicles by brand between years",
-			"data":    data,
+		data, meta := paginateVehicles(vehicles, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicles by brand between years",
+			Data:    data,
+			Meta:    meta,
 		})
 	}
 }
@@ -300,26 +435,22 @@ func (h *VehicleDefault) GetSpeedAvgByBrand() http.HandlerFunc {
 		brand := chi.URLParam(r, "brand")
 
 		if brand == "" {
-			response.Error(w, http.StatusBadRequest, "Brand cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Brand cannot be empty")
 			return
 		}
 
 		avg, err := h.sv.GetSpeedAvgByBrand(brand)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
 
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicle's speed average by brand",
-			"Average": avg,
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicle's speed average by brand",
+			Data:    map[string]any{"brand": brand, "average_speed": avg},
 		})
 	}
 }
@@ -329,11 +460,11 @@ func (h *VehicleDefault) CreateMultiple() http.HandlerFunc {
 		var body VehicleJSONBatch
 
 		if err := request.JSON(r, &body); err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid JSON Body")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON Body")
 			return
 		}
 
-		var vehicles = make(map[int]internal.Vehicle)
+		var vehicles = make([]internal.Vehicle, 0, len(body.Vehicles))
 
 		for _, value := range body.Vehicles {
 			vehicle := internal.Vehicle{
@@ -357,42 +488,24 @@ func (h *VehicleDefault) CreateMultiple() http.HandlerFunc {
 				},
 			}
 
-			vehicles[vehicle.Id] = vehicle
+			vehicles = append(vehicles, vehicle)
 		}
 
-		if err := h.sv.CreateMultiple(vehicles); err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehicleAlreadyExistsService):
-				fmt.Print(err.Error())
-				response.Error(w, http.StatusConflict, err.Error())
-			}
+		if err := h.sv.CreateMultiple(r.Context(), vehicles); err != nil {
+			respondError(w, r, err)
 			return
 		}
 
 		var data = make(map[int]VehicleJSON)
 
-		for key, value := range vehicles {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
-			}
+		// keyed by the ids the vehicles were stored with
+		for _, value := range vehicles {
+			data[value.Id] = newVehicleJSON(value)
 		}
 
-		response.JSON(w, http.StatusCreated, map[string]any{
-			"Message": "successful multiple vehicle creation",
-			"Data":    data,
+		respondEnvelope(w, http.StatusCreated, Envelope{
+			Message: "successful multiple vehicle creation",
+			Data:    data,
 		})
 
 	}
@@ -402,6 +515,12 @@ func (h *VehicleDefault) CreateMultiple() http.HandlerFunc {
 func (h *VehicleDefault) ListByWeightRange() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
 		minWeightStr := r.URL.Query().Get("weight_min")
 		maxWeightStr := r.URL.Query().Get("weight_max")
 
@@ -413,7 +532,7 @@ func (h *VehicleDefault) ListByWeightRange() http.HandlerFunc {
 			minWeight, err = strconv.ParseFloat(minWeightStr, 64)
 
 			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid min weight provided")
+				respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid min weight provided")
 				return
 			}
 		}
@@ -423,46 +542,32 @@ func (h *VehicleDefault) ListByWeightRange() http.HandlerFunc {
 			maxWeight, err = strconv.ParseFloat(maxWeightStr, 64)
 
 			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid max weight provided")
+				respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid max weight provided")
 				return
 			}
 		}
 
-		vehicles, err := h.sv.ListByWeightRange(minWeight, maxWeight)
+		// a max weight of 0 leaves the range unbounded
+		filter := internal.VehicleFilter{Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+			{Field: "weight", Operator: internal.FilterGte, Values: []any{minWeight}},
+		}}
+		if maxWeight != 0 {
+			filter.Filters = append(filter.Filters, internal.VehicleFilter{Field: "weight", Operator: internal.FilterLte, Values: []any{maxWeight}})
+		}
+
+		vehicles, err := h.findByCriteria(filter)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		data := make(map[int]VehicleJSON)
-
-		for key, value := range vehicles {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
-			}
-		}
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicles by weight range",
-			"data":    data,
+		data, meta := paginateVehicles(vehicles, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicles by weight range",
+			Data:    data,
+			Meta:    meta,
 		})
 	}
 }
@@ -470,146 +575,105 @@ func (h *VehicleDefault) ListByWeightRange() http.HandlerFunc {
 func (h *VehicleDefault) ListByDimensions() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		lengthStr := r.URL.Query().Get("length")
-		widthStr := r.URL.Query().Get("width")
-
-		var minLength float64
-		var maxLength float64
-		var minWidth float64
-		var maxWidth float64
-
-		var minLengthStr string
-		var maxLengthStr string
-		var minWidthStr string
-		var maxWidthStr string
-
-		if lengthStr != "" {
-			minLengthStr = strings.Split(lengthStr, "-")[0]
-			maxLengthStr = strings.Split(lengthStr, "-")[1]
-		}
-
-		if widthStr != "" {
-			minWidthStr = strings.Split(widthStr, "-")[0]
-			maxWidthStr = strings.Split(widthStr, "-")[1]
-		}
-
-		if minLengthStr != "" {
-			var err error
-			minLength, err = strconv.ParseFloat(minLengthStr, 64)
-
-			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid min length provided")
-				return
-			}
-		}
-
-		if maxLengthStr != "" {
-			var err error
-			maxLength, err = strconv.ParseFloat(maxLengthStr, 64)
-
-			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid max length provided")
-				return
-			}
-		}
-
-		if minWidthStr != "" {
-			var err error
-			minWidth, err = strconv.ParseFloat(minWidthStr, 64)
-
-			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid min width provided")
-				return
-			}
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
 		}
 
-		if maxWidthStr != "" {
-			var err error
-			maxWidth, err = strconv.ParseFloat(maxWidthStr, 64)
-
+		// a bound left out leaves the range open
+		filter := internal.VehicleFilter{Logic: internal.FilterAnd}
+		for _, field := range []string{"length", "width"} {
+			f, err := parseVehicleRange(field, r.URL.Query().Get(field))
 			if err != nil {
-				response.Error(w, http.StatusBadRequest, "Invalid max width provided")
+				respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, err.Error())
 				return
 			}
+			filter.Filters = append(filter.Filters, f...)
 		}
 
-		dimensions := make(map[string]float64)
-
-		dimensions["min_length"] = minLength
-		dimensions["max_length"] = maxLength
-		dimensions["min_width"] = minWidth
-		dimensions["max_width"] = maxWidth
-
-		vehicles, err := h.sv.ListByDimensions(dimensions)
+		vehicles, err := h.findByCriteria(filter)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		data := make(map[int]VehicleJSON)
-
-		for key, value := range vehicles {
-			data[key] = VehicleJSON{
-				ID:              value.Id,
-				Brand:           value.Brand,
-				Model:           value.Model,
-				Registration:    value.Registration,
-				Color:           value.Color,
-				FabricationYear: value.FabricationYear,
-				Capacity:        value.Capacity,
-				MaxSpeed:        value.MaxSpeed,
-				FuelType:        value.FuelType,
-				Transmission:    value.Transmission,
-				Weight:          value.Weight,
-				Height:          value.Height,
-				Length:          value.Length,
-				Width:           value.Width,
-			}
-		}
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicles by given dimensions",
-			"data":    data,
+		data, meta := paginateVehicles(vehicles, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicles by given dimensions",
+			Data:    data,
+			Meta:    meta,
 		})
 	}
 }
 
+// parseVehicleRange is a function that returns the filters of a "min-max" range of the field, e.g. length=3-5
+// - either bound may be left out, e.g. "3-" or "-5", and an empty range returns no filter
+func parseVehicleRange(field string, s string) (f []internal.VehicleFilter, err error) {
+	if s == "" {
+		return
+	}
+	minStr, maxStr, ok := strings.Cut(s, "-")
+	if !ok {
+		return nil, fmt.Errorf("Invalid %s range provided, expected min-max", field)
+	}
+
+	bounds := []struct {
+		name     string
+		value    string
+		operator internal.FilterOperator
+	}{
+		{name: "min", value: minStr, operator: internal.FilterGte},
+		{name: "max", value: maxStr, operator: internal.FilterLte},
+	}
+	for _, b := range bounds {
+		if b.value == "" {
+			continue
+		}
+		value, e := strconv.ParseFloat(b.value, 64)
+		if e != nil {
+			return nil, fmt.Errorf("Invalid %s %s provided", b.name, field)
+		}
+		f = append(f, internal.VehicleFilter{Field: field, Operator: b.operator, Values: []any{value}})
+	}
+	return
+}
+
 func (h *VehicleDefault) Update() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		idStr := chi.URLParam(r, "id")
 
 		if idStr == "" {
-			response.Error(w, http.StatusBadRequest, "id cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "id cannot be empty")
 			return
 		}
 
 		id, err := strconv.Atoi(idStr)
 
 		if err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid id provided")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
 			return
 		}
 
 		var body VehicleJSON
 
 		if err := request.JSON(r, &body); err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid JSON Body")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON Body")
 			return
 		}
 
-		if body.MaxSpeed <= minSpeed || body.MaxSpeed > maxSpeed {
-			response.Error(w, http.StatusBadRequest, "Invalid max speed provided")
+		version, err := h.preconditions(r, id)
+		if err != nil {
+			respondError(w, r, err)
 			return
 		}
 
 		vehicle := internal.Vehicle{
-			Id: id,
+			Id:      id,
+			Version: version,
 			VehicleAttributes: internal.VehicleAttributes{
 				Brand:           body.Brand,
 				Model:           body.Model,
@@ -629,23 +693,81 @@ func (h *VehicleDefault) Update() http.HandlerFunc {
 			},
 		}
 
-		if err := h.sv.Update(&vehicle); err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehicleNotFoundService):
-				fmt.Print(err.Error())
-				response.Error(w, http.StatusNotFound, "Vehicle not found")
-			}
+		if err := h.sv.Update(r.Context(), &vehicle); err != nil {
+			respondError(w, r, err)
 			return
 		}
 
-		response.JSON(w, http.StatusOK, map[string]any{
-			"Message": "successful vehicle speed update",
+		setVehicleValidators(w, vehicle)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "successful vehicle speed update",
+			Data:    newVehicleJSON(vehicle),
 		})
 
 	}
 
 }
 
+// Patch is a method that returns a handler for the route PATCH /vehicles/{id}
+// - the body is a JSON Merge Patch (application/merge-patch+json) or a JSON Patch (application/json-patch+json)
+// - the patch is applied to the stored vehicle and the result is validated by the service before it is saved
+// - the vehicle is saved only if it was not modified since it was read, If-Match and If-Unmodified-Since are honoured
+func (h *VehicleDefault) Patch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || (mediaType != mediaTypeMergePatch && mediaType != mediaTypeJSONPatch) {
+			respondProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType,
+				fmt.Sprintf("Content-Type must be %s or %s", mediaTypeMergePatch, mediaTypeJSONPatch))
+			return
+		}
+		patch, err := io.ReadAll(r.Body)
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid body")
+			return
+		}
+
+		// process
+		vehicle, err := h.sv.FindById(id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		if _, err = checkPreconditions(r, vehicle); err != nil {
+			respondError(w, r, err)
+			return
+		}
+		vehicle, err = patchVehicle(vehicle, mediaType, patch)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		if err = h.sv.Update(r.Context(), &vehicle); err != nil {
+			// without a precondition of the client, a concurrent change is a conflict to retry rather than a failed precondition
+			if errors.Is(err, internal.ErrVehicleVersionConflict) && !hasPreconditions(r) {
+				respondProblem(w, r, http.StatusConflict, CodeVersionConflict, "Vehicle was modified by another request, retry the patch")
+				return
+			}
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		setVehicleValidators(w, vehicle)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "successful vehicle patch",
+			Data:    newVehicleJSON(vehicle),
+		})
+	}
+}
+
+// Delete is a method that returns a handler for the route DELETE /vehicles/{id}
+// - the vehicle is moved to the trash, from where it can be restored until it is purged
 func (h *VehicleDefault) Delete() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
@@ -653,25 +775,25 @@ func (h *VehicleDefault) Delete() http.HandlerFunc {
 		id, err := strconv.Atoi(idStr)
 
 		if err != nil {
-			response.Error(w, http.StatusBadRequest, "Invalid id provided")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		version, err := h.preconditions(r, id)
+		if err != nil {
+			respondError(w, r, err)
 			return
 		}
 
-		err = h.sv.Delete(id)
+		err = h.sv.Delete(r.Context(), id, version)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehicleNotFoundService):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "Vehicle not found")
-			}
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		response.JSON(w, http.StatusNoContent, map[string]any{
-			"message": "successful deletion",
-		})
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
@@ -681,25 +803,274 @@ func (h *VehicleDefault) GetAverageCapacityByBrand() http.HandlerFunc {
 		brand := chi.URLParam(r, "brand")
 
 		if brand == "" {
-			response.Error(w, http.StatusBadRequest, "Brand cannot be empty")
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Brand cannot be empty")
 			return
 		}
 
 		average, err := h.sv.GetAverageCapacityByBrand(brand)
 
 		if err != nil {
-			switch {
-			case errors.Is(err, internal.ErrVehiclesNotFoundByCriteria):
-				fmt.Println(err.Error())
-				response.Error(w, http.StatusNotFound, "No vehicles found with the given criteria")
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicle average by brand",
+			Data:    map[string]any{"brand": brand, "average_capacity": average},
+		})
+	}
+}
+
+// GetBrandAggregates is a method that returns a handler for the route GET /vehicles/brands/stats
+func (h *VehicleDefault) GetBrandAggregates() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		aggregates, err := h.sv.GetBrandAggregates()
+
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response (sorted by brand)
+		data := make([]BrandAggregateJSON, 0, len(aggregates))
+		for _, value := range aggregates {
+			data = append(data, BrandAggregateJSON{
+				Brand:    value.Brand,
+				Count:    value.Count,
+				MaxSpeed: newMetricAggregateJSON(value.MaxSpeed),
+				Capacity: newMetricAggregateJSON(value.Capacity),
+				Weight:   newMetricAggregateJSON(value.Weight),
+			})
+		}
+		sort.Slice(data, func(i, j int) bool {
+			return data[i].Brand < data[j].Brand
+		})
+
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning aggregates by brand",
+			Data:    data,
+		})
+	}
+}
+
+// GetStatistics is a method that returns a handler for the route GET /vehicles/stats
+// - the optional query parameter filter selects the vehicles described, see parseVehicleFilter for its syntax
+// - the optional query parameter percentiles is a comma separated list of percentiles between 0 and 100
+func (h *VehicleDefault) GetStatistics() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		filter, err := parseVehicleFilter(r.URL.Query().Get("filter"))
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		percentiles := defaultPercentiles
+		if s := r.URL.Query().Get("percentiles"); s != "" {
+			percentiles = nil
+			for _, item := range strings.Split(s, ",") {
+				p, err := strconv.ParseFloat(strings.TrimSpace(item), 64)
+				if err != nil {
+					respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid percentile provided")
+					return
+				}
+				percentiles = append(percentiles, p)
 			}
+		}
+
+		statistics, err := h.sv.GetStatistics(filter, percentiles)
+
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		data := make(map[string]VehicleStatisticsJSON, len(statistics))
+		count := 0
+		for field, value := range statistics {
+			item := VehicleStatisticsJSON{
+				Count:       value.Count,
+				Min:         value.Min,
+				Max:         value.Max,
+				Mean:        value.Mean,
+				Median:      value.Median,
+				StdDev:      value.StdDev,
+				Percentiles: make(map[string]float64, len(value.Percentiles)),
+			}
+			for p, pv := range value.Percentiles {
+				item.Percentiles["p"+strconv.FormatFloat(p, 'f', -1, 64)] = pv
+			}
+			data[field] = item
+			count = value.Count
+		}
+
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicle statistics",
+			Data:    data,
+			Meta:    map[string]any{"count": count},
+		})
+	}
+}
+
+// Aggregate is a method that returns a handler for the route GET /vehicles/aggregate
+// - group_by is a comma separated list of fields, e.g. brand,fuel_type (decade is derived from year)
+// - metrics is a comma separated list of count(), sum(f), avg(f), min(f) and max(f) (default count())
+// - filter selects the vehicles aggregated, see parseVehicleFilter for its syntax
+// - format is table (default), a row per group, or nested, groups nested by each group by field
+func (h *VehicleDefault) Aggregate() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		query := r.URL.Query()
+
+		filter, err := parseVehicleFilter(query.Get("filter"))
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		aggregation := internal.VehicleAggregation{Filter: filter}
+		if s := query.Get("group_by"); s != "" {
+			for _, name := range strings.Split(s, ",") {
+				aggregation.GroupBy = append(aggregation.GroupBy, strings.TrimSpace(name))
+			}
+		}
+		metrics := query.Get("metrics")
+		if metrics == "" {
+			metrics = "count()"
+		}
+		for _, item := range strings.Split(metrics, ",") {
+			function, field, ok := strings.Cut(strings.TrimSpace(item), "(")
+			if !ok || !strings.HasSuffix(field, ")") {
+				respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, fmt.Sprintf("Invalid metric provided: %q", item))
+				return
+			}
+			aggregation.Metrics = append(aggregation.Metrics, internal.VehicleMetric{
+				Function: internal.AggregateFunction(function),
+				Field:    strings.TrimSpace(strings.TrimSuffix(field, ")")),
+			})
+		}
+
+		format := query.Get("format")
+		if format != "" && format != "table" && format != "nested" {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid format provided, it must be table or nested")
+			return
+		}
+
+		rows, err := h.sv.Aggregate(aggregation)
+
+		if err != nil {
+			respondError(w, r, err)
 			return
 		}
 
 		// response
-		response.JSON(w, http.StatusOK, map[string]any{
-			"message": "success, returning vehicle average by brand",
-			"Average": average,
+		var data any
+		switch format {
+		case "nested":
+			// group values as nested keys, metrics by name at the leaves
+			nested := make(map[string]any)
+			for _, row := range rows {
+				level := nested
+				for _, value := range row.Group {
+					key := fmt.Sprint(value)
+					next, ok := level[key].(map[string]any)
+					if !ok {
+						next = make(map[string]any)
+						level[key] = next
+					}
+					level = next
+				}
+				for i, m := range aggregation.Metrics {
+					level[m.String()] = row.Values[i]
+				}
+			}
+			data = nested
+		default:
+			// a row per group, keyed by group by field and metric name
+			table := make([]map[string]any, 0, len(rows))
+			for _, row := range rows {
+				item := make(map[string]any, len(row.Group)+len(row.Values))
+				for i, name := range aggregation.GroupBy {
+					item[name] = row.Group[i]
+				}
+				for i, m := range aggregation.Metrics {
+					item[m.String()] = row.Values[i]
+				}
+				table = append(table, item)
+			}
+			data = table
+		}
+
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success, returning vehicle aggregation",
+			Data:    data,
 		})
 	}
 }
+
+// GetTrash is a method that returns a handler for the route GET /vehicles/trash
+// - the deleted vehicles are sorted and paginated like every list route, see parseVehiclePageQuery
+func (h *VehicleDefault) GetTrash() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		pq, err := parseVehiclePageQuery(r)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// process
+		v, err := h.sv.FindDeleted()
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		data, meta := paginateVehicles(v, pq)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    data,
+			Meta:    meta,
+		})
+	}
+}
+
+// Restore is a method that returns a handler for the route POST /vehicles/{id}/restore
+func (h *VehicleDefault) Restore() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		// process
+		vehicle, err := h.sv.Restore(r.Context(), id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		setVehicleValidators(w, vehicle)
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "successful vehicle restore",
+			Data:    newVehicleJSON(vehicle),
+		})
+	}
+}
+
+// findByCriteria is a method that returns the vehicles matching the filter of a specialised endpoint
+// - no match is ErrVehiclesNotFoundByCriteria, as those endpoints answer 404 rather than an empty page
+func (h *VehicleDefault) findByCriteria(f internal.VehicleFilter) (v map[int]internal.Vehicle, err error) {
+	v, err = h.sv.FindByFilter(f)
+	if err == nil && len(v) == 0 {
+		err = internal.ErrVehiclesNotFoundByCriteria
+	}
+	return
+}
@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrAsOfQuery is returned when the as_of query parameter is invalid
+var ErrAsOfQuery = errors.New("invalid as_of")
+
+// parseAsOf is a function that parses the query parameter as_of, the time the vehicles are read at
+// - as_of is an RFC 3339 time, ok is false when the request has none and the current vehicles are read
+// - a time in the future is the same as now
+func parseAsOf(r *http.Request) (t time.Time, ok bool, err error) {
+	s := r.URL.Query().Get("as_of")
+	if s == "" {
+		return
+	}
+
+	t, err = time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, false, fmt.Errorf("%w: as_of must be an RFC 3339 time", ErrAsOfQuery)
+	}
+	return t, true, nil
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"app/internal"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed is returned when the If-Match or If-Unmodified-Since header does not hold for the stored vehicle
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// vehicleETag is a function that returns the entity tag of the vehicle, derived from its id and version
+func vehicleETag(v internal.Vehicle) string {
+	return fmt.Sprintf(`"%d-%d"`, v.Id, v.Version)
+}
+
+// setVehicleValidators is a function that sets the ETag and Last-Modified headers of the vehicle
+// - Last-Modified is omitted when the modification time of the vehicle is unknown
+func setVehicleValidators(w http.ResponseWriter, v internal.Vehicle) {
+	w.Header().Set("ETag", vehicleETag(v))
+	if !v.UpdatedAt.IsZero() {
+		w.Header().Set("Last-Modified", v.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+}
+
+// matchETag is a function that returns whether the etag is listed in the value of an If-Match or If-None-Match header
+// - "*" matches any etag
+// - with weak comparison the W/ prefix is ignored (If-None-Match), with strong comparison weak tags never match (If-Match)
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// modifiedSince is a function that returns whether the vehicle was modified after the HTTP date
+// - Last-Modified has a resolution of one second, so the modification time is truncated before comparing
+// - a vehicle with an unknown modification time is always taken as modified
+func modifiedSince(v internal.Vehicle, date time.Time) bool {
+	if v.UpdatedAt.IsZero() {
+		return true
+	}
+	return v.UpdatedAt.Truncate(time.Second).After(date)
+}
+
+// notModified is a function that evaluates the If-None-Match and If-Modified-Since headers of a read (RFC 9110)
+// - If-Modified-Since is only considered when the request has no If-None-Match
+func notModified(r *http.Request, v internal.Vehicle) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		return matchETag(header, vehicleETag(v), true)
+	}
+	if header := r.Header.Get("If-Modified-Since"); header != "" {
+		date, err := http.ParseTime(header)
+		return err == nil && !modifiedSince(v, date)
+	}
+	return false
+}
+
+// hasPreconditions is a function that returns whether the request has an If-Match or If-Unmodified-Since header
+func hasPreconditions(r *http.Request) bool {
+	return r.Header.Get("If-Match") != "" || r.Header.Get("If-Unmodified-Since") != ""
+}
+
+// checkPreconditions is a function that evaluates the If-Match and If-Unmodified-Since headers of a write (RFC 9110)
+// - If-Unmodified-Since is only considered when the request has no If-Match, an invalid date is ignored
+// - it returns the version the write must be conditioned on: 0 when the request has no precondition
+func checkPreconditions(r *http.Request, v internal.Vehicle) (version int, err error) {
+	if header := r.Header.Get("If-Match"); header != "" {
+		if !matchETag(header, vehicleETag(v), false) {
+			return 0, fmt.Errorf("%w: vehicle %d is at version %d", ErrPreconditionFailed, v.Id, v.Version)
+		}
+		return v.Version, nil
+	}
+	if header := r.Header.Get("If-Unmodified-Since"); header != "" {
+		date, err := http.ParseTime(header)
+		if err != nil {
+			return 0, nil
+		}
+		if modifiedSince(v, date) {
+			return 0, fmt.Errorf("%w: vehicle %d was modified after %s", ErrPreconditionFailed, v.Id, header)
+		}
+		return v.Version, nil
+	}
+	return 0, nil
+}
+
+// preconditions is a method that evaluates the If-Match and If-Unmodified-Since headers against the stored vehicle
+// - the vehicle is only looked up when the request has one of those headers
+func (h *VehicleDefault) preconditions(r *http.Request, id int) (version int, err error) {
+	if !hasPreconditions(r) {
+		return 0, nil
+	}
+	v, err := h.sv.FindById(id)
+	if err != nil {
+		return
+	}
+	return checkPreconditions(r, v)
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"app/internal"
+	"app/internal/loader"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// mediaTypeCSV is the media type of the vehicles exported and imported as CSV (RFC 4180)
+	mediaTypeCSV = "text/csv"
+	// maxImportSize is the maximum size in bytes of the body of an import
+	maxImportSize = 10 << 20
+	// importFormField is the field holding the file in multipart/form-data imports
+	importFormField = "file"
+)
+
+// ErrFormatQuery is returned when the format query parameter is invalid
+var ErrFormatQuery = errors.New("invalid format")
+
+// errImportMediaType is returned when the body of an import is neither a CSV file nor a form
+var errImportMediaType = fmt.Errorf("Content-Type must be %s or multipart/form-data", mediaTypeCSV)
+
+// vehicleCSV reads and writes the vehicles exported and imported as CSV
+var vehicleCSV = loader.NewVehicleCSV(nil)
+
+// VehicleImportRowJSON is a struct that represents the result of importing a row in JSON format
+type VehicleImportRowJSON struct {
+	// Line is the line of the file the row starts at
+	Line int `json:"line"`
+	// ID is the id the vehicle was created with, 0 when the row failed
+	ID int `json:"id,omitempty"`
+	// Error is why the row was not imported, nil when the vehicle was created
+	Error *Problem `json:"error,omitempty"`
+}
+
+// VehicleImportMeta is a struct that represents the summary of an import
+type VehicleImportMeta struct {
+	// Rows is the number of rows read, without the header
+	Rows int `json:"rows"`
+	// Created is the number of vehicles created
+	Created int `json:"created"`
+	// Failed is the number of rows not imported
+	Failed int `json:"failed"`
+}
+
+// parseExportFormat is a function that returns whether the vehicles are requested as CSV
+// - the query parameter format is csv or json, without it the Accept header is honoured
+func parseExportFormat(r *http.Request) (csv bool, err error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "csv":
+		return true, nil
+	case "json":
+		return false, nil
+	case "":
+	default:
+		return false, fmt.Errorf("%w: format must be csv or json, got %q", ErrFormatQuery, format)
+	}
+
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, e := mime.ParseMediaType(strings.TrimSpace(accept))
+		if e == nil && mediaType == mediaTypeCSV {
+			return true, nil
+		}
+	}
+	return
+}
+
+// respondCSV is a function that writes the vehicles as a CSV attachment
+func respondCSV(w http.ResponseWriter, v []internal.Vehicle) {
+	w.Header().Set("Content-Type", mediaTypeCSV+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="vehicles.csv"`)
+	w.WriteHeader(http.StatusOK)
+	if err := vehicleCSV.Write(w, v); err != nil {
+		// the status is already sent, the client sees a truncated file
+		log.Printf("write vehicles csv: %s", err)
+	}
+}
+
+// Import is a method that returns a handler for the route POST /vehicles/import
+// - the body is a CSV file (text/csv) or a form (multipart/form-data) with the file in the field "file",
+// its columns are mapped by the header, see loader.VehicleCSV
+// - each row is created on its own like POST /vehicles, ids left empty are assigned and the version,
+// updated_at and deleted_at columns are ignored
+// - an invalid row does not stop the import, the result of every row is returned with the problem of the failed ones
+func (h *VehicleDefault) Import() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		file, err := importFile(r)
+		if err == nil {
+			defer file.Close()
+		}
+		var rows []loader.VehicleCSVRow
+		if err == nil {
+			rows, err = vehicleCSV.Read(file)
+		}
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			respondProblem(w, r, http.StatusRequestEntityTooLarge, CodeInvalidRequest,
+				fmt.Sprintf("The file must not exceed %d bytes", maxImportSize))
+			return
+		case errors.Is(err, errImportMediaType):
+			respondProblem(w, r, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, err.Error())
+			return
+		case err != nil:
+			respondError(w, r, err)
+			return
+		}
+
+		// process
+		// - create the vehicles row by row, a failed row is reported and skipped
+		data := make([]VehicleImportRowJSON, 0, len(rows))
+		meta := VehicleImportMeta{Rows: len(rows)}
+		for _, row := range rows {
+			result := VehicleImportRowJSON{Line: row.Line}
+			err := row.Err
+			if err == nil {
+				vehicle := internal.Vehicle{Id: row.Vehicle.Id, VehicleAttributes: row.Vehicle.VehicleAttributes}
+				err = h.sv.Create(r.Context(), &vehicle)
+				result.ID = vehicle.Id
+			}
+			if err != nil {
+				p := newProblem(r, err)
+				result.ID, result.Error = 0, &p
+				meta.Failed++
+			} else {
+				meta.Created++
+			}
+			data = append(data, result)
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "vehicles import completed",
+			Data:    data,
+			Meta:    meta,
+		})
+	}
+}
+
+// importFile is a function that returns the CSV file sent in the body of an import
+func importFile(r *http.Request) (file io.ReadCloser, err error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errImportMediaType
+	}
+
+	switch mediaType {
+	case mediaTypeCSV:
+		return r.Body, nil
+	case "multipart/form-data":
+		file, _, err = r.FormFile(importFormField)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: the form must hold the CSV file in the field %q", loader.ErrVehicleCSV, importFormField)
+		}
+		return file, nil
+	default:
+		return nil, errImportMediaType
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"app/internal"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// eventKeepAlive is how often a comment is sent on an idle event stream, so proxies do not close it
+const eventKeepAlive = 15 * time.Second
+
+// eventReset is the type of the event sent when the events after Last-Event-ID are no longer buffered
+// - the client must reload the vehicles, the stream goes on from the id of the event
+const eventReset = "reset"
+
+// Events is a method that returns a handler for the route GET /vehicles/events
+// - the changes are streamed as Server-Sent Events of type created, updated or deleted, see internal.VehicleEvent,
+// with the VehicleJSON of the vehicle as data and the position in the feed as id
+// - a Last-Event-ID header, or last_event_id query parameter, resumes after that event from the buffered ones,
+// a reset event is sent first when some of them are no longer buffered
+// - the stream ends when the client falls too far behind, it is then expected to resume
+func (h *VehicleDefault) Events() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		after := -1
+		lastEventId := r.Header.Get("Last-Event-ID")
+		if lastEventId == "" {
+			lastEventId = r.URL.Query().Get("last_event_id")
+		}
+		if lastEventId != "" {
+			id, err := strconv.Atoi(lastEventId)
+			if err != nil || id < 0 {
+				respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid Last-Event-ID provided")
+				return
+			}
+			after = id
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			respondProblem(w, r, http.StatusInternalServerError, CodeInternal, "Streaming is not supported")
+			return
+		}
+
+		// process
+		sub, err := h.sv.Subscribe(after)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+		defer sub.Cancel()
+
+		// response
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+
+		// - resume
+		if sub.Missed {
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", sub.Last, eventReset)
+		}
+		for _, e := range sub.Replay {
+			if err = writeVehicleEvent(w, e); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+
+		// - follow
+		ticker := time.NewTicker(eventKeepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+				if _, err = fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case e, ok := <-sub.Events:
+				if !ok {
+					return
+				}
+				if err = writeVehicleEvent(w, e); err != nil {
+					return
+				}
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// writeVehicleEvent is a function that writes the event in the Server-Sent Events format
+func writeVehicleEvent(w http.ResponseWriter, e internal.VehicleEvent) (err error) {
+	data, err := json.Marshal(newVehicleJSON(e.Vehicle))
+	if err != nil {
+		return
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Id, e.Type, data)
+	return
+}
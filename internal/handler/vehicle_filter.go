@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"app/internal"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ErrFilterSyntax is returned when the filter query parameter cannot be parsed
+var ErrFilterSyntax = errors.New("invalid filter syntax")
+
+// parseVehicleFilter is a function that parses a filter expression into a vehicle filter
+// - grammar:
+//
+//	expression := term { "or" term }
+//	term       := factor { "and" factor }
+//	factor     := "(" expression ")" | field operator value { "," value }
+//	value      := word | "quoted text"
+//
+// - e.g. brand eq Ford and (year gte 2000 or color in Red,"Dark Blue")
+// - operators are eq, ne, lt, lte, gt, gte, in, contains and prefix; values are kept as text
+// and typed by the service after the kind of each field
+func parseVehicleFilter(expression string) (f internal.VehicleFilter, err error) {
+	tokens, err := tokenizeVehicleFilter(expression)
+	if err != nil {
+		return
+	}
+	if len(tokens) == 0 {
+		return
+	}
+
+	p := &vehicleFilterParser{tokens: tokens}
+	f, err = p.parseOr()
+	if err != nil {
+		return
+	}
+	if !p.done() {
+		return f, fmt.Errorf("%w: unexpected %q", ErrFilterSyntax, p.peek().text)
+	}
+	return
+}
+
+// vehicleFilterToken is a struct that represents a token of a filter expression
+type vehicleFilterToken struct {
+	// text is the text of the token, without quotes
+	text string
+	// quoted is whether the token was a quoted value, so it is never taken as a keyword
+	quoted bool
+}
+
+// is is a method that returns whether the token is the given unquoted keyword or symbol (case insensitive)
+func (t vehicleFilterToken) is(keyword string) bool {
+	return !t.quoted && strings.EqualFold(t.text, keyword)
+}
+
+// tokenizeVehicleFilter is a function that splits a filter expression into tokens
+func tokenizeVehicleFilter(expression string) (tokens []vehicleFilterToken, err error) {
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',':
+			tokens = append(tokens, vehicleFilterToken{text: string(r)})
+			i++
+		case r == '"' || r == '\'':
+			// quoted value, backslash escapes the next rune
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j == len(runes) {
+				return nil, fmt.Errorf("%w: unterminated quoted value", ErrFilterSyntax)
+			}
+			tokens = append(tokens, vehicleFilterToken{text: sb.String(), quoted: true})
+			i = j + 1
+		default:
+			j := i
+			for ; j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(`(),"'`, runes[j]); j++ {
+			}
+			tokens = append(tokens, vehicleFilterToken{text: string(runes[i:j])})
+			i = j
+		}
+	}
+	return
+}
+
+// vehicleFilterParser is a struct that represents a recursive descent parser of filter expressions
+type vehicleFilterParser struct {
+	// tokens are the tokens of the expression
+	tokens []vehicleFilterToken
+	// pos is the position of the next token
+	pos int
+}
+
+// done is a method that returns whether every token was consumed
+func (p *vehicleFilterParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peek is a method that returns the next token without consuming it
+func (p *vehicleFilterParser) peek() vehicleFilterToken {
+	if p.done() {
+		return vehicleFilterToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+// next is a method that consumes and returns the next token
+func (p *vehicleFilterParser) next() (t vehicleFilterToken, err error) {
+	if p.done() {
+		return t, fmt.Errorf("%w: unexpected end of filter", ErrFilterSyntax)
+	}
+	t = p.tokens[p.pos]
+	p.pos++
+	return
+}
+
+// parseOr is a method that parses: term { "or" term }
+func (p *vehicleFilterParser) parseOr() (f internal.VehicleFilter, err error) {
+	return p.parseGroup(internal.FilterOr, p.parseAnd)
+}
+
+// parseAnd is a method that parses: factor { "and" factor }
+func (p *vehicleFilterParser) parseAnd() (f internal.VehicleFilter, err error) {
+	return p.parseGroup(internal.FilterAnd, p.parseFactor)
+}
+
+// parseGroup is a method that parses operands separated by the logic keyword
+// - a single operand is returned as is, without wrapping it in a group
+func (p *vehicleFilterParser) parseGroup(logic internal.FilterLogic, operand func() (internal.VehicleFilter, error)) (f internal.VehicleFilter, err error) {
+	first, err := operand()
+	if err != nil {
+		return
+	}
+	if !p.peek().is(string(logic)) {
+		return first, nil
+	}
+
+	f = internal.VehicleFilter{Logic: logic, Filters: []internal.VehicleFilter{first}}
+	for p.peek().is(string(logic)) {
+		p.pos++
+		var sub internal.VehicleFilter
+		if sub, err = operand(); err != nil {
+			return
+		}
+		f.Filters = append(f.Filters, sub)
+	}
+	return
+}
+
+// parseFactor is a method that parses: "(" expression ")" | field operator value { "," value }
+func (p *vehicleFilterParser) parseFactor() (f internal.VehicleFilter, err error) {
+	// parenthesized expression
+	if p.peek().is("(") {
+		p.pos++
+		if f, err = p.parseOr(); err != nil {
+			return
+		}
+		var t vehicleFilterToken
+		if t, err = p.next(); err != nil {
+			return
+		}
+		if !t.is(")") {
+			return f, fmt.Errorf("%w: expected \")\", got %q", ErrFilterSyntax, t.text)
+		}
+		return
+	}
+
+	// condition
+	field, err := p.next()
+	if err != nil {
+		return
+	}
+	if field.quoted || field.is("(") || field.is(")") || field.is(",") {
+		return f, fmt.Errorf("%w: expected field, got %q", ErrFilterSyntax, field.text)
+	}
+	operator, err := p.next()
+	if err != nil {
+		return
+	}
+	if operator.quoted {
+		return f, fmt.Errorf("%w: expected operator after %q, got %q", ErrFilterSyntax, field.text, operator.text)
+	}
+
+	f = internal.VehicleFilter{Field: field.text, Operator: internal.FilterOperator(strings.ToLower(operator.text))}
+	for {
+		var value vehicleFilterToken
+		if value, err = p.next(); err != nil {
+			return
+		}
+		if value.is("(") || value.is(")") || value.is(",") {
+			return f, fmt.Errorf("%w: expected value for %q, got %q", ErrFilterSyntax, field.text, value.text)
+		}
+		f.Values = append(f.Values, value.text)
+
+		if !p.peek().is(",") {
+			return
+		}
+		p.pos++
+	}
+}
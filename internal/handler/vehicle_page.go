@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"app/internal"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// defaultPageLimit is the number of vehicles returned when the request has no limit
+	defaultPageLimit = 100
+	// maxPageLimit is the maximum number of vehicles returned in a single page
+	maxPageLimit = 1000
+)
+
+// ErrPageQuery is returned when the sort, limit or cursor query parameters are invalid
+var ErrPageQuery = errors.New("invalid pagination")
+
+// vehicleSortKey is a struct that represents a key used to sort vehicles
+type vehicleSortKey struct {
+	// field is the field compared
+	field internal.VehicleField
+	// desc is whether the order is descending
+	desc bool
+}
+
+// vehiclePageQuery is a struct that represents the sort and pagination requested for a list of vehicles
+type vehiclePageQuery struct {
+	// keys are the sort keys, always ending with the id so the order is total and stable
+	keys []vehicleSortKey
+	// sort is the canonical representation of keys, e.g. "year:desc,id:asc"
+	sort string
+	// limit is the maximum number of vehicles in the page
+	limit int
+	// after are the sort key values of the last vehicle of the previous page, nil for the first page
+	after []any
+}
+
+// vehicleCursor is a struct that represents the content of an opaque cursor
+type vehicleCursor struct {
+	// Sort is the canonical sort the cursor was created for
+	Sort string `json:"s"`
+	// Keys are the sort key values of the last vehicle returned
+	Keys []any `json:"k"`
+}
+
+// VehiclePageMeta is a struct that represents the pagination metadata of a list of vehicles
+type VehiclePageMeta struct {
+	// Total is the number of vehicles matching the request, across all pages
+	Total int `json:"total"`
+	// Count is the number of vehicles in this page
+	Count int `json:"count"`
+	// Limit is the maximum number of vehicles in a page
+	Limit int `json:"limit"`
+	// Sort is the order applied to the vehicles
+	Sort string `json:"sort"`
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseVehiclePageQuery is a function that parses the query parameters sort, limit and cursor
+// - sort=field[:asc|desc][,field[:asc|desc]...] with fields named as in VehicleJSON (default id:asc)
+// - limit=n between 1 and maxPageLimit (default defaultPageLimit)
+// - cursor is the next_cursor returned by the previous page, it must be used with the same sort
+func parseVehiclePageQuery(r *http.Request) (q vehiclePageQuery, err error) {
+	query := r.URL.Query()
+
+	// sort
+	hasId := false
+	if s := query.Get("sort"); s != "" {
+		for _, item := range strings.Split(s, ",") {
+			name, order, _ := strings.Cut(strings.TrimSpace(item), ":")
+			field, ok := internal.LookupVehicleField(name)
+			if !ok {
+				return q, fmt.Errorf("%w: unknown sort field %q", ErrPageQuery, name)
+			}
+			key := vehicleSortKey{field: field}
+			switch strings.ToLower(order) {
+			case "", "asc":
+			case "desc":
+				key.desc = true
+			default:
+				return q, fmt.Errorf("%w: unknown sort order %q", ErrPageQuery, order)
+			}
+			q.keys = append(q.keys, key)
+			hasId = hasId || field.Name == "id"
+		}
+	}
+	if !hasId {
+		field, _ := internal.LookupVehicleField("id")
+		q.keys = append(q.keys, vehicleSortKey{field: field})
+	}
+	parts := make([]string, 0, len(q.keys))
+	for _, key := range q.keys {
+		order := "asc"
+		if key.desc {
+			order = "desc"
+		}
+		parts = append(parts, key.field.Name+":"+order)
+	}
+	q.sort = strings.Join(parts, ",")
+
+	// limit
+	q.limit = defaultPageLimit
+	if s := query.Get("limit"); s != "" {
+		q.limit, err = strconv.Atoi(s)
+		if err != nil || q.limit < 1 || q.limit > maxPageLimit {
+			return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrPageQuery, maxPageLimit)
+		}
+	}
+
+	// cursor
+	if s := query.Get("cursor"); s != "" {
+		q.after, err = decodeVehicleCursor(s, q)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// sortVehicles is a function that returns the vehicles in the order of the sort keys
+func sortVehicles(v map[int]internal.Vehicle, q vehiclePageQuery) (sorted []internal.Vehicle) {
+	sorted = make([]internal.Vehicle, 0, len(v))
+	for _, value := range v {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return compareVehicleKeys(vehicleKeyValues(sorted[i], q), vehicleKeyValues(sorted[j], q), q) < 0
+	})
+	return
+}
+
+// paginateVehicles is a function that sorts the vehicles and returns the requested page
+func paginateVehicles(v map[int]internal.Vehicle, q vehiclePageQuery) (data []VehicleJSON, meta VehiclePageMeta) {
+	// sort
+	sorted := sortVehicles(v, q)
+
+	// skip up to the cursor
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(sorted), func(i int) bool {
+			return compareVehicleKeys(vehicleKeyValues(sorted[i], q), q.after, q) > 0
+		})
+	}
+	end := min(start+q.limit, len(sorted))
+
+	// page
+	data = make([]VehicleJSON, 0, end-start)
+	for _, value := range sorted[start:end] {
+		data = append(data, newVehicleJSON(value))
+	}
+	meta = VehiclePageMeta{
+		Total: len(sorted),
+		Count: len(data),
+		Limit: q.limit,
+		Sort:  q.sort,
+	}
+	if end < len(sorted) {
+		meta.NextCursor = encodeVehicleCursor(vehicleKeyValues(sorted[end-1], q), q)
+	}
+	return
+}
+
+// vehicleKeyValues is a function that returns the values of the sort keys for the vehicle
+func vehicleKeyValues(v internal.Vehicle, q vehiclePageQuery) []any {
+	values := make([]any, len(q.keys))
+	for i, key := range q.keys {
+		values[i] = key.field.Value(v)
+	}
+	return values
+}
+
+// compareVehicleKeys is a function that compares two lists of sort key values in the order of q
+func compareVehicleKeys(a, b []any, q vehiclePageQuery) int {
+	for i, key := range q.keys {
+		c := internal.CompareVehicleFieldValues(a[i], b[i])
+		if key.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// encodeVehicleCursor is a function that encodes the sort key values into an opaque cursor
+func encodeVehicleCursor(keys []any, q vehiclePageQuery) string {
+	bytes, _ := json.Marshal(vehicleCursor{Sort: q.sort, Keys: keys})
+	return base64.RawURLEncoding.EncodeToString(bytes)
+}
+
+// decodeVehicleCursor is a function that decodes an opaque cursor into sort key values typed after the fields of q
+func decodeVehicleCursor(s string, q vehiclePageQuery) (keys []any, err error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrPageQuery)
+	}
+	var cursor vehicleCursor
+	if err = json.Unmarshal(bytes, &cursor); err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrPageQuery)
+	}
+	if cursor.Sort != q.sort {
+		return nil, fmt.Errorf("%w: cursor was created for sort %q", ErrPageQuery, cursor.Sort)
+	}
+	if len(cursor.Keys) != len(q.keys) {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrPageQuery)
+	}
+
+	// type values after the kind of each field (json numbers decode as float64)
+	keys = make([]any, len(q.keys))
+	for i, key := range q.keys {
+		switch value := cursor.Keys[i].(type) {
+		case string:
+			if key.field.Kind == internal.VehicleFieldString {
+				keys[i] = value
+				continue
+			}
+		case float64:
+			switch key.field.Kind {
+			case internal.VehicleFieldInt:
+				keys[i] = int(value)
+				continue
+			case internal.VehicleFieldFloat:
+				keys[i] = value
+				continue
+			}
+		}
+		return nil, fmt.Errorf("%w: malformed cursor", ErrPageQuery)
+	}
+	return
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"app/internal"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// mediaTypeMergePatch is the media type of a JSON Merge Patch (RFC 7396)
+	mediaTypeMergePatch = "application/merge-patch+json"
+	// mediaTypeJSONPatch is the media type of a JSON Patch (RFC 6902)
+	mediaTypeJSONPatch = "application/json-patch+json"
+)
+
+var (
+	// ErrPatchSyntax is returned when the patch document is malformed
+	ErrPatchSyntax = errors.New("invalid patch")
+	// ErrPatchTest is returned when a test operation of a JSON Patch does not hold
+	ErrPatchTest = errors.New("patch test failed")
+	// ErrPatchResult is returned when the patched document is not a valid vehicle
+	ErrPatchResult = errors.New("invalid patched vehicle")
+)
+
+// jsonPatchOperation is a struct that represents an operation of a JSON Patch
+type jsonPatchOperation struct {
+	// Op is the operation: add, replace, remove or test
+	Op string `json:"op"`
+	// Path is the JSON Pointer to the member the operation applies to
+	Path string `json:"path"`
+	// Value is the value of add, replace and test, empty when missing
+	Value json.RawMessage `json:"value"`
+}
+
+// patchVehicle is a function that applies the patch of the given media type to the vehicle
+// - the vehicle is patched in its JSON representation, so members are named as in VehicleJSON
+// - the id and version cannot be changed, updated_at and deleted_at are managed by the repository and ignored
+// - unknown members or values of the wrong type are rejected
+func patchVehicle(v internal.Vehicle, mediaType string, patch []byte) (patched internal.Vehicle, err error) {
+	// document
+	bytesDoc, err := json.Marshal(newVehicleJSON(v))
+	if err != nil {
+		return
+	}
+	var doc map[string]any
+	if err = json.Unmarshal(bytesDoc, &doc); err != nil {
+		return
+	}
+
+	// patch
+	switch mediaType {
+	case mediaTypeMergePatch:
+		var p any
+		if err = json.Unmarshal(patch, &p); err != nil {
+			return patched, fmt.Errorf("%w: %s", ErrPatchSyntax, err.Error())
+		}
+		obj, ok := applyMergePatch(doc, p).(map[string]any)
+		if !ok {
+			return patched, fmt.Errorf("%w: a vehicle must be a JSON object", ErrPatchResult)
+		}
+		doc = obj
+	case mediaTypeJSONPatch:
+		var ops []jsonPatchOperation
+		if err = json.Unmarshal(patch, &ops); err != nil {
+			return patched, fmt.Errorf("%w: %s", ErrPatchSyntax, err.Error())
+		}
+		if err = applyJSONPatch(doc, ops); err != nil {
+			return
+		}
+	default:
+		return patched, fmt.Errorf("%w: unsupported media type %q", ErrPatchSyntax, mediaType)
+	}
+
+	// decode the patched document strictly
+	bytesDoc, err = json.Marshal(doc)
+	if err != nil {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(bytesDoc))
+	decoder.DisallowUnknownFields()
+	var body VehicleJSON
+	if err = decoder.Decode(&body); err != nil {
+		return patched, fmt.Errorf("%w: %s", ErrPatchResult, err.Error())
+	}
+
+	// validate
+	if body.ID != v.Id {
+		return patched, fmt.Errorf("%w: id cannot be changed", ErrPatchResult)
+	}
+	if body.Version != v.Version {
+		return patched, fmt.Errorf("%w: version cannot be changed", ErrPatchResult)
+	}
+
+	patched = internal.Vehicle{
+		Id:      body.ID,
+		Version: body.Version,
+		VehicleAttributes: internal.VehicleAttributes{
+			Brand:           body.Brand,
+			Model:           body.Model,
+			Registration:    body.Registration,
+			Color:           body.Color,
+			FabricationYear: body.FabricationYear,
+			Capacity:        body.Capacity,
+			MaxSpeed:        body.MaxSpeed,
+			FuelType:        body.FuelType,
+			Transmission:    body.Transmission,
+			Weight:          body.Weight,
+			Dimensions: internal.Dimensions{
+				Height: body.Height,
+				Length: body.Length,
+				Width:  body.Width,
+			},
+		},
+	}
+	return
+}
+
+// applyMergePatch is a function that applies a JSON Merge Patch to the target (RFC 7396)
+// - a null member removes the member from the target, an object is merged recursively
+// and any other value replaces the target
+func applyMergePatch(target, patch any) any {
+	p, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	t, ok := target.(map[string]any)
+	if !ok {
+		t = make(map[string]any)
+	}
+	for name, value := range p {
+		if value == nil {
+			delete(t, name)
+			continue
+		}
+		t[name] = applyMergePatch(t[name], value)
+	}
+	return t
+}
+
+// applyJSONPatch is a function that applies the operations of a JSON Patch to the document (RFC 6902)
+// - the operations are applied in order and the first failing operation aborts the patch
+// - a vehicle is a flat object, so paths must point to one of its members, e.g. /max_speed
+func applyJSONPatch(doc map[string]any, ops []jsonPatchOperation) (err error) {
+	for i, op := range ops {
+		var name string
+		name, err = parseJSONPointerMember(op.Path)
+		if err != nil {
+			return fmt.Errorf("%w: operation %d: %s", ErrPatchSyntax, i, err.Error())
+		}
+
+		var value any
+		switch op.Op {
+		case "add", "replace", "test":
+			if len(op.Value) == 0 {
+				return fmt.Errorf("%w: operation %d: %s requires a value", ErrPatchSyntax, i, op.Op)
+			}
+			if err = json.Unmarshal(op.Value, &value); err != nil {
+				return fmt.Errorf("%w: operation %d: %s", ErrPatchSyntax, i, err.Error())
+			}
+		}
+
+		current, exists := doc[name]
+		switch op.Op {
+		case "add":
+			doc[name] = value
+		case "replace":
+			if !exists {
+				return fmt.Errorf("%w: operation %d: path %q does not exist", ErrPatchSyntax, i, op.Path)
+			}
+			doc[name] = value
+		case "remove":
+			if !exists {
+				return fmt.Errorf("%w: operation %d: path %q does not exist", ErrPatchSyntax, i, op.Path)
+			}
+			delete(doc, name)
+		case "test":
+			if !exists || !reflect.DeepEqual(current, value) {
+				return fmt.Errorf("%w: operation %d: value at %q is not %s", ErrPatchTest, i, op.Path, op.Value)
+			}
+		default:
+			return fmt.Errorf("%w: operation %d: unsupported op %q", ErrPatchSyntax, i, op.Op)
+		}
+	}
+	return
+}
+
+// parseJSONPointerMember is a function that returns the member name a single-token JSON Pointer refers to
+func parseJSONPointerMember(pointer string) (name string, err error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return "", fmt.Errorf("path %q must start with \"/\"", pointer)
+	}
+	name = pointer[1:]
+	if strings.Contains(name, "/") {
+		return "", fmt.Errorf("path %q does not point to a member of the vehicle", pointer)
+	}
+	return strings.NewReplacer("~1", "/", "~0", "~").Replace(name), nil
+}
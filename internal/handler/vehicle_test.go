@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"app/internal"
+	"app/internal/repository"
+	"app/internal/service"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// newTestVehicleHandler is a function that returns a handler over vehicles 1 to 4 kept in memory
+// - their length is 3 + id and their width 1 + id / 10
+func newTestVehicleHandler() *VehicleDefault {
+	db := make(map[int]internal.Vehicle)
+	for id := 1; id <= 4; id++ {
+		db[id] = internal.Vehicle{Id: id, Version: 1, VehicleAttributes: internal.VehicleAttributes{
+			Brand: "Ford", Model: "Fiesta", Registration: "REG-" + strconv.Itoa(id), FabricationYear: 2010,
+			Dimensions: internal.Dimensions{Height: 1.5, Length: 3 + float64(id), Width: 1 + float64(id)/10},
+		}}
+	}
+	return NewVehicleDefault(service.NewVehicleDefault(repository.NewVehicleMap(db), nil, nil, nil))
+}
+
+// serveTestVehicles is a function that serves the request to the handler and returns the response and the ids of the vehicles in it
+func serveTestVehicles(t *testing.T, hd http.HandlerFunc, r *http.Request) (res *httptest.ResponseRecorder, ids []int) {
+	t.Helper()
+
+	res = httptest.NewRecorder()
+	hd(res, r)
+	if res.Code != http.StatusOK {
+		return
+	}
+	var body struct {
+		Data []VehicleJSON `json:"data"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range body.Data {
+		ids = append(ids, v.ID)
+	}
+	sort.Ints(ids)
+	return
+}
+
+func TestVehicleDefault_ListByDimensions(t *testing.T) {
+	cases := map[string]struct {
+		query  string
+		status int
+		ids    []int
+	}{
+		"both ranges":       {query: "length=4-6&width=1.1-1.2", status: http.StatusOK, ids: []int{1, 2}},
+		"length only":       {query: "length=5-7", status: http.StatusOK, ids: []int{2, 3, 4}},
+		"width only":        {query: "width=1.3-1.4", status: http.StatusOK, ids: []int{3, 4}},
+		"open max":          {query: "length=6-", status: http.StatusOK, ids: []int{3, 4}},
+		"open min":          {query: "width=-1.1", status: http.StatusOK, ids: []int{1}},
+		"no match":          {query: "length=10-20", status: http.StatusNotFound},
+		"missing separator": {query: "length=3", status: http.StatusBadRequest},
+		"single width":      {query: "width=2", status: http.StatusBadRequest},
+		"invalid bound":     {query: "length=a-5", status: http.StatusBadRequest},
+		"negative bound":    {query: "length=-2-5", status: http.StatusBadRequest},
+	}
+	hd := newTestVehicleHandler().ListByDimensions()
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			res, ids := serveTestVehicles(t, hd, httptest.NewRequest(http.MethodGet, "/vehicles/dimensions?"+c.query, nil))
+			if res.Code != c.status {
+				t.Fatalf("got status %d, want %d: %s", res.Code, c.status, res.Body)
+			}
+			if c.status == http.StatusOK && !slices.Equal(ids, c.ids) {
+				t.Errorf("got vehicles %v, want %v", ids, c.ids)
+			}
+		})
+	}
+}
+
+func TestVehicleDefault_ConditionalGet(t *testing.T) {
+	hd := newTestVehicleHandler()
+	rt := chi.NewRouter()
+	rt.Get("/vehicles/{id}", hd.GetById())
+	rt.Get("/vehicles/registration/{plate}", hd.GetByRegistration())
+
+	// both lookups of the vehicle answer a conditional request with its validators alike
+	for _, path := range []string{"/vehicles/2", "/vehicles/registration/reg-2"} {
+		res := httptest.NewRecorder()
+		rt.ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		etag := res.Header().Get("ETag")
+		if res.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: got status %d, ETag %q", path, res.Code, etag)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("If-None-Match", etag)
+		res = httptest.NewRecorder()
+		rt.ServeHTTP(res, r)
+		if res.Code != http.StatusNotModified || res.Body.Len() != 0 {
+			t.Errorf("%s: got status %d with %d bytes, want 304 without body", path, res.Code, res.Body.Len())
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package handler
+
+import (
+	"app/internal"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bootcamp-go/web/request"
+	"github.com/go-chi/chi/v5"
+)
+
+// WebhookJSON is a struct that represents a webhook in JSON format
+// - the secret is only returned when the webhook is created
+type WebhookJSON struct {
+	ID        int        `json:"id"`
+	URL       string     `json:"url"`
+	Events    []string   `json:"events"`
+	Secret    string     `json:"secret,omitempty"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+// WebhookAttemptJSON is a struct that represents an attempt of a delivery in JSON format
+type WebhookAttemptJSON struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// WebhookDeliveryJSON is a struct that represents a delivery of a webhook in JSON format
+type WebhookDeliveryJSON struct {
+	ID            int                  `json:"id"`
+	WebhookID     int                  `json:"webhook_id"`
+	EventID       int                  `json:"event_id"`
+	EventType     string               `json:"event_type"`
+	Status        string               `json:"status"`
+	Attempts      []WebhookAttemptJSON `json:"attempts"`
+	NextAttemptAt *time.Time           `json:"next_attempt_at,omitempty"`
+	CreatedAt     time.Time            `json:"created_at"`
+	Payload       json.RawMessage      `json:"payload"`
+}
+
+// VehicleEventJSON is a struct that represents a vehicle event in JSON format, the payload posted to the webhooks
+type VehicleEventJSON struct {
+	ID      int         `json:"id"`
+	Type    string      `json:"type"`
+	Time    time.Time   `json:"time"`
+	Vehicle VehicleJSON `json:"vehicle"`
+}
+
+// EncodeVehicleEvent is a function that returns the JSON payload of a vehicle event, see VehicleEventJSON
+func EncodeVehicleEvent(e internal.VehicleEvent) (payload []byte, err error) {
+	return json.Marshal(VehicleEventJSON{ID: e.Id, Type: e.Type, Time: e.Time, Vehicle: newVehicleJSON(e.Vehicle)})
+}
+
+// newWebhookJSON is a function that returns the JSON representation of a webhook, without its secret
+func newWebhookJSON(w internal.Webhook) WebhookJSON {
+	var createdAt *time.Time
+	if !w.CreatedAt.IsZero() {
+		createdAt = &w.CreatedAt
+	}
+	events := w.Events
+	if events == nil {
+		events = []string{}
+	}
+	return WebhookJSON{ID: w.Id, URL: w.URL, Events: events, CreatedAt: createdAt}
+}
+
+// newWebhookDeliveriesJSON is a function that returns the JSON representation of the deliveries
+func newWebhookDeliveriesJSON(d []internal.WebhookDelivery) (data []WebhookDeliveryJSON) {
+	data = make([]WebhookDeliveryJSON, 0, len(d))
+	for _, value := range d {
+		data = append(data, newWebhookDeliveryJSON(value))
+	}
+	return
+}
+
+// newWebhookDeliveryJSON is a function that returns the JSON representation of a delivery
+func newWebhookDeliveryJSON(d internal.WebhookDelivery) WebhookDeliveryJSON {
+	var nextAttemptAt *time.Time
+	if !d.NextAttemptAt.IsZero() {
+		nextAttemptAt = &d.NextAttemptAt
+	}
+	attempts := make([]WebhookAttemptJSON, 0, len(d.Attempts))
+	for _, a := range d.Attempts {
+		attempts = append(attempts, WebhookAttemptJSON{
+			Time:       a.Time,
+			StatusCode: a.StatusCode,
+			Error:      a.Error,
+			DurationMs: a.Duration.Milliseconds(),
+		})
+	}
+	return WebhookDeliveryJSON{
+		ID:            d.Id,
+		WebhookID:     d.WebhookId,
+		EventID:       d.EventId,
+		EventType:     d.EventType,
+		Status:        d.Status,
+		Attempts:      attempts,
+		NextAttemptAt: nextAttemptAt,
+		CreatedAt:     d.CreatedAt,
+		Payload:       d.Payload,
+	}
+}
+
+// NewWebhookDefault is a function that returns a new instance of WebhookDefault
+func NewWebhookDefault(sv internal.WebhookService) *WebhookDefault {
+	return &WebhookDefault{sv: sv}
+}
+
+// WebhookDefault is a struct with methods that represent handlers for webhooks
+type WebhookDefault struct {
+	// sv is the service that will be used by the handler
+	sv internal.WebhookService
+}
+
+// Create is a method that returns a handler for the route POST /webhooks
+// - the body is {"url": ..., "events": [...], "secret": ...}, no events subscribes to every type
+// - the secret is generated when it is empty, it is only returned by this route
+func (h *WebhookDefault) Create() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		var body WebhookJSON
+		if err := request.JSON(r, &body); err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid JSON Body")
+			return
+		}
+
+		// process
+		webhook := internal.Webhook{URL: body.URL, Events: body.Events, Secret: body.Secret}
+		if err := h.sv.Create(&webhook); err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		data := newWebhookJSON(webhook)
+		data.Secret = webhook.Secret
+		w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+strconv.Itoa(webhook.Id))
+		respondEnvelope(w, http.StatusCreated, Envelope{
+			Message: "successful webhook creation",
+			Data:    data,
+		})
+	}
+}
+
+// GetAll is a method that returns a handler for the route GET /webhooks
+func (h *WebhookDefault) GetAll() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// process
+		webhooks, err := h.sv.FindAll()
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		data := make([]WebhookJSON, 0, len(webhooks))
+		for _, webhook := range webhooks {
+			data = append(data, newWebhookJSON(webhook))
+		}
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    data,
+		})
+	}
+}
+
+// GetById is a method that returns a handler for the route GET /webhooks/{id}
+func (h *WebhookDefault) GetById() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		// process
+		webhook, err := h.sv.FindById(id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newWebhookJSON(webhook),
+		})
+	}
+}
+
+// Delete is a method that returns a handler for the route DELETE /webhooks/{id}
+func (h *WebhookDefault) Delete() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		// process
+		if err = h.sv.Delete(id); err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// GetDeliveries is a method that returns a handler for the route GET /webhooks/{id}/deliveries
+// - the delivery log of the webhook, oldest first, with every attempt made
+func (h *WebhookDefault) GetDeliveries() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		// process
+		d, err := h.sv.FindDeliveries(id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newWebhookDeliveriesJSON(d),
+		})
+	}
+}
+
+// GetDeadLetters is a method that returns a handler for the route GET /webhooks/dead_letters
+// - the deliveries of every webhook whose attempts all failed, oldest first
+func (h *WebhookDefault) GetDeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// process
+		d, err := h.sv.FindDeadLetters()
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusOK, Envelope{
+			Message: "success",
+			Data:    newWebhookDeliveriesJSON(d),
+		})
+	}
+}
+
+// Redeliver is a method that returns a handler for the route POST /webhooks/deliveries/{id}/retry
+// - the delivery must be dead, it is attempted again in the background
+func (h *WebhookDefault) Redeliver() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// request
+		id, err := strconv.Atoi(chi.URLParam(r, "id"))
+		if err != nil {
+			respondProblem(w, r, http.StatusBadRequest, CodeInvalidRequest, "Invalid id provided")
+			return
+		}
+
+		// process
+		d, err := h.sv.Redeliver(id)
+		if err != nil {
+			respondError(w, r, err)
+			return
+		}
+
+		// response
+		respondEnvelope(w, http.StatusAccepted, Envelope{
+			Message: "successful webhook redelivery",
+			Data:    newWebhookDeliveryJSON(d),
+		})
+	}
+}
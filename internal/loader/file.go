@@ -0,0 +1,60 @@
+package loader
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// saveFile is a function that replaces the file at path with the content written by write
+// - the content is written to a temporary file in the same directory and then renamed over the original,
+// so a crash never leaves a half-written file behind
+func saveFile(path string, write func(w io.Writer) error) (err error) {
+	// create temporary file
+	dir := filepath.Dir(path)
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return
+	}
+	defer func() {
+		// remove temporary file if anything went wrong
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+
+	// keep the permissions of the original file
+	mode := os.FileMode(0644)
+	if info, e := os.Stat(path); e == nil {
+		mode = info.Mode().Perm()
+	}
+	if err = file.Chmod(mode); err != nil {
+		return
+	}
+
+	// write file
+	if err = write(file); err != nil {
+		return
+	}
+	if err = file.Sync(); err != nil {
+		return
+	}
+	if err = file.Close(); err != nil {
+		return
+	}
+
+	// replace file
+	if err = os.Rename(file.Name(), path); err != nil {
+		return
+	}
+
+	// sync directory so the rename itself is durable
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+	err = d.Sync()
+	return
+}
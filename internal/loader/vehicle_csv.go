@@ -0,0 +1,338 @@
+package loader
+
+import (
+	"app/internal"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrVehicleCSV is returned when a CSV file cannot be read at all, e.g. its header maps no column
+	ErrVehicleCSV = errors.New("invalid vehicles CSV")
+	// ErrVehicleCSVRow is returned when a row of a CSV file holds an invalid value
+	ErrVehicleCSVRow = errors.New("invalid vehicles CSV row")
+)
+
+// vehicleCSVColumns are the columns written, in order, named as the fields of VehicleJSON
+var vehicleCSVColumns = []string{
+	"id", "version", "brand", "model", "registration", "color", "year", "passengers", "max_speed",
+	"fuel_type", "transmission", "weight", "height", "length", "width", "updated_at", "deleted_at",
+}
+
+// DefaultVehicleCSVAliases are the header names read as another column, besides the column names themselves
+var DefaultVehicleCSVAliases = map[string]string{
+	"fabrication_year": "year",
+	"capacity":         "passengers",
+	"plate":            "registration",
+	"license_plate":    "registration",
+	"fuel":             "fuel_type",
+}
+
+// VehicleCSVRow is a struct that represents a row read from a CSV file
+type VehicleCSVRow struct {
+	// Line is the line of the file the row starts at
+	Line int
+	// Vehicle is the vehicle read, partially filled when Err is set
+	Vehicle internal.Vehicle
+	// Err is why the row could not be read, it wraps ErrVehicleCSVRow
+	Err error
+}
+
+// NewVehicleCSV is a function that returns a new instance of VehicleCSV
+// - aliases maps extra header names to columns, nil uses DefaultVehicleCSVAliases
+func NewVehicleCSV(aliases map[string]string) *VehicleCSV {
+	if aliases == nil {
+		aliases = DefaultVehicleCSVAliases
+	}
+	columns := make(map[string]string, len(vehicleCSVColumns)+len(aliases))
+	for _, column := range vehicleCSVColumns {
+		columns[column] = column
+	}
+	for alias, column := range aliases {
+		columns[normalizeCSVHeader(alias)] = column
+	}
+	return &VehicleCSV{columns: columns}
+}
+
+// VehicleCSV is a struct that reads and writes vehicles in CSV format
+// - the first row is the header, the columns are mapped by name so they can come in any order,
+// names are compared case-insensitively with spaces and dashes taken as underscores, e.g. "Max Speed"
+// - unknown columns are ignored, missing ones are left empty
+// - text cells that a spreadsheet would run as a formula are written with a leading quote, which is removed when read
+type VehicleCSV struct {
+	// columns maps the normalized header names to the columns
+	columns map[string]string
+}
+
+// Read is a method that reads every row of the CSV, keeping reading past the invalid ones
+// - an error is only returned when the file cannot be read or its header maps no column
+func (c *VehicleCSV) Read(r io.Reader) (rows []VehicleCSVRow, err error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	// header
+	header, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("%w: missing header", ErrVehicleCSV)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrVehicleCSV, err)
+	}
+	index := make(map[string]int)
+	for i, name := range header {
+		column, ok := c.columns[normalizeCSVHeader(name)]
+		if !ok {
+			continue
+		}
+		if _, ok := index[column]; ok {
+			return nil, fmt.Errorf("%w: column %q appears more than once", ErrVehicleCSV, column)
+		}
+		index[column] = i
+	}
+	if len(index) == 0 {
+		return nil, fmt.Errorf("%w: the header maps no column, expected some of %q", ErrVehicleCSV, vehicleCSVColumns)
+	}
+
+	// rows
+	// - the line is known once the row is read, from the error when it could not be parsed
+	for {
+		record, e := reader.Read()
+		if errors.Is(e, io.EOF) {
+			break
+		}
+		var row VehicleCSVRow
+		var pe *csv.ParseError
+		switch {
+		case errors.As(e, &pe):
+			row.Line = pe.StartLine
+			row.Err = fmt.Errorf("%w: %w", ErrVehicleCSVRow, e)
+		case e != nil:
+			// the file itself could not be read
+			return nil, fmt.Errorf("%w: %w", ErrVehicleCSV, e)
+		default:
+			row.Line, _ = reader.FieldPos(0)
+			row.Vehicle, row.Err = parseVehicleCSVRecord(record, index)
+		}
+		rows = append(rows, row)
+	}
+	return
+}
+
+// Write is a method that writes the header and a row for each vehicle, in the given order
+func (c *VehicleCSV) Write(w io.Writer, v []internal.Vehicle) (err error) {
+	writer := csv.NewWriter(w)
+	if err = writer.Write(vehicleCSVColumns); err != nil {
+		return
+	}
+	for _, vh := range v {
+		record := []string{
+			strconv.Itoa(vh.Id),
+			strconv.Itoa(vh.Version),
+			escapeCSVFormula(vh.Brand),
+			escapeCSVFormula(vh.Model),
+			escapeCSVFormula(vh.Registration),
+			escapeCSVFormula(vh.Color),
+			strconv.Itoa(vh.FabricationYear),
+			strconv.Itoa(vh.Capacity),
+			formatCSVFloat(vh.MaxSpeed),
+			escapeCSVFormula(vh.FuelType),
+			escapeCSVFormula(vh.Transmission),
+			formatCSVFloat(vh.Weight),
+			formatCSVFloat(vh.Height),
+			formatCSVFloat(vh.Length),
+			formatCSVFloat(vh.Width),
+			formatCSVTime(vh.UpdatedAt),
+			formatCSVTime(vh.DeletedAt),
+		}
+		if err = writer.Write(record); err != nil {
+			return
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// parseVehicleCSVRecord is a function that returns the vehicle held by a record, index maps the columns to cells
+// - every invalid cell is reported, not only the first one
+func parseVehicleCSVRecord(record []string, index map[string]int) (v internal.Vehicle, err error) {
+	cell := func(column string) string {
+		i, ok := index[column]
+		if !ok || i >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[i])
+	}
+
+	var problems []string
+	parseInt := func(column string, dst *int) {
+		if s := cell(column); s != "" {
+			n, e := strconv.Atoi(s)
+			if e != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not an integer", column, s))
+			}
+			*dst = n
+		}
+	}
+	parseFloat := func(column string, dst *float64) {
+		if s := cell(column); s != "" {
+			f, e := strconv.ParseFloat(s, 64)
+			if e != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a number", column, s))
+			}
+			*dst = f
+		}
+	}
+	parseTime := func(column string, dst *time.Time) {
+		if s := cell(column); s != "" {
+			t, e := time.Parse(time.RFC3339, s)
+			if e != nil {
+				problems = append(problems, fmt.Sprintf("%s: %q is not an RFC 3339 time", column, s))
+			}
+			*dst = t.UTC()
+		}
+	}
+
+	parseInt("id", &v.Id)
+	parseInt("version", &v.Version)
+	v.Brand = unescapeCSVFormula(cell("brand"))
+	v.Model = unescapeCSVFormula(cell("model"))
+	v.Registration = unescapeCSVFormula(cell("registration"))
+	v.Color = unescapeCSVFormula(cell("color"))
+	parseInt("year", &v.FabricationYear)
+	parseInt("passengers", &v.Capacity)
+	parseFloat("max_speed", &v.MaxSpeed)
+	v.FuelType = unescapeCSVFormula(cell("fuel_type"))
+	v.Transmission = unescapeCSVFormula(cell("transmission"))
+	parseFloat("weight", &v.Weight)
+	parseFloat("height", &v.Height)
+	parseFloat("length", &v.Length)
+	parseFloat("width", &v.Width)
+	parseTime("updated_at", &v.UpdatedAt)
+	parseTime("deleted_at", &v.DeletedAt)
+
+	if len(problems) > 0 {
+		err = fmt.Errorf("%w: %s", ErrVehicleCSVRow, strings.Join(problems, ", "))
+	}
+	return
+}
+
+// normalizeCSVHeader is a function that returns the header name lowercased, with spaces and dashes as underscores
+// - the byte order mark spreadsheets write at the start of the file is removed
+func normalizeCSVHeader(name string) string {
+	name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+	return strings.NewReplacer(" ", "_", "-", "_").Replace(name)
+}
+
+// escapeCSVFormula is a function that prefixes with a quote the text a spreadsheet would run as a formula
+func escapeCSVFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// unescapeCSVFormula is a function that removes the quote added by escapeCSVFormula
+func unescapeCSVFormula(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.ContainsRune("=+-@\t\r", rune(s[1])) {
+		return s[1:]
+	}
+	return s
+}
+
+// formatCSVFloat is a function that formats the number with as few digits as needed
+func formatCSVFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// formatCSVTime is a function that formats the time in RFC 3339, empty for the zero time
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// NewVehicleCSVFile is a function that returns a new instance of VehicleCSVFile
+// - aliases maps extra header names to columns, nil uses DefaultVehicleCSVAliases
+func NewVehicleCSVFile(path string, aliases map[string]string) *VehicleCSVFile {
+	return &VehicleCSVFile{path: path, csv: NewVehicleCSV(aliases)}
+}
+
+// VehicleCSVFile is a struct that implements the VehicleLoader and VehicleStorer interfaces over a CSV file
+// - unlike imports, loading fails on the first invalid row, so no vehicle is silently left behind
+type VehicleCSVFile struct {
+	// path is the path to the file that contains the vehicles in CSV format
+	path string
+	// csv reads and writes the rows
+	csv *VehicleCSV
+}
+
+// Load is a method that loads the vehicles
+func (l *VehicleCSVFile) Load() (v map[int]internal.Vehicle, err error) {
+	// open file
+	file, err := os.Open(l.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// vehicles saved without a modification time are taken as last modified with the file
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	modTime := info.ModTime().UTC()
+
+	// decode file
+	rows, err := l.csv.Read(file)
+	if err != nil {
+		return
+	}
+
+	v = make(map[int]internal.Vehicle, len(rows))
+	for _, row := range rows {
+		switch _, ok := v[row.Vehicle.Id]; {
+		case row.Err != nil:
+			return nil, fmt.Errorf("line %d: %w", row.Line, row.Err)
+		case row.Vehicle.Id <= 0:
+			return nil, fmt.Errorf("line %d: %w: id must be positive", row.Line, ErrVehicleCSVRow)
+		case ok:
+			return nil, fmt.Errorf("line %d: %w: id %d appears more than once", row.Line, ErrVehicleCSVRow, row.Vehicle.Id)
+		}
+
+		vh := row.Vehicle
+		// vehicles saved before versions existed start at 1
+		if vh.Version == 0 {
+			vh.Version = 1
+		}
+		if vh.UpdatedAt.IsZero() {
+			vh.UpdatedAt = modTime
+		}
+		v[vh.Id] = vh
+	}
+
+	return
+}
+
+// Save is a method that saves the vehicles sorted by id, replacing the file atomically, see saveFile
+func (l *VehicleCSVFile) Save(v map[int]internal.Vehicle) (err error) {
+	vehicles := make([]internal.Vehicle, 0, len(v))
+	for _, vh := range v {
+		vehicles = append(vehicles, vh)
+	}
+	sort.Slice(vehicles, func(i, j int) bool {
+		return vehicles[i].Id < vehicles[j].Id
+	})
+
+	return saveFile(l.path, func(w io.Writer) error {
+		return l.csv.Write(w, vehicles)
+	})
+}
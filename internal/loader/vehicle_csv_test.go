@@ -0,0 +1,179 @@
+package loader
+
+import (
+	"app/internal"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVehicleCSV_ReadQuoted(t *testing.T) {
+	in := "id,brand,model,max_speed\n" +
+		"1,\"Ford, Motor\",\"the \"\"T\"\"\",120.5\n" +
+		"2,Fiat,\"multi\nline\",90\n" +
+		"3,Toyota,Corolla,150\n"
+	rows, err := NewVehicleCSV(nil).Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// rows are numbered by the line they start at
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	for i, want := range []int{2, 3, 5} {
+		if rows[i].Err != nil || rows[i].Line != want {
+			t.Errorf("got row %d at line %d, %v, want line %d", i, rows[i].Line, rows[i].Err, want)
+		}
+	}
+	if v := rows[0].Vehicle; v.Brand != "Ford, Motor" || v.Model != `the "T"` || v.MaxSpeed != 120.5 {
+		t.Errorf("got %+v", v)
+	}
+	if v := rows[1].Vehicle; v.Model != "multi\nline" {
+		t.Errorf("got model %q, want a multi-line one", v.Model)
+	}
+}
+
+func TestVehicleCSV_ReadInvalidRows(t *testing.T) {
+	cases := map[string]struct {
+		in    string
+		lines []int
+		bad   []bool
+	}{
+		// the first field of a row can not be parsed
+		"quote in first field": {in: "id,brand\n1\"x,Ford\n2,Fiat\n", lines: []int{2, 3}, bad: []bool{true, false}},
+		"quote in last field":  {in: "id,brand\n1,F\"ord\n2,Fiat\n", lines: []int{2, 3}, bad: []bool{true, false}},
+		"invalid values":       {in: "id,year,max_speed\n1,old,fast\n2,2010,90\n", lines: []int{2, 3}, bad: []bool{true, false}},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rows, err := NewVehicleCSV(nil).Read(strings.NewReader(c.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(c.lines) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(c.lines))
+			}
+			for i, row := range rows {
+				if row.Line != c.lines[i] || (row.Err != nil) != c.bad[i] {
+					t.Errorf("got row %d at line %d, %v", i, row.Line, row.Err)
+				}
+				if row.Err != nil && !errors.Is(row.Err, ErrVehicleCSVRow) {
+					t.Errorf("got %v, want ErrVehicleCSVRow", row.Err)
+				}
+			}
+		})
+	}
+
+	// every invalid cell is reported
+	rows, _ := NewVehicleCSV(nil).Read(strings.NewReader(cases["invalid values"].in))
+	if msg := rows[0].Err.Error(); !strings.Contains(msg, "year") || !strings.Contains(msg, "max_speed") {
+		t.Errorf("got %q, want both cells reported", msg)
+	}
+}
+
+func TestVehicleCSV_ReadHeader(t *testing.T) {
+	// aliases, byte order mark, case, spaces and dashes
+	in := "\ufeffID,Plate,Fabrication Year,max-speed,Fuel,unknown\n1,AB-123,2010,120,diesel,x\n"
+	rows, err := NewVehicleCSV(nil).Read(strings.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := internal.Vehicle{Id: 1, VehicleAttributes: internal.VehicleAttributes{
+		Registration: "AB-123", FabricationYear: 2010, MaxSpeed: 120, FuelType: "diesel",
+	}}
+	if len(rows) != 1 || rows[0].Err != nil || rows[0].Vehicle != want {
+		t.Errorf("got %+v, want %+v", rows, want)
+	}
+
+	// custom aliases replace the default ones
+	rows, err = NewVehicleCSV(map[string]string{"Make": "brand"}).Read(strings.NewReader("id,make,plate\n1,Ford,AB-123\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := rows[0].Vehicle; v.Brand != "Ford" || v.Registration != "" {
+		t.Errorf("got %+v, want the brand only", v)
+	}
+
+	// headers that can not be used
+	for name, in := range map[string]string{
+		"empty":     "",
+		"no column": "foo,bar\n1,2\n",
+		"repeated":  "plate,registration\nA,B\n",
+	} {
+		if _, err := NewVehicleCSV(nil).Read(strings.NewReader(in)); !errors.Is(err, ErrVehicleCSV) {
+			t.Errorf("%s: got %v, want ErrVehicleCSV", name, err)
+		}
+	}
+}
+
+func TestVehicleCSV_FormulaEscaping(t *testing.T) {
+	v := []internal.Vehicle{{Id: 1, VehicleAttributes: internal.VehicleAttributes{
+		Brand: "=HYPERLINK(\"x\")", Model: "+1", Registration: "-AB", Color: "@red", FuelType: "'quoted",
+	}}}
+	var buf bytes.Buffer
+	if err := NewVehicleCSV(nil).Write(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+
+	// no cell starts with a formula character
+	out := buf.String()
+	for _, cell := range []string{`"'=HYPERLINK(""x"")"`, "'+1", "'-AB", "'@red", ",'quoted,"} {
+		if !strings.Contains(out, cell) {
+			t.Errorf("got %q, want it to hold %s", out, cell)
+		}
+	}
+
+	// the quote added is removed when read, the one that was there is kept
+	rows, err := NewVehicleCSV(nil).Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := rows[0].Vehicle.VehicleAttributes; got != v[0].VehicleAttributes {
+		t.Errorf("got %+v, want %+v", got, v[0].VehicleAttributes)
+	}
+}
+
+func TestVehicleCSVFile_RoundTrip(t *testing.T) {
+	updated := time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC)
+	v := map[int]internal.Vehicle{
+		1: {Id: 1, Version: 3, UpdatedAt: updated, VehicleAttributes: internal.VehicleAttributes{
+			Brand: "Ford", Model: "Fiesta, \"ST\"", Registration: "AB-123", Color: "red", FabricationYear: 2010,
+			Capacity: 5, MaxSpeed: 180.5, FuelType: "gasoline", Transmission: "manual", Weight: 1100.25,
+			Dimensions: internal.Dimensions{Height: 1.5, Length: 4, Width: 1.7},
+		}},
+		2: {Id: 2, Version: 1, UpdatedAt: updated, DeletedAt: updated.Add(time.Hour), VehicleAttributes: internal.VehicleAttributes{
+			Brand: "Fiat", Model: "multi\nline", Registration: "=1+1",
+		}},
+	}
+	path := filepath.Join(t.TempDir(), "vehicles.csv")
+	l := NewVehicleCSVFile(path, nil)
+	if err := l.Save(v); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := l.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+}
+
+func TestVehicleCSVFile_LoadFailsOnInvalidRow(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vehicles.csv")
+	if err := os.WriteFile(path, []byte("id,brand\n1,Ford\n1\"x,Fiat\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewVehicleCSVFile(path, nil).Load()
+	if !errors.Is(err, ErrVehicleCSVRow) || !strings.HasPrefix(err.Error(), "line 3:") {
+		t.Errorf("got %v, want ErrVehicleCSVRow at line 3", err)
+	}
+}
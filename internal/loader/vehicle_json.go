@@ -3,7 +3,10 @@ package loader
 import (
 	"app/internal"
 	"encoding/json"
+	"io"
 	"os"
+	"sort"
+	"time"
 )
 
 // NewVehicleJSONFile is a function that returns a new instance of VehicleJSONFile
@@ -13,7 +16,7 @@ func NewVehicleJSONFile(path string) *VehicleJSONFile {
 	}
 }
 
-// VehicleJSONFile is a struct that implements the LoaderVehicle interface
+// VehicleJSONFile is a struct that implements the LoaderVehicle and VehicleStorer interfaces
 type VehicleJSONFile struct {
 	// path is the path to the file that contains the vehicles in JSON format
 	path string
@@ -21,20 +24,23 @@ type VehicleJSONFile struct {
 
 // VehicleJSON is a struct that represents a vehicle in JSON format
 type VehicleJSON struct {
-	Id              int     `json:"id"`
-	Brand           string  `json:"brand"`
-	Model           string  `json:"model"`
-	Registration    string  `json:"registration"`
-	Color           string  `json:"color"`
-	FabricationYear int     `json:"year"`
-	Capacity        int     `json:"passengers"`
-	MaxSpeed        float64 `json:"max_speed"`
-	FuelType        string  `json:"fuel_type"`
-	Transmission    string  `json:"transmission"`
-	Weight          float64 `json:"weight"`
-	Height          float64 `json:"height"`
-	Length          float64 `json:"length"`
-	Width           float64 `json:"width"`
+	Id              int        `json:"id"`
+	Version         int        `json:"version,omitempty"`
+	Brand           string     `json:"brand"`
+	Model           string     `json:"model"`
+	Registration    string     `json:"registration"`
+	Color           string     `json:"color"`
+	FabricationYear int        `json:"year"`
+	Capacity        int        `json:"passengers"`
+	MaxSpeed        float64    `json:"max_speed"`
+	FuelType        string     `json:"fuel_type"`
+	Transmission    string     `json:"transmission"`
+	Weight          float64    `json:"weight"`
+	Height          float64    `json:"height"`
+	Length          float64    `json:"length"`
+	Width           float64    `json:"width"`
+	UpdatedAt       *time.Time `json:"updated_at,omitempty"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
 }
 
 // Load is a method that loads the vehicles
@@ -46,6 +52,13 @@ func (l *VehicleJSONFile) Load() (v map[int]internal.Vehicle, err error) {
 	}
 	defer file.Close()
 
+	// vehicles saved without a modification time are taken as last modified with the file
+	info, err := file.Stat()
+	if err != nil {
+		return
+	}
+	modTime := info.ModTime().UTC()
+
 	// decode file
 	var vehiclesJSON []VehicleJSON
 	err = json.NewDecoder(file).Decode(&vehiclesJSON)
@@ -56,8 +69,22 @@ func (l *VehicleJSONFile) Load() (v map[int]internal.Vehicle, err error) {
 	// serialize vehicles
 	v = make(map[int]internal.Vehicle)
 	for _, vh := range vehiclesJSON {
+		// vehicles saved before versions existed start at 1
+		if vh.Version == 0 {
+			vh.Version = 1
+		}
+		if vh.UpdatedAt == nil {
+			vh.UpdatedAt = &modTime
+		}
+		var deletedAt time.Time
+		if vh.DeletedAt != nil {
+			deletedAt = vh.DeletedAt.UTC()
+		}
 		v[vh.Id] = internal.Vehicle{
-			Id: vh.Id,
+			Id:        vh.Id,
+			Version:   vh.Version,
+			UpdatedAt: vh.UpdatedAt.UTC(),
+			DeletedAt: deletedAt,
 			VehicleAttributes: internal.VehicleAttributes{
 				Brand:           vh.Brand,
 				Model:           vh.Model,
@@ -80,3 +107,49 @@ func (l *VehicleJSONFile) Load() (v map[int]internal.Vehicle, err error) {
 
 	return
 }
+
+// Save is a method that saves the vehicles, replacing the file atomically, see saveFile
+func (l *VehicleJSONFile) Save(v map[int]internal.Vehicle) (err error) {
+	// deserialize vehicles (sorted by id so the file is stable between saves)
+	vehiclesJSON := make([]VehicleJSON, 0, len(v))
+	for _, vh := range v {
+		var updatedAt *time.Time
+		if !vh.UpdatedAt.IsZero() {
+			t := vh.UpdatedAt
+			updatedAt = &t
+		}
+		var deletedAt *time.Time
+		if !vh.DeletedAt.IsZero() {
+			t := vh.DeletedAt
+			deletedAt = &t
+		}
+		vehiclesJSON = append(vehiclesJSON, VehicleJSON{
+			Id:              vh.Id,
+			Version:         vh.Version,
+			Brand:           vh.Brand,
+			Model:           vh.Model,
+			Registration:    vh.Registration,
+			Color:           vh.Color,
+			FabricationYear: vh.FabricationYear,
+			Capacity:        vh.Capacity,
+			MaxSpeed:        vh.MaxSpeed,
+			FuelType:        vh.FuelType,
+			Transmission:    vh.Transmission,
+			Weight:          vh.Weight,
+			Height:          vh.Height,
+			Length:          vh.Length,
+			Width:           vh.Width,
+			UpdatedAt:       updatedAt,
+			DeletedAt:       deletedAt,
+		})
+	}
+	sort.Slice(vehiclesJSON, func(i, j int) bool {
+		return vehiclesJSON[i].Id < vehiclesJSON[j].Id
+	})
+
+	return saveFile(l.path, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(vehiclesJSON)
+	})
+}
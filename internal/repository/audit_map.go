@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"app/internal"
+	"sync"
+)
+
+// NewAuditMap is a function that returns a new instance of AuditMap
+func NewAuditMap() *AuditMap {
+	return &AuditMap{}
+}
+
+// AuditMap is a struct that represents an audit repository kept in memory
+// - it is safe for concurrent use
+// - entries are lost when the application stops
+type AuditMap struct {
+	// mu guards entries and seq
+	mu sync.RWMutex
+	// entries is the audit trail, in the order it was appended
+	entries []internal.AuditEntry
+	// seq is the id of the last entry
+	seq int
+}
+
+// Append is a method that stores the entries at the end of the audit trail, setting their ids
+func (r *AuditMap) Append(e []internal.AuditEntry) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range e {
+		r.seq++
+		e[i].Id = r.seq
+		r.entries = append(r.entries, e[i])
+	}
+	return
+}
+
+// Find is a method that returns the entries matching the query in the order they were appended
+func (r *AuditMap) Find(q internal.AuditQuery) (e []internal.AuditEntry, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// walk backwards so the limit keeps the latest entries
+	for i := len(r.entries) - 1; i >= 0; i-- {
+		if q.Limit > 0 && len(e) == q.Limit {
+			break
+		}
+		if q.Match(r.entries[i]) {
+			e = append(e, r.entries[i])
+		}
+	}
+
+	// back in the order they were appended
+	for i, j := 0, len(e)-1; i < j; i, j = i+1, j-1 {
+		e[i], e[j] = e[j], e[i]
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"app/internal"
+	"database/sql"
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// NewAuditSQL is a function that returns a new instance of AuditSQL
+// - the vehicle_audit table is created by the migrations of VehicleSQL
+func NewAuditSQL(db *sql.DB) *AuditSQL {
+	return &AuditSQL{db: db}
+}
+
+// AuditSQL is a struct that represents an audit repository on top of database/sql
+// - queries use "?" placeholders (sqlite, mysql)
+// - the values of the changes are stored as JSON, so numbers are read back as float64
+type AuditSQL struct {
+	// db is the database connection pool
+	db *sql.DB
+}
+
+// Append is a method that stores the entries at the end of the audit trail, setting their ids
+// - the entries are stored in a single transaction, all or none
+func (r *AuditSQL) Append(e []internal.AuditEntry) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	ids := make([]int, len(e))
+	for i, entry := range e {
+		var changes []byte
+		changes, err = json.Marshal(newAuditChangesSQL(entry.Changes))
+		if err != nil {
+			return
+		}
+
+		var res sql.Result
+		res, err = tx.Exec(
+			`INSERT INTO vehicle_audit (time, actor, operation, vehicle_id, version, changes) VALUES (?, ?, ?, ?, ?, ?)`,
+			entry.Time.UnixNano(), entry.Actor, entry.Operation, entry.VehicleId, entry.Version, string(changes),
+		)
+		if err != nil {
+			return
+		}
+
+		var id int64
+		if id, err = res.LastInsertId(); err != nil {
+			return
+		}
+		ids[i] = int(id)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	// ids are only set once they are stored
+	for i := range e {
+		e[i].Id = ids[i]
+	}
+	return
+}
+
+// Find is a method that returns the entries matching the query in the order they were appended
+func (r *AuditSQL) Find(q internal.AuditQuery) (e []internal.AuditEntry, err error) {
+	// conditions
+	var where []string
+	var args []any
+	if q.VehicleId != 0 {
+		where = append(where, "vehicle_id = ?")
+		args = append(args, q.VehicleId)
+	}
+	if q.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, q.Actor)
+	}
+	if !q.From.IsZero() {
+		where = append(where, "time >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "time < ?")
+		args = append(args, q.To.UnixNano())
+	}
+
+	// latest entries first, so the limit keeps them
+	query := "SELECT id, time, actor, operation, vehicle_id, version, changes FROM vehicle_audit"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+
+	rows, err := r.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry internal.AuditEntry
+		var nanos int64
+		var changes string
+		if err = rows.Scan(&entry.Id, &nanos, &entry.Actor, &entry.Operation, &entry.VehicleId, &entry.Version, &changes); err != nil {
+			return nil, err
+		}
+		entry.Time = time.Unix(0, nanos).UTC()
+
+		var cs []auditChangeSQL
+		if err = json.Unmarshal([]byte(changes), &cs); err != nil {
+			return nil, err
+		}
+		for _, c := range cs {
+			entry.Changes = append(entry.Changes, internal.AuditChange{Field: c.Field, Before: c.Before, After: c.After})
+		}
+
+		e = append(e, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// back in the order they were appended
+	for i, j := 0, len(e)-1; i < j; i, j = i+1, j-1 {
+		e[i], e[j] = e[j], e[i]
+	}
+	return
+}
+
+// auditChangeSQL is a struct that represents a change of an audit entry stored as JSON
+type auditChangeSQL struct {
+	Field  string `json:"field"`
+	Before any    `json:"before"`
+	After  any    `json:"after"`
+}
+
+// newAuditChangesSQL is a function that returns the changes to be stored as JSON, never nil
+func newAuditChangesSQL(changes []internal.AuditChange) (cs []auditChangeSQL) {
+	cs = make([]auditChangeSQL, 0, len(changes))
+	for _, c := range changes {
+		cs = append(cs, auditChangeSQL{Field: c.Field, Before: c.Before, After: c.After})
+	}
+	return
+}
@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"app/internal"
+	"encoding/json"
+)
+
+// aggregateVehicles is a function that groups the vehicles matching the aggregation filter and computes its metrics
+// - the aggregation is expected to be validated
+func aggregateVehicles(v map[int]internal.Vehicle, a internal.VehicleAggregation) (rows []internal.VehicleAggregateRow) {
+	// fields
+	groupFields := make([]internal.VehicleField, len(a.GroupBy))
+	for i, name := range a.GroupBy {
+		groupFields[i], _ = internal.LookupVehicleField(name)
+	}
+	metricFields := make([]internal.VehicleField, len(a.Metrics))
+	for i, m := range a.Metrics {
+		metricFields[i], _ = internal.LookupVehicleField(m.Field)
+	}
+
+	// group
+	groups := make(map[string]*vehicleGroup)
+	var order []*vehicleGroup
+	for _, vh := range v {
+		if !matchVehicleFilter(a.Filter, vh) {
+			continue
+		}
+
+		values := make([]any, len(groupFields))
+		for i, field := range groupFields {
+			values[i] = field.Value(vh)
+		}
+		bytes, _ := json.Marshal(values)
+		g, ok := groups[string(bytes)]
+		if !ok {
+			g = &vehicleGroup{values: values, metrics: make([]internal.MetricAggregate, len(a.Metrics))}
+			groups[string(bytes)] = g
+			order = append(order, g)
+		}
+
+		for i, m := range a.Metrics {
+			if m.Function == internal.AggregateCount {
+				g.metrics[i].Count++
+				continue
+			}
+			var value float64
+			switch x := metricFields[i].Value(vh).(type) {
+			case int:
+				value = float64(x)
+			case float64:
+				value = x
+			}
+			addToMetric(&g.metrics[i], value)
+		}
+	}
+
+	// compute metrics
+	rows = make([]internal.VehicleAggregateRow, 0, len(order))
+	for _, g := range order {
+		row := internal.VehicleAggregateRow{Group: g.values, Values: make([]float64, len(a.Metrics))}
+		for i, m := range a.Metrics {
+			switch m.Function {
+			case internal.AggregateCount:
+				row.Values[i] = float64(g.metrics[i].Count)
+			case internal.AggregateSum:
+				row.Values[i] = g.metrics[i].Sum
+			case internal.AggregateAvg:
+				row.Values[i] = g.metrics[i].Avg()
+			case internal.AggregateMin:
+				row.Values[i] = g.metrics[i].Min
+			case internal.AggregateMax:
+				row.Values[i] = g.metrics[i].Max
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return
+}
+
+// vehicleGroup is a struct that represents a group of vehicles being aggregated
+type vehicleGroup struct {
+	// values are the values of the group by fields
+	values []any
+	// metrics are the running aggregates of each metric
+	metrics []internal.MetricAggregate
+}
@@ -0,0 +1,22 @@
+package repository
+
+import (
+	"app/internal"
+)
+
+// NewVehicleFile is a function that returns a new instance of VehicleFile
+func NewVehicleFile(db map[int]internal.Vehicle, st internal.VehicleStorer) *VehicleFile {
+	r := NewVehicleMap(db)
+	r.st = st
+	return &VehicleFile{VehicleMap: r}
+}
+
+// VehicleFile is a struct that represents a vehicle repository persisted to a file
+// - reads are served by the embedded VehicleMap
+// - every write saves the whole set of vehicles, with the change applied, through the storer before
+// the change is applied in memory, so a failed save leaves the repository untouched
+// - the write lock is held while saving, readers wait for the save instead of seeing a change that may not be kept
+type VehicleFile struct {
+	// VehicleMap is the in-memory repository holding the vehicles
+	*VehicleMap
+}
@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"app/internal"
+	"errors"
+	"testing"
+)
+
+// storerStub is a struct that implements internal.VehicleStorer keeping the last vehicles saved
+type storerStub struct {
+	// saved are the vehicles of the last successful save
+	saved map[int]internal.Vehicle
+	// err is returned by Save when set
+	err error
+}
+
+// Save is a method that keeps the vehicles, or returns err
+func (s *storerStub) Save(v map[int]internal.Vehicle) (err error) {
+	if s.err != nil {
+		return s.err
+	}
+	s.saved = v
+	return
+}
+
+func TestVehicleFile_SavesBeforeApplying(t *testing.T) {
+	st := &storerStub{}
+	rp := NewVehicleFile(newTestVehicleMap(3).snapshot(), st)
+
+	v := newTestVehicle(10)
+	if err := rp.Create(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.saved[v.Id]; !ok || len(st.saved) != 4 {
+		t.Fatalf("saved %d vehicles without the one created", len(st.saved))
+	}
+
+	if _, err := rp.Delete(1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if st.saved[1].DeletedAt.IsZero() {
+		t.Error("deleted vehicle saved without DeletedAt")
+	}
+}
+
+func TestVehicleFile_FailedSaveLeavesRepositoryUntouched(t *testing.T) {
+	st := &storerStub{}
+	rp := NewVehicleFile(newTestVehicleMap(3).snapshot(), st)
+	before, _ := rp.FindAll()
+	st.err = errors.New("disk full")
+
+	// every write fails as a storage error
+	v := newTestVehicle(10)
+	writes := map[string]error{
+		"Create":         rp.Create(&v),
+		"CreateMultiple": rp.CreateMultiple([]internal.Vehicle{newTestVehicle(11)}),
+	}
+	_, writes["Delete"] = rp.Delete(1, 0)
+	updated, _ := rp.FindById(2)
+	updated.MaxSpeed++
+	_, writes["Update"] = rp.Update(&updated)
+	for op, err := range writes {
+		if !errors.Is(err, internal.ErrVehicleStorageRepo) {
+			t.Errorf("%s: got %v, want ErrVehicleStorageRepo", op, err)
+		}
+	}
+
+	// nothing changed in memory
+	after, _ := rp.FindAll()
+	if len(after) != len(before) {
+		t.Fatalf("got %d vehicles, want %d", len(after), len(before))
+	}
+	for id, value := range before {
+		if after[id] != value {
+			t.Errorf("vehicle %d changed: %+v", id, after[id])
+		}
+	}
+	if deleted, _ := rp.FindDeleted(); len(deleted) != 0 {
+		t.Errorf("got %d deleted vehicles, want 0", len(deleted))
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"app/internal"
+	"strings"
+)
+
+// matchVehicleFilter is a function that returns whether the vehicle matches the filter
+// - the filter is expected to be validated (known fields and values typed after the kind of the field)
+func matchVehicleFilter(f internal.VehicleFilter, v internal.Vehicle) bool {
+	switch {
+	case f.IsZero():
+		return true
+	case f.IsGroup():
+		for _, sub := range f.Filters {
+			ok := matchVehicleFilter(sub, v)
+			if f.Logic == internal.FilterOr && ok {
+				return true
+			}
+			if f.Logic == internal.FilterAnd && !ok {
+				return false
+			}
+		}
+		return f.Logic == internal.FilterAnd
+	}
+
+	field, ok := internal.LookupVehicleField(f.Field)
+	if !ok || len(f.Values) == 0 {
+		return false
+	}
+	value := field.Value(v)
+
+	switch f.Operator {
+	case internal.FilterEq:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) == 0
+	case internal.FilterNe:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) != 0
+	case internal.FilterLt:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) < 0
+	case internal.FilterLte:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) <= 0
+	case internal.FilterGt:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) > 0
+	case internal.FilterGte:
+		return internal.CompareVehicleFieldValues(value, f.Values[0]) >= 0
+	case internal.FilterIn:
+		for _, item := range f.Values {
+			if internal.CompareVehicleFieldValues(value, item) == 0 {
+				return true
+			}
+		}
+		return false
+	case internal.FilterContains:
+		text, _ := value.(string)
+		pattern, _ := f.Values[0].(string)
+		return strings.Contains(strings.ToLower(text), strings.ToLower(pattern))
+	case internal.FilterPrefix:
+		text, _ := value.(string)
+		pattern, _ := f.Values[0].(string)
+		return strings.HasPrefix(strings.ToLower(text), strings.ToLower(pattern))
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"app/internal"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// newVehicleHistory is a function that returns a new history starting now with the given vehicles
+// - earlier versions of the vehicles are unknown, so the history only reaches back to its creation
+func newVehicleHistory(v ...map[int]internal.Vehicle) *vehicleHistory {
+	h := &vehicleHistory{versions: make(map[int][]internal.Vehicle), horizon: time.Now().UTC()}
+	for _, m := range v {
+		for _, value := range m {
+			h.record(value)
+		}
+	}
+	return h
+}
+
+// vehicleHistory is a struct that represents the versions of the vehicles kept in memory
+// - each version is the state of the vehicle from its UpdatedAt until the next one
+// - a version with DeletedAt set ends the vehicle, until a later version restores it
+// - versions are searched by UpdatedAt, the repository never hands out a time before the last one, so they are ordered by it
+// - it is not safe for concurrent use, the repository holding it guards it
+type vehicleHistory struct {
+	// versions are the versions of each vehicle, in the order they were written
+	versions map[int][]internal.Vehicle
+	// horizon is the earliest time the history answers for
+	horizon time.Time
+}
+
+// record is a method that appends the versions written
+func (h *vehicleHistory) record(v ...internal.Vehicle) {
+	for _, value := range v {
+		h.versions[value.Id] = append(h.versions[value.Id], value)
+	}
+}
+
+// check is a method that returns ErrVehicleHistoryUnavailableRepo when the history does not reach back to t
+func (h *vehicleHistory) check(t time.Time) (err error) {
+	if t.Before(h.horizon) {
+		return fmt.Errorf("%w: history starts at %s", internal.ErrVehicleHistoryUnavailableRepo, h.horizon.Format(time.RFC3339Nano))
+	}
+	return
+}
+
+// at is a method that returns the index of the version of the vehicle at time t, -1 when it has none yet
+func (h *vehicleHistory) at(id int, t time.Time) int {
+	versions := h.versions[id]
+	return sort.Search(len(versions), func(i int) bool { return versions[i].UpdatedAt.After(t) }) - 1
+}
+
+// findAll is a method that returns the vehicles that existed at time t
+func (h *vehicleHistory) findAll(t time.Time) (v map[int]internal.Vehicle, err error) {
+	if err = h.check(t); err != nil {
+		return
+	}
+
+	v = make(map[int]internal.Vehicle)
+	for id, versions := range h.versions {
+		if i := h.at(id, t); i >= 0 && versions[i].DeletedAt.IsZero() {
+			v[id] = versions[i]
+		}
+	}
+	return
+}
+
+// findById is a method that returns the vehicle with the given id as it was at time t
+func (h *vehicleHistory) findById(id int, t time.Time) (v internal.Vehicle, err error) {
+	if err = h.check(t); err != nil {
+		return
+	}
+
+	i := h.at(id, t)
+	if i < 0 || !h.versions[id][i].DeletedAt.IsZero() {
+		return v, internal.ErrVehicleNotFoundRepo
+	}
+	return h.versions[id][i], nil
+}
+
+// prune is a method that drops the versions superseded before the given time, returning how many
+// - the version current at before is kept, unless it is a deletion, which is the same as no version
+func (h *vehicleHistory) prune(before time.Time) (n int) {
+	for id, versions := range h.versions {
+		i := h.at(id, before)
+		if i >= 0 && !versions[i].DeletedAt.IsZero() {
+			i++
+		}
+		if i <= 0 {
+			continue
+		}
+
+		n += i
+		if i == len(versions) {
+			delete(h.versions, id)
+			continue
+		}
+		h.versions[id] = append([]internal.Vehicle(nil), versions[i:]...)
+	}
+
+	if before.After(h.horizon) {
+		h.horizon = before
+	}
+	return
+}
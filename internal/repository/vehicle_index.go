@@ -0,0 +1,359 @@
+package repository
+
+import (
+	"app/internal"
+	"math"
+	"sort"
+)
+
+// colorYearKey is a struct that represents the key of the color and year index
+type colorYearKey struct {
+	// color is the color of the vehicle
+	color string
+	// year is the fabrication year of the vehicle
+	year int
+}
+
+// idSet is a set of vehicle ids
+type idSet map[int]struct{}
+
+// newVehicleIndexes is a function that returns the indexes built from db
+// - db is kept to recompute the brand aggregates, it must be the map the indexes are maintained for
+func newVehicleIndexes(db map[int]internal.Vehicle) *vehicleIndexes {
+	ix := &vehicleIndexes{
+		db:           db,
+		brand:        make(map[string]idSet),
+		registration: make(map[string]idSet),
+		colorYear:    make(map[colorYearKey]idSet),
+		aggregates:   make(map[string]*internal.BrandAggregate),
+	}
+
+	// bulk load: append every entry and sort each index once
+	for _, v := range db {
+		ix.addToHashes(v)
+		ix.aggregate(v)
+		ix.weight.entries = append(ix.weight.entries, sortedEntry{value: v.Weight, id: v.Id})
+		ix.length.entries = append(ix.length.entries, sortedEntry{value: v.Length, id: v.Id})
+		ix.width.entries = append(ix.width.entries, sortedEntry{value: v.Width, id: v.Id})
+		ix.year.entries = append(ix.year.entries, sortedEntry{value: float64(v.FabricationYear), id: v.Id})
+	}
+	for _, sx := range []*sortedIndex{&ix.weight, &ix.length, &ix.width, &ix.year} {
+		sort.Slice(sx.entries, func(i, j int) bool {
+			return sx.entries[i].less(sx.entries[j])
+		})
+	}
+
+	return ix
+}
+
+// vehicleIndexes is a struct that holds the secondary indexes of VehicleMap
+// - hash indexes on brand, on normalized registration and on (color, year)
+// - sorted indexes on weight, length, width and fabrication year
+// - running aggregates per brand
+// - it is not safe for concurrent use, VehicleMap guards it with its own lock
+type vehicleIndexes struct {
+	// db is the map of vehicles indexed
+	db map[int]internal.Vehicle
+	// brand maps each brand to the ids of its vehicles
+	brand map[string]idSet
+	// registration maps each normalized registration to the ids of its vehicles, empty registrations are not indexed
+	registration map[string]idSet
+	// colorYear maps each (color, year) pair to the ids of its vehicles
+	colorYear map[colorYearKey]idSet
+	// weight is the sorted index on weight
+	weight sortedIndex
+	// length is the sorted index on length
+	length sortedIndex
+	// width is the sorted index on width
+	width sortedIndex
+	// year is the sorted index on fabrication year
+	year sortedIndex
+	// aggregates maps each brand to the running aggregates of its vehicles
+	aggregates map[string]*internal.BrandAggregate
+}
+
+// add is a method that indexes the vehicle
+func (ix *vehicleIndexes) add(v internal.Vehicle) {
+	ix.addToHashes(v)
+	ix.aggregate(v)
+	ix.weight.insert(v.Weight, v.Id)
+	ix.length.insert(v.Length, v.Id)
+	ix.width.insert(v.Width, v.Id)
+	ix.year.insert(float64(v.FabricationYear), v.Id)
+}
+
+// remove is a method that removes the vehicle from the indexes
+// - v must be the vehicle as it was indexed
+func (ix *vehicleIndexes) remove(v internal.Vehicle) {
+	removeFromHash(ix.brand, v.Brand, v.Id)
+	if key := internal.NormalizeRegistration(v.Registration); key != "" {
+		removeFromHash(ix.registration, key, v.Id)
+	}
+	removeFromHash(ix.colorYear, colorYearKey{color: v.Color, year: v.FabricationYear}, v.Id)
+	ix.disaggregate(v)
+	ix.weight.remove(v.Weight, v.Id)
+	ix.length.remove(v.Length, v.Id)
+	ix.width.remove(v.Width, v.Id)
+	ix.year.remove(float64(v.FabricationYear), v.Id)
+}
+
+// candidates is a method that returns the ids of the vehicles that may match the filter, from the narrowest index that applies
+// - the conditions used are the filter itself or the conditions of a top level and group: brand, registration
+// and the (color, year) pair compared with eq, and ranges of weight, length, width and year
+// - the candidates are a superset of the matches, every one must still be checked against the filter
+// - ok is false when no index applies, so every vehicle must be checked
+func (ix *vehicleIndexes) candidates(f internal.VehicleFilter) (ids []int, ok bool) {
+	conditions := []internal.VehicleFilter{f}
+	if f.Logic == internal.FilterAnd {
+		conditions = f.Filters
+	}
+
+	// collect the hash sets and the ranges of the conditions
+	var sets []idSet
+	sorted := map[string]*sortedIndex{"weight": &ix.weight, "length": &ix.length, "width": &ix.width, "year": &ix.year}
+	ranges := make(map[string][2]float64)
+	var color *string
+	var year *int
+	for _, c := range conditions {
+		if c.IsGroup() || len(c.Values) != 1 {
+			continue
+		}
+		switch value := c.Values[0].(type) {
+		case string:
+			if c.Operator != internal.FilterEq {
+				continue
+			}
+			switch c.Field {
+			case "brand":
+				sets = append(sets, ix.brand[value])
+			case "registration":
+				if key := internal.NormalizeRegistration(value); key != "" {
+					sets = append(sets, ix.registration[key])
+				}
+			case "color":
+				color = &value
+			}
+		case int:
+			if c.Field == "year" && c.Operator == internal.FilterEq {
+				year = &value
+			}
+			narrowRange(ranges, sorted, c, float64(value))
+		case float64:
+			narrowRange(ranges, sorted, c, value)
+		}
+	}
+	if color != nil && year != nil {
+		sets = append(sets, ix.colorYear[colorYearKey{color: *color, year: *year}])
+	}
+
+	// pick the narrowest
+	var best idSet
+	var bestEntries []sortedEntry
+	size := -1
+	for _, set := range sets {
+		if size < 0 || len(set) < size {
+			best, bestEntries, size = set, nil, len(set)
+		}
+	}
+	for field, r := range ranges {
+		if entries := sorted[field].between(r[0], r[1]); size < 0 || len(entries) < size {
+			best, bestEntries, size = nil, entries, len(entries)
+		}
+	}
+	if size < 0 {
+		return nil, false
+	}
+
+	ids = make([]int, 0, size)
+	for id := range best {
+		ids = append(ids, id)
+	}
+	for _, e := range bestEntries {
+		ids = append(ids, e.id)
+	}
+	return ids, true
+}
+
+// narrowRange is a function that narrows the range of the field of the condition, when the field has a sorted index
+// - strict bounds are kept inclusive, the candidates only need to be a superset of the matches
+func narrowRange(ranges map[string][2]float64, sorted map[string]*sortedIndex, c internal.VehicleFilter, bound float64) {
+	if _, indexed := sorted[c.Field]; !indexed {
+		return
+	}
+	r, seen := ranges[c.Field]
+	if !seen {
+		r = [2]float64{math.Inf(-1), math.Inf(1)}
+	}
+	switch c.Operator {
+	case internal.FilterEq:
+		r = [2]float64{max(r[0], bound), min(r[1], bound)}
+	case internal.FilterGt, internal.FilterGte:
+		r[0] = max(r[0], bound)
+	case internal.FilterLt, internal.FilterLte:
+		r[1] = min(r[1], bound)
+	default:
+		return
+	}
+	ranges[c.Field] = r
+}
+
+// addToHashes is a method that indexes the vehicle in the hash indexes
+func (ix *vehicleIndexes) addToHashes(v internal.Vehicle) {
+	addToHash(ix.brand, v.Brand, v.Id)
+	if key := internal.NormalizeRegistration(v.Registration); key != "" {
+		addToHash(ix.registration, key, v.Id)
+	}
+	addToHash(ix.colorYear, colorYearKey{color: v.Color, year: v.FabricationYear}, v.Id)
+}
+
+// aggregate is a method that adds the vehicle to the aggregates of its brand
+func (ix *vehicleIndexes) aggregate(v internal.Vehicle) {
+	a, ok := ix.aggregates[v.Brand]
+	if !ok {
+		a = &internal.BrandAggregate{Brand: v.Brand}
+		ix.aggregates[v.Brand] = a
+	}
+	a.Count++
+	addToMetric(&a.MaxSpeed, v.MaxSpeed)
+	addToMetric(&a.Capacity, float64(v.Capacity))
+	addToMetric(&a.Weight, v.Weight)
+}
+
+// disaggregate is a method that removes the vehicle from the aggregates of its brand
+// - the vehicle must already be removed from the brand index
+// - when the vehicle held a minimum or maximum, the aggregates of the brand are recomputed from the brand index
+func (ix *vehicleIndexes) disaggregate(v internal.Vehicle) {
+	a, ok := ix.aggregates[v.Brand]
+	if !ok {
+		return
+	}
+	if a.Count <= 1 {
+		delete(ix.aggregates, v.Brand)
+		return
+	}
+
+	if isMetricBound(a.MaxSpeed, v.MaxSpeed) || isMetricBound(a.Capacity, float64(v.Capacity)) || isMetricBound(a.Weight, v.Weight) {
+		recomputed := internal.BrandAggregate{Brand: v.Brand}
+		for id := range ix.brand[v.Brand] {
+			vh := ix.db[id]
+			recomputed.Count++
+			addToMetric(&recomputed.MaxSpeed, vh.MaxSpeed)
+			addToMetric(&recomputed.Capacity, float64(vh.Capacity))
+			addToMetric(&recomputed.Weight, vh.Weight)
+		}
+		*a = recomputed
+		return
+	}
+
+	a.Count--
+	removeFromMetric(&a.MaxSpeed, v.MaxSpeed)
+	removeFromMetric(&a.Capacity, float64(v.Capacity))
+	removeFromMetric(&a.Weight, v.Weight)
+}
+
+// addToMetric is a function that adds the value to the running aggregates
+func addToMetric(a *internal.MetricAggregate, value float64) {
+	if a.Count == 0 || value < a.Min {
+		a.Min = value
+	}
+	if a.Count == 0 || value > a.Max {
+		a.Max = value
+	}
+	a.Count++
+	a.Sum += value
+}
+
+// removeFromMetric is a function that removes a value that is neither the minimum nor the maximum
+func removeFromMetric(a *internal.MetricAggregate, value float64) {
+	a.Count--
+	a.Sum -= value
+}
+
+// isMetricBound is a function that returns whether the value is the minimum or the maximum of the aggregates
+func isMetricBound(a internal.MetricAggregate, value float64) bool {
+	return value <= a.Min || value >= a.Max
+}
+
+// addToHash is a function that adds the id to the set of the key
+func addToHash[K comparable](index map[K]idSet, key K, id int) {
+	ids, ok := index[key]
+	if !ok {
+		ids = make(idSet)
+		index[key] = ids
+	}
+	ids[id] = struct{}{}
+}
+
+// removeFromHash is a function that removes the id from the set of the key, dropping empty sets
+func removeFromHash[K comparable](index map[K]idSet, key K, id int) {
+	ids, ok := index[key]
+	if !ok {
+		return
+	}
+	delete(ids, id)
+	if len(ids) == 0 {
+		delete(index, key)
+	}
+}
+
+// sortedEntry is a struct that represents an entry of a sorted index
+type sortedEntry struct {
+	// value is the indexed value
+	value float64
+	// id is the id of the vehicle
+	id int
+}
+
+// less is a method that orders entries by value and then by id
+func (e sortedEntry) less(o sortedEntry) bool {
+	if e.value != o.value {
+		return e.value < o.value
+	}
+	return e.id < o.id
+}
+
+// sortedIndex is a struct that represents an index of vehicle ids sorted by a numeric value
+type sortedIndex struct {
+	// entries are the entries sorted by value and id
+	entries []sortedEntry
+}
+
+// search is a method that returns the position of the first entry not less than e
+func (sx *sortedIndex) search(e sortedEntry) int {
+	return sort.Search(len(sx.entries), func(i int) bool {
+		return !sx.entries[i].less(e)
+	})
+}
+
+// insert is a method that inserts the entry keeping the index sorted
+func (sx *sortedIndex) insert(value float64, id int) {
+	e := sortedEntry{value: value, id: id}
+	i := sx.search(e)
+	sx.entries = append(sx.entries, sortedEntry{})
+	copy(sx.entries[i+1:], sx.entries[i:])
+	sx.entries[i] = e
+}
+
+// remove is a method that removes the entry, if present
+func (sx *sortedIndex) remove(value float64, id int) {
+	e := sortedEntry{value: value, id: id}
+	i := sx.search(e)
+	if i < len(sx.entries) && sx.entries[i] == e {
+		sx.entries = append(sx.entries[:i], sx.entries[i+1:]...)
+	}
+}
+
+// between is a method that returns the entries with a value in the closed range [from, to]
+// - the returned slice shares memory with the index and must not be modified
+func (sx *sortedIndex) between(from, to float64) []sortedEntry {
+	lo := sort.Search(len(sx.entries), func(i int) bool {
+		return sx.entries[i].value >= from
+	})
+	hi := sort.Search(len(sx.entries), func(i int) bool {
+		return sx.entries[i].value > to
+	})
+	if lo >= hi {
+		return nil
+	}
+	return sx.entries[lo:hi]
+}
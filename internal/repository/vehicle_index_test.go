@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"app/internal"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// indexedFilters are filters whose conditions VehicleMap answers from its indexes, keyed by name
+var indexedFilters = map[string]internal.VehicleFilter{
+	"brand":        {Field: "brand", Operator: internal.FilterEq, Values: []any{"Fiat"}},
+	"registration": {Field: "registration", Operator: internal.FilterEq, Values: []any{"REG-00042"}},
+	"color and year": {Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "color", Operator: internal.FilterEq, Values: []any{"red"}},
+		{Field: "year", Operator: internal.FilterEq, Values: []any{2004}},
+	}},
+	"brand between years": {Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "brand", Operator: internal.FilterEq, Values: []any{"Ford"}},
+		{Field: "year", Operator: internal.FilterGte, Values: []any{2005}},
+		{Field: "year", Operator: internal.FilterLte, Values: []any{2010}},
+	}},
+	"weight range": {Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "weight", Operator: internal.FilterGte, Values: []any{1000.0}},
+		{Field: "weight", Operator: internal.FilterLte, Values: []any{1100.0}},
+	}},
+	"strict dimensions": {Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "length", Operator: internal.FilterGt, Values: []any{3.0}},
+		{Field: "length", Operator: internal.FilterLte, Values: []any{4.0}},
+		{Field: "width", Operator: internal.FilterLt, Values: []any{1.7}},
+		{Field: "model", Operator: internal.FilterContains, Values: []any{"1"}},
+	}},
+	"no match": {Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "brand", Operator: internal.FilterEq, Values: []any{"Unknown"}},
+		{Field: "weight", Operator: internal.FilterGte, Values: []any{0.0}},
+	}},
+	"or group": {Logic: internal.FilterOr, Filters: []internal.VehicleFilter{
+		{Field: "brand", Operator: internal.FilterEq, Values: []any{"Fiat"}},
+		{Field: "weight", Operator: internal.FilterLt, Values: []any{950.0}},
+	}},
+}
+
+// scanVehicleMap is a function that returns the vehicles of rp matching the filter without using the indexes
+func scanVehicleMap(rp *VehicleMap, f internal.VehicleFilter) (v map[int]internal.Vehicle) {
+	rp.mu.RLock()
+	defer rp.mu.RUnlock()
+
+	v = make(map[int]internal.Vehicle)
+	for id, value := range rp.db {
+		if matchVehicleFilter(f, value) {
+			v[id] = value
+		}
+	}
+	return
+}
+
+func TestVehicleMap_FindByFilterMatchesScan(t *testing.T) {
+	rp := newTestVehicleMap(1000)
+
+	for name, f := range indexedFilters {
+		t.Run(name, func(t *testing.T) {
+			got, err := rp.FindByFilter(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := scanVehicleMap(rp, f); !reflect.DeepEqual(got, want) {
+				t.Errorf("got %d vehicles, want %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// assertIndexesInSync is a function that fails the test when the indexes of rp differ from indexes rebuilt from its vehicles
+func assertIndexesInSync(t *testing.T, rp *VehicleMap) {
+	t.Helper()
+
+	rebuilt := newVehicleIndexes(rp.db)
+	hashes := map[string][2]any{
+		"brand":        {rp.ix.brand, rebuilt.brand},
+		"registration": {rp.ix.registration, rebuilt.registration},
+		"color year":   {rp.ix.colorYear, rebuilt.colorYear},
+	}
+	for name, pair := range hashes {
+		if !reflect.DeepEqual(pair[0], pair[1]) {
+			t.Errorf("%s index out of sync", name)
+		}
+	}
+	sorted := map[string][2]sortedIndex{
+		"weight": {rp.ix.weight, rebuilt.weight},
+		"length": {rp.ix.length, rebuilt.length},
+		"width":  {rp.ix.width, rebuilt.width},
+		"year":   {rp.ix.year, rebuilt.year},
+	}
+	for name, pair := range sorted {
+		if !slices.Equal(pair[0].entries, pair[1].entries) {
+			t.Errorf("%s index out of sync", name)
+		}
+	}
+
+	// sums are kept incrementally, they may differ by rounding
+	if len(rp.ix.aggregates) != len(rebuilt.aggregates) {
+		t.Errorf("got aggregates of %d brands, want %d", len(rp.ix.aggregates), len(rebuilt.aggregates))
+	}
+	for brand, want := range rebuilt.aggregates {
+		got, ok := rp.ix.aggregates[brand]
+		if !ok {
+			t.Errorf("aggregates of %s missing", brand)
+			continue
+		}
+		for _, m := range [][2]internal.MetricAggregate{{got.MaxSpeed, want.MaxSpeed}, {got.Capacity, want.Capacity}, {got.Weight, want.Weight}} {
+			if got.Count != want.Count || m[0].Count != m[1].Count || m[0].Min != m[1].Min || m[0].Max != m[1].Max || math.Abs(m[0].Sum-m[1].Sum) > 1e-6 {
+				t.Errorf("aggregates of %s out of sync: got %+v, want %+v", brand, *got, *want)
+			}
+		}
+	}
+}
+
+func TestVehicleMap_IndexesInSync(t *testing.T) {
+	rp := newTestVehicleMap(300)
+
+	// update every indexed attribute, including the brand bounds
+	for id := 1; id <= 300; id += 7 {
+		v, _ := rp.FindById(id)
+		v.Brand = []string{"Ford", "Fiat", "Toyota", "Renault"}[id%4]
+		v.Registration = fmt.Sprintf("NEW-%05d", id)
+		v.Color = "green"
+		v.FabricationYear += 3
+		v.Weight = float64(id * 10)
+		v.Length, v.Width = 5, 2
+		v.MaxSpeed, v.Capacity = 400, 9
+		if _, err := rp.Update(&v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertIndexesInSync(t, rp)
+
+	// delete, including every vehicle of a brand, and restore some
+	for id := 1; id <= 300; id += 3 {
+		if _, err := rp.Delete(id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renault, _ := rp.FindByFilter(internal.VehicleFilter{Field: "brand", Operator: internal.FilterEq, Values: []any{"Renault"}})
+	for id := range renault {
+		if _, err := rp.Delete(id, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id := 1; id <= 300; id += 9 {
+		if _, err := rp.Restore(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	assertIndexesInSync(t, rp)
+}
+
+// BenchmarkVehicleMap_FindByFilter compares the filters answered from the indexes with a scan of every vehicle
+func BenchmarkVehicleMap_FindByFilter(b *testing.B) {
+	rp := newTestVehicleMap(100000)
+
+	for _, name := range []string{"registration", "color and year", "brand between years", "weight range"} {
+		f := indexedFilters[name]
+		b.Run(name+"/indexed", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := rp.FindByFilter(f); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(name+"/scan", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				scanVehicleMap(rp, f)
+			}
+		})
+	}
+}
+
+// BenchmarkVehicleMap_GetSpeedAvgByBrand compares the running brand aggregates with averaging a scan of every vehicle
+func BenchmarkVehicleMap_GetSpeedAvgByBrand(b *testing.B) {
+	rp := newTestVehicleMap(100000)
+	f := indexedFilters["brand"]
+
+	b.Run("indexed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := rp.GetSpeedAvgByBrand("Fiat"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("scan", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var sum float64
+			v := scanVehicleMap(rp, f)
+			for _, value := range v {
+				sum += value.MaxSpeed
+			}
+			_ = sum / float64(len(v))
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"app/internal"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const (
+	// JournalOpPut is the journal operation that stores a vehicle (create, update, delete to and restore from the trash)
+	JournalOpPut = "put"
+	// JournalOpPutBatch is the journal operation that stores a batch of vehicles (create multiple)
+	JournalOpPutBatch = "put_batch"
+	// JournalOpDelete is the journal operation that removes a vehicle permanently (purge)
+	JournalOpDelete = "delete"
+)
+
+// ErrVehicleJournalBroken is returned by Append once a failed append could not be undone
+// - the journal holds an entry that was reported as failed, it is refused until a compaction drops it
+var ErrVehicleJournalBroken = errors.New("vehicle journal is broken")
+
+// journalFile is an interface that represents the journal file opened for appending
+type journalFile interface {
+	io.WriteCloser
+	// Sync is a method that commits the file to disk
+	Sync() (err error)
+	// Truncate is a method that changes the size of the file
+	Truncate(size int64) (err error)
+	// Stat is a method that returns the information of the file
+	Stat() (fi os.FileInfo, err error)
+}
+
+// JournalEntry is a struct that represents an operation recorded in the journal
+type JournalEntry struct {
+	// Op is the operation
+	Op string `json:"op"`
+	// Id is the id of the vehicle affected by the operation
+	Id int `json:"id"`
+	// Vehicle is the vehicle stored by a put operation
+	Vehicle *internal.Vehicle `json:"vehicle,omitempty"`
+	// Vehicles are the vehicles stored by a put batch operation
+	Vehicles []internal.Vehicle `json:"vehicles,omitempty"`
+}
+
+// NewVehicleJournal is a function that returns a new instance of VehicleJournal
+// - the previous generation, being compacted, is kept next to path with the ".prev" suffix
+func NewVehicleJournal(path string) *VehicleJournal {
+	return &VehicleJournal{path: path, prevPath: path + ".prev"}
+}
+
+// VehicleJournal is a struct that represents an append-only journal of vehicle operations
+// - entries are stored one JSON object per line and synced to disk before Append returns
+// - a failed append is cut from the file, so entries reported as failed are never replayed
+// - entries are idempotent (put / delete) so replaying a journal over a snapshot that already
+// contains part of it yields the same state
+// - compacting rotates the entries to a previous generation, so appends go on while the snapshot is saved
+type VehicleJournal struct {
+	// mu guards file, broken and repair
+	mu sync.Mutex
+	// path is the path to the journal file
+	path string
+	// prevPath is the path to the previous generation, removed once compacted
+	prevPath string
+	// file is the journal file opened for appending
+	file journalFile
+	// broken is why a failed append could not be cut from the file, nil when it was
+	broken error
+	// repair is whether the entry that broke the journal was rotated, so the compaction drops it
+	repair bool
+}
+
+// Replay is a method that applies the journal entries on top of db
+// - the previous generation, left by a compaction that did not finish, is applied first
+// - a torn last line (crash in the middle of an append) is discarded and cut from the file
+func (j *VehicleJournal) Replay(db map[int]internal.Vehicle) (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if _, err = os.Stat(j.prevPath); err == nil {
+		if err = replayJournalFile(j.prevPath, db); err != nil {
+			return
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	return replayJournalFile(j.path, db)
+}
+
+// replayJournalFile is a function that applies the entries of the journal file at path on top of db
+func replayJournalFile(path string, db map[int]internal.Vehicle) (err error) {
+	// open file
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	// apply entries
+	var offset int64
+	reader := bufio.NewReader(file)
+	for {
+		var line []byte
+		line, err = reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// last line without newline: torn write
+			if len(line) > 0 {
+				err = file.Truncate(offset)
+				return
+			}
+			return nil
+		}
+		if err != nil {
+			return
+		}
+
+		var entry JournalEntry
+		if err = json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+			return fmt.Errorf("journal %s at offset %d: %w", path, offset, err)
+		}
+		if err = applyJournalEntry(db, entry); err != nil {
+			return fmt.Errorf("journal %s at offset %d: %w", path, offset, err)
+		}
+		offset += int64(len(line))
+	}
+}
+
+// Append is a method that writes the entries at the end of the journal and syncs them to disk
+// - when the write or the sync fails, the file is truncated back to where the entries started
+// - when that fails too, the journal is broken and every next append fails with ErrVehicleJournalBroken
+func (j *VehicleJournal) Append(entries ...JournalEntry) (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.broken != nil {
+		return fmt.Errorf("%w: %w", ErrVehicleJournalBroken, j.broken)
+	}
+
+	// open file
+	if j.file == nil {
+		j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return
+		}
+	}
+
+	// encode entries
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			return
+		}
+	}
+
+	// write entries, remembering where they start
+	info, err := j.file.Stat()
+	if err != nil {
+		return
+	}
+	if _, err = j.file.Write(buf.Bytes()); err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		// - the entries may be on disk, in part or in full
+		if e := j.file.Truncate(info.Size()); e != nil {
+			j.broken = e
+			return fmt.Errorf("%w: %w", err, e)
+		}
+		if e := j.file.Sync(); e != nil {
+			j.broken = e
+			return fmt.Errorf("%w: %w", err, e)
+		}
+	}
+	return
+}
+
+// Compact is a method that saves db as the new snapshot through the storer and drops the previous generation
+// - db is expected to hold every entry of the previous generation, see Rotate
+// - appends go on meanwhile, to the current generation
+// - a journal broken when rotated is repaired, the entry that could not be cut was in the previous generation
+func (j *VehicleJournal) Compact(db map[int]internal.Vehicle, st internal.VehicleStorer) (err error) {
+	// save snapshot
+	// - if the process dies before the previous generation is removed, replaying it over the new snapshot is harmless
+	if err = st.Save(db); err != nil {
+		return
+	}
+
+	// drop previous generation
+	if err = os.Remove(j.prevPath); err != nil {
+		return
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.repair {
+		j.broken, j.repair = nil, false
+	}
+	return
+}
+
+// Rotate is a method that moves the entries appended so far to the previous generation, starting an empty journal
+// - a previous generation left by a failed compaction is kept, the entries are appended to it
+func (j *VehicleJournal) Rotate() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	// appends are refused while broken, the entry that broke it goes to the previous generation
+	j.repair = j.broken != nil
+
+	// close file, the next append opens the new generation
+	if j.file != nil {
+		if err = j.file.Close(); err != nil {
+			return
+		}
+		j.file = nil
+	}
+
+	// move entries
+	if _, err = os.Stat(j.prevPath); errors.Is(err, os.ErrNotExist) {
+		err = os.Rename(j.path, j.prevPath)
+		if errors.Is(err, os.ErrNotExist) {
+			err = os.WriteFile(j.prevPath, nil, 0644)
+		}
+		return
+	}
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadFile(j.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	prev, err := os.OpenFile(j.prevPath, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	defer prev.Close()
+	if _, err = prev.Write(entries); err != nil {
+		return
+	}
+	if err = prev.Sync(); err != nil {
+		return
+	}
+	err = os.Truncate(j.path, 0)
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return
+}
+
+// Close is a method that closes the journal file
+func (j *VehicleJournal) Close() (err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return
+	}
+	err = j.file.Close()
+	j.file = nil
+	return
+}
+
+// applyJournalEntry is a function that applies the operation of the entry to db
+func applyJournalEntry(db map[int]internal.Vehicle, entry JournalEntry) (err error) {
+	switch entry.Op {
+	case JournalOpPut:
+		if entry.Vehicle != nil {
+			db[entry.Id] = *entry.Vehicle
+		}
+	case JournalOpPutBatch:
+		for _, v := range entry.Vehicles {
+			db[v.Id] = v
+		}
+	case JournalOpDelete:
+		delete(db, entry.Id)
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Op)
+	}
+	return
+}
@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"app/internal"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingStorer is a struct that implements internal.VehicleStorer blocking every save until released
+type blockingStorer struct {
+	// saving receives the vehicles of every save once it started
+	saving chan map[int]internal.Vehicle
+	// release lets the save return
+	release chan struct{}
+}
+
+// Save is a method that blocks until the save is released
+func (s *blockingStorer) Save(v map[int]internal.Vehicle) (err error) {
+	s.saving <- v
+	<-s.release
+	return
+}
+
+// tornFile is a struct that implements journalFile writing part of every write before failing
+type tornFile struct {
+	*os.File
+	// truncErr is returned by Truncate when set
+	truncErr error
+}
+
+// Write is a method that writes half of p and fails
+func (f *tornFile) Write(p []byte) (n int, err error) {
+	n, _ = f.File.Write(p[:len(p)/2])
+	return n, errors.New("disk full")
+}
+
+// Truncate is a method that truncates the file, or returns truncErr
+func (f *tornFile) Truncate(size int64) (err error) {
+	if f.truncErr != nil {
+		return f.truncErr
+	}
+	return f.File.Truncate(size)
+}
+
+// newTestVehicleJournal is a function that returns a VehicleMap seeded with n vehicles backed by a journal in a temporary directory
+func newTestVehicleJournal(t *testing.T, n int) (rp *VehicleMap, jr *VehicleJournal) {
+	t.Helper()
+
+	jr = NewVehicleJournal(filepath.Join(t.TempDir(), "vehicles.journal"))
+	t.Cleanup(func() { jr.Close() })
+	return NewVehicleMapWithJournal(newTestVehicleMap(n).snapshot(), jr), jr
+}
+
+func TestVehicleMap_CompactDoesNotBlock(t *testing.T) {
+	rp, jr := newTestVehicleJournal(t, 3)
+	st := &blockingStorer{saving: make(chan map[int]internal.Vehicle), release: make(chan struct{})}
+
+	done := make(chan error)
+	go func() { done <- rp.Compact(st) }()
+	saved := <-st.saving
+
+	// reads and writes go on while the snapshot is saved
+	if _, err := rp.FindAll(); err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVehicle(10)
+	if err := rp.Create(&v); err != nil {
+		t.Fatal(err)
+	}
+	close(st.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// the snapshot misses the write, the journal has it
+	if len(saved) != 3 {
+		t.Errorf("got %d vehicles saved, want 3", len(saved))
+	}
+	if err := jr.Replay(saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved[v.Id] != v {
+		t.Errorf("got %+v replayed, want %+v", saved[v.Id], v)
+	}
+}
+
+func TestVehicleMap_FailedCompactionIsReplayed(t *testing.T) {
+	rp, jr := newTestVehicleJournal(t, 3)
+	base := newTestVehicleMap(3).snapshot()
+
+	first := newTestVehicle(10)
+	if err := rp.Create(&first); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Compact(&storerStub{err: errors.New("disk full")}); err == nil {
+		t.Fatal("got nil error, want the save error")
+	}
+	second := newTestVehicle(11)
+	if err := rp.Create(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	// both generations are replayed over the last snapshot saved
+	replayed := newTestVehicleMap(3).snapshot()
+	if err := jr.Replay(replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed[first.Id] != first || replayed[second.Id] != second {
+		t.Errorf("got %+v and %+v replayed", replayed[first.Id], replayed[second.Id])
+	}
+
+	// the next compaction saves both and drops the previous generation
+	st := &storerStub{}
+	if err := rp.Compact(st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.saved) != len(base)+2 {
+		t.Errorf("got %d vehicles saved, want %d", len(st.saved), len(base)+2)
+	}
+	if _, err := os.Stat(jr.prevPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got %v, want the previous generation removed", err)
+	}
+}
+
+func TestVehicleMap_FailedAppendIsNotReplayed(t *testing.T) {
+	rp, jr := newTestVehicleJournal(t, 3)
+	first := newTestVehicle(10)
+	if err := rp.Create(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	// the torn write is cut from the file
+	file := jr.file.(*os.File)
+	jr.file = &tornFile{File: file}
+	failed := newTestVehicle(11)
+	if err := rp.Create(&failed); err == nil {
+		t.Fatal("got nil error, want the write error")
+	}
+	jr.file = file
+	second := newTestVehicle(12)
+	if err := rp.Create(&second); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the writes reported as done are replayed
+	replayed := newTestVehicleMap(3).snapshot()
+	if err := jr.Replay(replayed); err != nil {
+		t.Fatal(err)
+	}
+	if len(replayed) != 5 || replayed[first.Id] != first || replayed[second.Id] != second {
+		t.Errorf("got %+v replayed, want vehicles 1 to 3, %d and %d", replayed, first.Id, second.Id)
+	}
+}
+
+func TestVehicleMap_BrokenJournalRefusesAppends(t *testing.T) {
+	rp, jr := newTestVehicleJournal(t, 3)
+	first := newTestVehicle(10)
+	if err := rp.Create(&first); err != nil {
+		t.Fatal(err)
+	}
+
+	// the torn write can not be cut
+	file := jr.file.(*os.File)
+	jr.file = &tornFile{File: file, truncErr: errors.New("io error")}
+	failed := newTestVehicle(11)
+	if err := rp.Create(&failed); err == nil {
+		t.Fatal("got nil error, want the write error")
+	}
+	jr.file = file
+	second := newTestVehicle(12)
+	if err := rp.Create(&second); !errors.Is(err, ErrVehicleJournalBroken) {
+		t.Fatalf("got %v, want ErrVehicleJournalBroken", err)
+	}
+
+	// a compaction drops the torn write and repairs it
+	if err := rp.Compact(&storerStub{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rp.Create(&second); err != nil {
+		t.Fatal(err)
+	}
+	replayed := newTestVehicleMap(3).snapshot()
+	if err := jr.Replay(replayed); err != nil {
+		t.Fatal(err)
+	}
+	if replayed[second.Id] != second {
+		t.Errorf("got %+v replayed, want %+v", replayed[second.Id], second)
+	}
+}
+
+func TestVehicleMap_FailedWriteKeepsSequence(t *testing.T) {
+	st := &storerStub{err: errors.New("disk full")}
+	rp := NewVehicleFile(newTestVehicleMap(3).snapshot(), st)
+
+	// the caller's vehicles are left as they were
+	v := newTestVehicle(10)
+	if err := rp.Create(&v); err == nil {
+		t.Fatal("got nil error, want the save error")
+	}
+	batch := []internal.Vehicle{newTestVehicle(11), newTestVehicle(12)}
+	if err := rp.CreateMultiple(batch); err == nil {
+		t.Fatal("got nil error, want the save error")
+	}
+	if v.Id != 0 || batch[0].Id != 0 || batch[1].Id != 0 || !batch[0].UpdatedAt.IsZero() {
+		t.Errorf("got ids %d, %d and %d, want 0", v.Id, batch[0].Id, batch[1].Id)
+	}
+
+	// no id was used up
+	st.err = nil
+	if err := rp.Create(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Id != 4 {
+		t.Errorf("got id %d, want 4", v.Id)
+	}
+	if err := rp.CreateMultiple(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Id != 5 || batch[1].Id != 6 || batch[0].UpdatedAt.Before(time.Now().Add(-time.Minute)) {
+		t.Errorf("got %+v", batch)
+	}
+}
@@ -3,27 +3,98 @@ package repository
 import (
 	"app/internal"
 	"fmt"
-	"math"
+	"slices"
+	"sort"
+	"sync"
+	"time"
 )
 
 // NewVehicleMap is a function that returns a new instance of VehicleMap
+// - vehicles of db with DeletedAt set are moved to the trash
+// - the history starts with the vehicles of db, so it reaches back to now
 func NewVehicleMap(db map[int]internal.Vehicle) *VehicleMap {
 	// default db
 	defaultDb := make(map[int]internal.Vehicle)
 	if db != nil {
 		defaultDb = db
 	}
-	return &VehicleMap{db: defaultDb}
+	// trash
+	trash := make(map[int]internal.Vehicle)
+	for id, v := range defaultDb {
+		if !v.DeletedAt.IsZero() {
+			trash[id] = v
+			delete(defaultDb, id)
+		}
+	}
+	// sequence starts at the highest id, deleted ones included, the clock at the history start or the latest modification
+	hs := newVehicleHistory(defaultDb, trash)
+	var seq int
+	clock := hs.horizon
+	for _, m := range []map[int]internal.Vehicle{defaultDb, trash} {
+		for id, v := range m {
+			seq = max(seq, id)
+			if v.UpdatedAt.After(clock) {
+				clock = v.UpdatedAt
+			}
+		}
+	}
+	return &VehicleMap{db: defaultDb, trash: trash, ix: newVehicleIndexes(defaultDb), hs: hs, seq: seq, clock: clock}
+}
+
+// NewVehicleMapWithJournal is a function that returns a new instance of VehicleMap backed by a journal
+// - db is expected to already have the journal replayed on top of it
+func NewVehicleMapWithJournal(db map[int]internal.Vehicle, jr *VehicleJournal) *VehicleMap {
+	r := NewVehicleMap(db)
+	r.jr = jr
+	return r
 }
 
 // VehicleMap is a struct that represents a vehicle repository
+// - it is safe for concurrent use: readers share the lock, writers take it exclusively
+// - queries are answered from secondary indexes kept consistent with db on every write
+// - ids are allocated from a sequence that only grows, client-provided ids above it move it forward
+// - deleted vehicles are moved from db to trash, so they are out of the indexes and every query
+// - every version written is kept in memory in hs, it is not persisted
 type VehicleMap struct {
+	// mu guards db, trash, ix and hs
+	mu sync.RWMutex
 	// db is a map of vehicles
 	db map[int]internal.Vehicle
+	// trash is a map of the deleted vehicles
+	trash map[int]internal.Vehicle
+	// ix are the secondary indexes over db
+	ix *vehicleIndexes
+	// hs is the history of the versions written
+	hs *vehicleHistory
+	// jr is the optional journal every write is recorded in before it is applied
+	jr *VehicleJournal
+	// st is the optional storer every write is saved through, with the change applied, before it is applied
+	st internal.VehicleStorer
+	// cm serializes compactions of jr
+	cm sync.Mutex
+	// seq is the last id allocated or stored
+	seq int
+	// clock is the last modification time handed out, guarded by mu
+	clock time.Time
+}
+
+// now is a method that returns the modification time of a write, never before the last one
+// - the wall clock may be stepped back, the versions in hs must still be ordered by time as they are by version
+// - it is expected to be called with the write lock held
+func (r *VehicleMap) now() (t time.Time) {
+	t = time.Now().UTC()
+	if t.Before(r.clock) {
+		t = r.clock
+	}
+	r.clock = t
+	return
 }
 
 // FindAll is a method that returns a map of all vehicles
 func (r *VehicleMap) FindAll() (v map[int]internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	v = make(map[int]internal.Vehicle)
 
 	// copy db
@@ -34,167 +105,518 @@ func (r *VehicleMap) FindAll() (v map[int]internal.Vehicle, err error) {
 	return
 }
 
-func (r *VehicleMap) Create(v internal.Vehicle) (err error) {
+// FindById is a method that returns the vehicle with the given id
+func (r *VehicleMap) FindById(id int) (v internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v, ok := r.db[id]
+	if !ok {
+		return v, internal.ErrVehicleNotFoundRepo
+	}
+
+	return
+}
+
+// FindByRegistration is a method that returns the vehicle with the given registration
+func (r *VehicleMap) FindByRegistration(registration string) (v internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// lookup registration index, the lowest id wins when the registration is shared
+	id := 0
+	for candidate := range r.ix.registration[internal.NormalizeRegistration(registration)] {
+		if id == 0 || candidate < id {
+			id = candidate
+		}
+	}
+	if id == 0 {
+		return v, internal.ErrNoVehicleByRegistrationRepo
+	}
+
+	return r.db[id], nil
+}
+
+func (r *VehicleMap) Create(v *internal.Vehicle) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
 	//Check if vehicle already exists
-	if _, ok := r.db[v.Id]; ok {
+	if r.idTaken(v.Id) {
 		return internal.ErrVehicleAlreadyExistsRepo
 	}
+	if r.registrationTaken(v.Registration, v.Id) {
+		return &internal.VehicleRegistrationConflictError{Existing: []string{v.Registration}}
+	}
+	// allocate id on a copy of the sequence, committed once recorded
+	seq := r.seq
+	created := *v
+	created.Id = nextId(&seq, v.Id)
+	created.Version = 1
+	created.UpdatedAt = r.now()
+
+	// record in journal
+	if err = r.journal(JournalEntry{Op: JournalOpPut, Id: created.Id, Vehicle: &created}); err != nil {
+		return
+	}
 
 	// add vehicle to db
-	r.db[v.Id] = v
+	r.db[created.Id] = created
+	r.ix.add(created)
+	r.hs.record(created)
+	r.seq = seq
+	*v = created
 
 	// return nil error
 	return nil
 }
 
-func (r *VehicleMap) GetByColorAndYear(color string, year int) (v map[int]internal.Vehicle, err error) {
-	v = make(map[int]internal.Vehicle)
+func (r *VehicleMap) GetSpeedAvgByBrand(brand string) (speedAvg float64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	// copy db
-	for key, value := range r.db {
-		if value.Color == color && value.FabricationYear == year {
-			v[key] = value
+	// lookup brand aggregates
+	a, ok := r.ix.aggregates[brand]
+	if !ok {
+		return 0, internal.ErrNoVehiclesByBrandRepo
+	}
+
+	return a.MaxSpeed.Avg(), nil
+}
+
+func (r *VehicleMap) CreateMultiple(v []internal.Vehicle) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// check every id before inserting any vehicle
+	conflict := &internal.VehicleConflictError{Duplicated: duplicatedIds(v)}
+	for _, value := range v {
+		if r.idTaken(value.Id) {
+			conflict.Existing = append(conflict.Existing, value.Id)
+		}
+	}
+	if len(conflict.Existing) > 0 || len(conflict.Duplicated) > 0 {
+		sort.Ints(conflict.Existing)
+		return conflict
+	}
+
+	// check every registration
+	registrationConflict := &internal.VehicleRegistrationConflictError{Duplicated: duplicatedRegistrations(v)}
+	for _, value := range v {
+		if r.registrationTaken(value.Registration, value.Id) {
+			registrationConflict.Existing = append(registrationConflict.Existing, value.Registration)
 		}
 	}
+	if len(registrationConflict.Existing) > 0 || len(registrationConflict.Duplicated) > 0 {
+		sort.Strings(registrationConflict.Existing)
+		return registrationConflict
+	}
 
-	if len(v) == 0 {
-		return nil, internal.ErrNoVehiclesByColorYearRepo
+	// allocate ids after the highest id provided, so none of them is taken by the sequence
+	// - on copies of the vehicles and the sequence, committed once recorded
+	created := slices.Clone(v)
+	seq := r.seq
+	for _, value := range created {
+		seq = max(seq, value.Id)
+	}
+	now := r.now()
+	for i := range created {
+		created[i].Id = nextId(&seq, created[i].Id)
+		created[i].Version = 1
+		created[i].UpdatedAt = now
 	}
 
-	return
+	// record in journal as a single entry, so the batch is replayed whole or not at all
+	if err = r.journal(JournalEntry{Op: JournalOpPutBatch, Vehicles: created}); err != nil {
+		return
+	}
+
+	// add vehicles to db
+	for _, value := range created {
+		r.db[value.Id] = value
+		r.ix.add(value)
+	}
+	r.hs.record(created...)
+	r.seq = seq
+	copy(v, created)
+
+	// return nil error
+	return nil
 }
 
-func (r *VehicleMap) GetByBrandBetweenYears(brand string, yearStart int, yearEnd int) (v map[int]internal.Vehicle, err error) {
-	v = make(map[int]internal.Vehicle)
+func (r *VehicleMap) Update(v *internal.Vehicle) (previous internal.Vehicle, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// copy db
-	for key, value := range r.db {
-		if value.Brand == brand && value.FabricationYear >= yearStart && value.FabricationYear <= yearEnd {
-			v[key] = value
-		}
+	// check if vehicle exists
+	stored, ok := r.db[v.Id]
+	if !ok {
+		return previous, internal.ErrVehicleNotFoundRepo
+	}
+
+	// check version
+	if v.Version != 0 && v.Version != stored.Version {
+		return previous, internal.ErrVehicleVersionConflictRepo
+	}
+
+	// check registration, only when it changes so vehicles sharing one from before can still be updated
+	changed := internal.NormalizeRegistration(v.Registration) != internal.NormalizeRegistration(stored.Registration)
+	if changed && r.registrationTaken(v.Registration, v.Id) {
+		return previous, &internal.VehicleRegistrationConflictError{Existing: []string{v.Registration}}
+	}
+	updated := *v
+	updated.Version = stored.Version + 1
+	updated.UpdatedAt = r.now()
+
+	// record in journal
+	if err = r.journal(JournalEntry{Op: JournalOpPut, Id: v.Id, Vehicle: &updated}); err != nil {
+		return
+	}
+
+	// update vehicle
+	r.db[v.Id] = updated
+	r.ix.remove(stored)
+	r.ix.add(updated)
+	r.hs.record(updated)
+	*v = updated
+
+	// return the vehicle as it was
+	return stored, nil
+}
+
+func (r *VehicleMap) Delete(id int, version int) (v internal.Vehicle, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// check if vehicle exists
+	previous, ok := r.db[id]
+	if !ok {
+		return v, internal.ErrVehicleNotFoundRepo
 	}
 
-	if len(v) == 0 {
-		return nil, internal.ErrNoVehiclesByBrandYearsRepo
+	// check version
+	if version != 0 && version != previous.Version {
+		return v, internal.ErrVehicleVersionConflictRepo
+	}
+
+	deleted := previous
+	deleted.Version = previous.Version + 1
+	deleted.UpdatedAt = r.now()
+	deleted.DeletedAt = deleted.UpdatedAt
+
+	// record in journal
+	if err = r.journal(JournalEntry{Op: JournalOpPut, Id: id, Vehicle: &deleted}); err != nil {
+		return
+	}
+
+	// move vehicle to trash
+	delete(r.db, id)
+	r.ix.remove(previous)
+	r.trash[id] = deleted
+	r.hs.record(deleted)
+
+	// return the vehicle as it is in the trash
+	return deleted, nil
+}
+
+// FindDeleted is a method that returns the vehicles in the trash
+func (r *VehicleMap) FindDeleted() (v map[int]internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	v = make(map[int]internal.Vehicle, len(r.trash))
+
+	// copy trash
+	for key, value := range r.trash {
+		v[key] = value
 	}
 
 	return
 }
 
-func (r *VehicleMap) GetSpeedAvgByBrand(brand string) (speedAvg float64, err error) {
-	speedAvg = 0
-	count := 0
+// Restore is a method that moves the vehicle with the given id out of the trash
+func (r *VehicleMap) Restore(id int) (v internal.Vehicle, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// copy db
-	for _, value := range r.db {
-		if value.Brand == brand {
-			speedAvg += value.MaxSpeed
-			count++
+	// check if vehicle is in trash
+	previous, ok := r.trash[id]
+	if !ok {
+		if _, ok = r.db[id]; ok {
+			return v, internal.ErrVehicleNotDeletedRepo
 		}
+		return v, internal.ErrVehicleNotFoundRepo
 	}
 
-	if count == 0 {
-		return 0, internal.ErrNoVehiclesByBrandRepo
+	v = previous
+	v.Version = previous.Version + 1
+	v.UpdatedAt = r.now()
+	v.DeletedAt = time.Time{}
+
+	// record in journal
+	if err = r.journal(JournalEntry{Op: JournalOpPut, Id: id, Vehicle: &v}); err != nil {
+		return internal.Vehicle{}, err
 	}
 
-	return speedAvg / float64(count), nil
+	// move vehicle out of trash
+	delete(r.trash, id)
+	r.db[id] = v
+	r.ix.add(v)
+	r.hs.record(v)
+
+	return v, nil
 }
 
-func (r *VehicleMap) CreateMultiple(v map[int]internal.Vehicle) (err error) {
-	// add vehicles to db
-	for key, value := range v {
-		if _, ok := r.db[key]; ok {
-			return fmt.Errorf("%w key: %d", internal.ErrVehicleAlreadyExistsRepo, key)
+// Purge is a method that permanently removes the vehicles deleted before the given time
+func (r *VehicleMap) Purge(before time.Time) (ids []int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// vehicles to purge
+	var entries []JournalEntry
+	for id, value := range r.trash {
+		if value.DeletedAt.Before(before) {
+			ids = append(ids, id)
+			entries = append(entries, JournalEntry{Op: JournalOpDelete, Id: id})
 		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+	sort.Ints(ids)
 
-		r.db[key] = value
+	// record in journal
+	if err = r.journal(entries...); err != nil {
+		return nil, err
 	}
 
-	// return nil error
-	return nil
+	// delete vehicles
+	for _, id := range ids {
+		delete(r.trash, id)
+	}
+
+	return
+}
+
+func (r *VehicleMap) GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// lookup brand aggregates
+	a, ok := r.ix.aggregates[brand]
+	if !ok {
+		return 0, internal.ErrNoVehiclesByBrandRepo
+	}
+
+	return a.Capacity.Avg(), nil
 }
 
-func (r *VehicleMap) ListByWeightRange(weightMin, weightMax float64) (v map[int]internal.Vehicle, err error) {
+// FindByFilter is a method that returns the vehicles matching the filter
+// - the candidates are looked up in the narrowest index the conditions allow, every vehicle is checked otherwise
+func (r *VehicleMap) FindByFilter(f internal.VehicleFilter) (v map[int]internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
 	v = make(map[int]internal.Vehicle)
 
-	if weightMax == 0 {
-		weightMax = math.MaxFloat64
+	// lookup indexes
+	if ids, ok := r.ix.candidates(f); ok {
+		for _, id := range ids {
+			if value := r.db[id]; matchVehicleFilter(f, value) {
+				v[id] = value
+			}
+		}
+		return
 	}
 
-	// copy db
+	// scan
 	for key, value := range r.db {
-		if value.Weight >= weightMin && value.Weight <= weightMax {
+		if matchVehicleFilter(f, value) {
 			v[key] = value
 		}
 	}
 
-	if len(v) == 0 {
-		return nil, internal.ErrNoVehiclesByWeightRangeRepo
+	return
+}
+
+// GetBrandAggregates is a method that returns the aggregates of every brand
+func (r *VehicleMap) GetBrandAggregates() (a map[string]internal.BrandAggregate, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	a = make(map[string]internal.BrandAggregate, len(r.ix.aggregates))
+	for brand, value := range r.ix.aggregates {
+		a[brand] = *value
 	}
 
 	return
 }
 
-func (r *VehicleMap) ListByDimensions(minLength, maxLength, minWidth, maxWidth float64) (v map[int]internal.Vehicle, err error) {
+// Aggregate is a method that groups the vehicles and computes the metrics of each group
+func (r *VehicleMap) Aggregate(a internal.VehicleAggregation) (rows []internal.VehicleAggregateRow, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	v = make(map[int]internal.Vehicle)
+	return aggregateVehicles(r.db, a), nil
+}
 
-	// copy db
-	for key, value := range r.db {
-		if value.Length >= minLength && value.Length <= maxLength && value.Width >= minWidth && value.Width <= maxWidth {
-			v[key] = value
-		}
+// Compact is a method that saves the current vehicles as a new snapshot and empties the journal
+// - the vehicles are copied and the journal rotated under the read lock, so no entry can be appended
+// between both, then the snapshot is saved without blocking readers nor writers
+func (r *VehicleMap) Compact(st internal.VehicleStorer) (err error) {
+	if r.jr == nil {
+		return
 	}
+	r.cm.Lock()
+	defer r.cm.Unlock()
+
+	// snapshot and rotate
+	r.mu.RLock()
+	db := r.snapshot()
+	err = r.jr.Rotate()
+	r.mu.RUnlock()
+	if err != nil {
+		return
+	}
+
+	// save
+	return r.jr.Compact(db, st)
+}
 
-	if len(v) == 0 {
-		return nil, internal.ErrNoVehiclesByDimensionsRepo
+// journal is a method that persists the entries of a write before it is applied in memory
+// - the entries are appended to the journal, if any
+// - the vehicles are saved with the entries applied through the storer, if any
+// - it is expected to be called with the write lock held
+func (r *VehicleMap) journal(entries ...JournalEntry) (err error) {
+	if r.jr != nil {
+		if err = r.jr.Append(entries...); err != nil {
+			return fmt.Errorf("%w: %w", internal.ErrVehicleStorageRepo, err)
+		}
 	}
 
+	if r.st != nil {
+		next := r.snapshot()
+		for _, entry := range entries {
+			if err = applyJournalEntry(next, entry); err != nil {
+				return fmt.Errorf("%w: %w", internal.ErrVehicleStorageRepo, err)
+			}
+		}
+		if err = r.st.Save(next); err != nil {
+			return fmt.Errorf("%w: %w", internal.ErrVehicleStorageRepo, err)
+		}
+	}
 	return
 }
 
-func (r *VehicleMap) Update(v *internal.Vehicle) (err error) {
-	// check if vehicle exists
-	if _, ok := r.db[v.Id]; !ok {
-		return internal.ErrVehicleNotFoundRepo
-	}
+// FindAllAsOf is a method that returns the vehicles as they were at the given time, keyed by id
+func (r *VehicleMap) FindAllAsOf(t time.Time) (v map[int]internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	// update vehicle
-	r.db[v.Id] = *v
+	return r.hs.findAll(t)
+}
 
-	// return nil error
-	return nil
+// FindByIdAsOf is a method that returns the vehicle with the given id as it was at the given time
+func (r *VehicleMap) FindByIdAsOf(id int, t time.Time) (v internal.Vehicle, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.hs.findById(id, t)
 }
 
-func (r *VehicleMap) Delete(id int) (err error) {
-	// check if vehicle exists
-	if _, ok := r.db[id]; !ok {
-		return internal.ErrVehicleNotFoundRepo
-	}
+// PruneHistory is a method that drops the versions superseded before the given time, returning how many
+func (r *VehicleMap) PruneHistory(before time.Time) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
 
-	// delete vehicle
-	delete(r.db, id)
+	return r.hs.prune(before), nil
+}
 
-	// return nil error
-	return nil
+// snapshot is a method that returns a copy of every vehicle, deleted ones included
+// - it is expected to be called with the lock held
+func (r *VehicleMap) snapshot() (v map[int]internal.Vehicle) {
+	v = make(map[int]internal.Vehicle, len(r.db)+len(r.trash))
+	for _, m := range []map[int]internal.Vehicle{r.db, r.trash} {
+		for key, value := range m {
+			v[key] = value
+		}
+	}
+	return
 }
 
-func (r *VehicleMap) GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error) {
-	capacityAvg = 0
-	count := 0
+// idTaken is a method that returns whether the id is held by a vehicle, deleted or not
+// - it is expected to be called with the lock held
+func (r *VehicleMap) idTaken(id int) bool {
+	if _, ok := r.db[id]; ok {
+		return true
+	}
+	_, ok := r.trash[id]
+	return ok
+}
 
-	// copy db
-	for _, value := range r.db {
-		if value.Brand == brand {
-			capacityAvg += float64(value.Capacity)
-			count++
+// registrationTaken is a method that returns whether the registration is held by a vehicle other than id, deleted or not
+// - the trash is not indexed, it is expected to stay small
+// - it is expected to be called with the lock held
+func (r *VehicleMap) registrationTaken(registration string, id int) bool {
+	key := internal.NormalizeRegistration(registration)
+	if key == "" {
+		return false
+	}
+	for holder := range r.ix.registration[key] {
+		if holder != id {
+			return true
+		}
+	}
+	for holder, value := range r.trash {
+		if holder != id && internal.NormalizeRegistration(value.Registration) == key {
+			return true
 		}
 	}
+	return false
+}
 
-	if count == 0 {
-		return 0, internal.ErrNoVehiclesByBrandRepo
+// nextId is a function that returns the id a new vehicle is stored with, advancing seq
+// - 0 allocates the next id of the sequence, any other id is kept and moves the sequence forward
+func nextId(seq *int, id int) int {
+	if id == 0 {
+		*seq++
+		return *seq
 	}
+	*seq = max(*seq, id)
+	return id
+}
 
-	return capacityAvg / float64(count), nil
+// duplicatedIds is a function that returns the sorted ids that appear more than once in v
+// - id 0 is not an id but a request for one, so it is never duplicated
+func duplicatedIds(v []internal.Vehicle) (ids []int) {
+	seen := make(map[int]int, len(v))
+	for _, value := range v {
+		if value.Id == 0 {
+			continue
+		}
+		seen[value.Id]++
+		if seen[value.Id] == 2 {
+			ids = append(ids, value.Id)
+		}
+	}
+	sort.Ints(ids)
+	return
+}
+
+// duplicatedRegistrations is a function that returns the sorted registrations that appear more than once in v
+// - registrations are compared normalized, each one is reported once
+func duplicatedRegistrations(v []internal.Vehicle) (registrations []string) {
+	seen := make(map[string]int, len(v))
+	for _, value := range v {
+		key := internal.NormalizeRegistration(value.Registration)
+		if key == "" {
+			continue
+		}
+		seen[key]++
+		if seen[key] == 2 {
+			registrations = append(registrations, value.Registration)
+		}
+	}
+	sort.Strings(registrations)
+	return
 }
@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"app/internal"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestVehicle is a function that returns a vehicle with attributes derived from i
+func newTestVehicle(i int) internal.Vehicle {
+	return internal.Vehicle{
+		VehicleAttributes: internal.VehicleAttributes{
+			Brand:           []string{"Ford", "Fiat", "Toyota"}[i%3],
+			Model:           fmt.Sprintf("Model %d", i),
+			Registration:    fmt.Sprintf("REG-%05d", i),
+			Color:           []string{"red", "blue"}[i%2],
+			FabricationYear: 2000 + i%20,
+			Capacity:        2 + i%5,
+			MaxSpeed:        120 + float64(i%80),
+			FuelType:        "gasoline",
+			Transmission:    "manual",
+			Weight:          900 + float64(i%600),
+			Dimensions:      internal.Dimensions{Height: 1.5, Length: 3 + float64(i%3), Width: 1.6 + float64(i%2)/10},
+		},
+	}
+}
+
+// newTestVehicleMap is a function that returns a VehicleMap seeded with n vehicles, ids 1 to n
+func newTestVehicleMap(n int) *VehicleMap {
+	db := make(map[int]internal.Vehicle, n)
+	for i := 1; i <= n; i++ {
+		v := newTestVehicle(i)
+		v.Id, v.Version = i, 1
+		db[i] = v
+	}
+	return NewVehicleMap(db)
+}
+
+// TestVehicleMap_Concurrent calls every method of VehicleMap from many goroutines at once
+// - run it with -race, it fails when any method touches the maps without holding the lock
+func TestVehicleMap_Concurrent(t *testing.T) {
+	const (
+		seeded  = 200
+		workers = 8
+		rounds  = 25
+	)
+	rp := newTestVehicleMap(seeded)
+
+	// errors the operations can legitimately return when racing with each other
+	expected := []error{
+		internal.ErrVehicleNotFoundRepo,
+		internal.ErrVehicleVersionConflictRepo,
+		internal.ErrVehicleNotDeletedRepo,
+		internal.ErrNoVehicleByRegistrationRepo,
+		internal.ErrNoVehiclesByBrandRepo,
+	}
+	check := func(op string, err error) {
+		if err == nil {
+			return
+		}
+		for _, e := range expected {
+			if errors.Is(err, e) {
+				return
+			}
+		}
+		t.Errorf("%s: unexpected error: %v", op, err)
+	}
+
+	filter := internal.VehicleFilter{Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+		{Field: "brand", Operator: internal.FilterEq, Values: []any{"Ford"}},
+		{Field: "weight", Operator: internal.FilterGte, Values: []any{1000.0}},
+	}}
+	aggregation := internal.VehicleAggregation{
+		GroupBy: []string{"brand"},
+		Metrics: []internal.VehicleMetric{{Function: internal.AggregateAvg, Field: "max_speed"}},
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				id := 1 + (w*rounds+i)%seeded
+				n := seeded + 1 + w*rounds + i
+
+				// writes
+				created := newTestVehicle(n)
+				check("Create", rp.Create(&created))
+				batch := []internal.Vehicle{newTestVehicle(n + 100000), newTestVehicle(n + 200000)}
+				check("CreateMultiple", rp.CreateMultiple(batch))
+				if v, err := rp.FindById(id); err == nil {
+					v.MaxSpeed++
+					_, err := rp.Update(&v)
+					check("Update", err)
+				}
+				if i%5 == 0 {
+					_, err := rp.Delete(created.Id, 0)
+					check("Delete", err)
+					_, err = rp.Restore(created.Id)
+					check("Restore", err)
+				}
+				if i%10 == 0 {
+					_, err := rp.Purge(time.Now().Add(-time.Hour))
+					check("Purge", err)
+					_, err = rp.PruneHistory(time.Now().Add(-time.Hour))
+					check("PruneHistory", err)
+				}
+
+				// reads
+				_, err := rp.FindAll()
+				check("FindAll", err)
+				_, err = rp.FindById(id)
+				check("FindById", err)
+				_, err = rp.FindByRegistration(fmt.Sprintf("REG-%05d", id))
+				check("FindByRegistration", err)
+				_, err = rp.GetSpeedAvgByBrand("Fiat")
+				check("GetSpeedAvgByBrand", err)
+				_, err = rp.GetAverageCapacityByBrand("Toyota")
+				check("GetAverageCapacityByBrand", err)
+				_, err = rp.FindByFilter(filter)
+				check("FindByFilter", err)
+				_, err = rp.GetBrandAggregates()
+				check("GetBrandAggregates", err)
+				_, err = rp.Aggregate(aggregation)
+				check("Aggregate", err)
+				_, err = rp.FindDeleted()
+				check("FindDeleted", err)
+				_, err = rp.FindAllAsOf(time.Now())
+				check("FindAllAsOf", err)
+				_, err = rp.FindByIdAsOf(id, time.Now())
+				check("FindByIdAsOf", err)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// every vehicle created is there exactly once, with a unique id
+	v, err := rp.FindAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := seeded + workers*rounds*3
+	if len(v) != want {
+		t.Errorf("got %d vehicles, want %d", len(v), want)
+	}
+}
+
+func TestVehicleMap_UpdateDeleteReturnPrevious(t *testing.T) {
+	rp := newTestVehicleMap(3)
+
+	// update returns the vehicle as it was
+	v, _ := rp.FindById(1)
+	before := v
+	v.MaxSpeed = 200
+	previous, err := rp.Update(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if previous != before {
+		t.Errorf("got previous %+v, want %+v", previous, before)
+	}
+	if _, err = rp.Update(&before); !errors.Is(err, internal.ErrVehicleVersionConflictRepo) {
+		t.Errorf("got %v, want ErrVehicleVersionConflictRepo", err)
+	}
+
+	// delete returns the vehicle as it is in the trash
+	deleted, err := rp.Delete(1, v.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trash, _ := rp.FindDeleted()
+	if deleted != trash[1] || deleted.Version != 3 || deleted.MaxSpeed != 200 || deleted.DeletedAt.IsZero() {
+		t.Errorf("got %+v returned, %+v in the trash", deleted, trash[1])
+	}
+}
+
+// TestVehicleMap_ClockSteppedBack writes after the wall clock went back and checks the history stays ordered
+func TestVehicleMap_ClockSteppedBack(t *testing.T) {
+	rp := newTestVehicleMap(3)
+
+	// the last write was an hour ahead of the wall clock
+	v, _ := rp.FindById(1)
+	v.MaxSpeed = 200
+	if _, err := rp.Update(&v); err != nil {
+		t.Fatal(err)
+	}
+	ahead := time.Now().UTC().Add(time.Hour)
+	rp.clock = ahead
+
+	v.MaxSpeed = 210
+	if _, err := rp.Update(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.UpdatedAt.Before(ahead) {
+		t.Errorf("got updated at %v, want not before %v", v.UpdatedAt, ahead)
+	}
+
+	// the latest version is the one found at its time
+	got, err := rp.FindByIdAsOf(1, v.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+}
+
+// TestVehicleMap_ConcurrentIds creates vehicles from many goroutines and checks no id is allocated twice
+func TestVehicleMap_ConcurrentIds(t *testing.T) {
+	const workers, rounds = 8, 100
+	rp := newTestVehicleMap(0)
+
+	ids := make(chan int, workers*rounds)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				v := newTestVehicle(w*rounds + i)
+				if err := rp.Create(&v); err != nil {
+					t.Error(err)
+					return
+				}
+				ids <- v.Id
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(ids)
+
+	seen := make(map[int]bool)
+	for id := range ids {
+		if seen[id] {
+			t.Errorf("id %d allocated twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != workers*rounds {
+		t.Errorf("got %d ids, want %d", len(seen), workers*rounds)
+	}
+}
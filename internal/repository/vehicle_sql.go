@@ -0,0 +1,1102 @@
+package repository
+
+import (
+	"app/internal"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// vehicleSQLMigrations is the ordered list of schema migrations applied by VehicleSQL
+// - each entry is applied once, in its own transaction, and recorded in the schema_migrations table
+// - new migrations must be appended, never edited or reordered
+var vehicleSQLMigrations = []string{
+	// 1: vehicles table, one column per Vehicle, VehicleAttributes and Dimensions field
+	`CREATE TABLE IF NOT EXISTS vehicles (
+		id               INTEGER PRIMARY KEY,
+		brand            TEXT    NOT NULL DEFAULT '',
+		model            TEXT    NOT NULL DEFAULT '',
+		registration     TEXT    NOT NULL DEFAULT '',
+		color            TEXT    NOT NULL DEFAULT '',
+		fabrication_year INTEGER NOT NULL DEFAULT 0,
+		capacity         INTEGER NOT NULL DEFAULT 0,
+		max_speed        REAL    NOT NULL DEFAULT 0,
+		fuel_type        TEXT    NOT NULL DEFAULT '',
+		transmission     TEXT    NOT NULL DEFAULT '',
+		weight           REAL    NOT NULL DEFAULT 0,
+		height           REAL    NOT NULL DEFAULT 0,
+		length           REAL    NOT NULL DEFAULT 0,
+		width            REAL    NOT NULL DEFAULT 0
+	)`,
+	// 2-5: indexes backing the filters
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_color_year ON vehicles (color, fabrication_year)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_brand_year ON vehicles (brand, fabrication_year)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_weight ON vehicles (weight)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_length_width ON vehicles (length, width)`,
+	// 6: version of each vehicle, existing vehicles start at 1
+	`ALTER TABLE vehicles ADD COLUMN version INTEGER NOT NULL DEFAULT 1`,
+	// 7: modification time of each vehicle in unix nanoseconds, 0 when unknown
+	`ALTER TABLE vehicles ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0`,
+	// 8-9: single-row sequence the ids of new vehicles are allocated from, starting at the highest id
+	`CREATE TABLE IF NOT EXISTS vehicle_sequence (id INTEGER NOT NULL)`,
+	`INSERT INTO vehicle_sequence (id) SELECT COALESCE(MAX(id), 0) FROM vehicles`,
+	// 10-11: normalized registration of each vehicle, see internal.NormalizeRegistration, backfilled by Migrate
+	// - not unique, registrations stored before were not checked and may be shared
+	`ALTER TABLE vehicles ADD COLUMN registration_key TEXT NOT NULL DEFAULT ''`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_registration_key ON vehicles (registration_key)`,
+	// 12-13: time each vehicle was moved to the trash in unix nanoseconds, 0 when it is not deleted
+	`ALTER TABLE vehicles ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicles_deleted_at ON vehicles (deleted_at)`,
+	// 14-16: audit trail of the changes made through the service, see AuditSQL
+	// - time in unix nanoseconds, changes as a JSON array
+	`CREATE TABLE IF NOT EXISTS vehicle_audit (
+		id         INTEGER PRIMARY KEY,
+		time       INTEGER NOT NULL,
+		actor      TEXT    NOT NULL DEFAULT '',
+		operation  TEXT    NOT NULL,
+		vehicle_id INTEGER NOT NULL,
+		version    INTEGER NOT NULL DEFAULT 0,
+		changes    TEXT    NOT NULL DEFAULT '[]'
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicle_audit_vehicle_id ON vehicle_audit (vehicle_id, id)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicle_audit_time ON vehicle_audit (time)`,
+	// 17-19: history of the vehicles, one row per version written in the order they were written
+	// - each version is the state of the vehicle from its updated_at until the next one of the same id
+	`CREATE TABLE IF NOT EXISTS vehicle_versions (
+		seq              INTEGER PRIMARY KEY,
+		id               INTEGER NOT NULL,
+		version          INTEGER NOT NULL DEFAULT 1,
+		brand            TEXT    NOT NULL DEFAULT '',
+		model            TEXT    NOT NULL DEFAULT '',
+		registration     TEXT    NOT NULL DEFAULT '',
+		color            TEXT    NOT NULL DEFAULT '',
+		fabrication_year INTEGER NOT NULL DEFAULT 0,
+		capacity         INTEGER NOT NULL DEFAULT 0,
+		max_speed        REAL    NOT NULL DEFAULT 0,
+		fuel_type        TEXT    NOT NULL DEFAULT '',
+		transmission     TEXT    NOT NULL DEFAULT '',
+		weight           REAL    NOT NULL DEFAULT 0,
+		height           REAL    NOT NULL DEFAULT 0,
+		length           REAL    NOT NULL DEFAULT 0,
+		width            REAL    NOT NULL DEFAULT 0,
+		updated_at       INTEGER NOT NULL DEFAULT 0,
+		deleted_at       INTEGER NOT NULL DEFAULT 0
+	)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicle_versions_id ON vehicle_versions (id, seq)`,
+	`CREATE INDEX IF NOT EXISTS idx_vehicle_versions_updated_at ON vehicle_versions (updated_at)`,
+	// 20: the vehicles stored so far are their first versions, earlier ones are unknown
+	`INSERT INTO vehicle_versions (` + vehicleSQLColumns + `) SELECT ` + vehicleSQLColumns + ` FROM vehicles`,
+	// 21: single-row earliest time the history reaches back to in unix nanoseconds, set by Migrate
+	`CREATE TABLE IF NOT EXISTS vehicle_history (horizon INTEGER NOT NULL)`,
+}
+
+// vehicleSQLFieldColumns maps the name of each vehicle field (see internal.VehicleFields) to its column
+var vehicleSQLFieldColumns = map[string]string{
+	"id":           "id",
+	"brand":        "brand",
+	"model":        "model",
+	"registration": "registration",
+	"color":        "color",
+	"year":         "fabrication_year",
+	"passengers":   "capacity",
+	"max_speed":    "max_speed",
+	"fuel_type":    "fuel_type",
+	"transmission": "transmission",
+	"weight":       "weight",
+	"height":       "height",
+	"length":       "length",
+	"width":        "width",
+	"decade":       "(fabrication_year / 10 * 10)",
+}
+
+// vehicleSQLColumns is the list of columns selected for a vehicle, in the order scanned by scanVehicle
+const vehicleSQLColumns = "id, version, brand, model, registration, color, fabrication_year, capacity, max_speed, fuel_type, transmission, weight, height, length, width, updated_at, deleted_at"
+
+// vehicleSQLLive is the condition matching the vehicles that are not deleted
+const vehicleSQLLive = "deleted_at = 0"
+
+// NewVehicleSQL is a function that returns a new instance of VehicleSQL
+func NewVehicleSQL(db *sql.DB) *VehicleSQL {
+	return &VehicleSQL{db: db}
+}
+
+// VehicleSQL is a struct that represents a vehicle repository on top of database/sql
+// - queries use "?" placeholders (sqlite, mysql)
+// - filters are pushed down into WHERE clauses and averages are computed by the database
+// - deleted vehicles stay in the table with deleted_at set, every query but the trash ones matches vehicleSQLLive
+// - every write also copies the vehicles written to vehicle_versions, within the same transaction
+type VehicleSQL struct {
+	// db is the database connection pool
+	db *sql.DB
+}
+
+// Migrate is a method that creates or updates the schema, applying the pending migrations
+// - afterwards the normalized registrations missing are backfilled
+// - the history horizon is set to now the first time, as the versions written before are unknown
+func (r *VehicleSQL) Migrate() (err error) {
+	// migrations table
+	_, err = r.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`)
+	if err != nil {
+		return
+	}
+
+	// current version
+	var version int
+	err = r.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return
+	}
+
+	// apply pending migrations
+	for i := version; i < len(vehicleSQLMigrations); i++ {
+		if err = r.migrate(i+1, vehicleSQLMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+
+	if err = r.backfillRegistrationKeys(); err != nil {
+		return fmt.Errorf("registration keys: %w", err)
+	}
+
+	_, err = r.db.Exec(
+		`INSERT INTO vehicle_history (horizon) SELECT ? WHERE NOT EXISTS (SELECT 1 FROM vehicle_history)`,
+		time.Now().UnixNano(),
+	)
+	if err != nil {
+		return fmt.Errorf("history horizon: %w", err)
+	}
+
+	return
+}
+
+// Close is a method that closes the database, shared with the AuditSQL of the same database
+func (r *VehicleSQL) Close() (err error) {
+	return r.db.Close()
+}
+
+// backfillRegistrationKeys is a method that sets the normalized registration of the vehicles stored without one
+// - the normalization is done in Go, so it cannot be part of a migration
+func (r *VehicleSQL) backfillRegistrationKeys() (err error) {
+	rows, err := r.db.Query(`SELECT id, registration FROM vehicles WHERE registration_key = '' AND registration <> ''`)
+	if err != nil {
+		return
+	}
+	keys := make(map[int]string)
+	for rows.Next() {
+		var id int
+		var registration string
+		if err = rows.Scan(&id, &registration); err != nil {
+			rows.Close()
+			return
+		}
+		keys[id] = internal.NormalizeRegistration(registration)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(keys) == 0 {
+		return
+	}
+
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+	for id, key := range keys {
+		if _, err = tx.Exec(`UPDATE vehicles SET registration_key = ? WHERE id = ?`, key, id); err != nil {
+			return
+		}
+	}
+
+	return tx.Commit()
+}
+
+// migrate is a method that applies a single migration and records its version
+func (r *VehicleSQL) migrate(version int, statement string) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(statement); err != nil {
+		return
+	}
+	if _, err = tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, version); err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+// FindAll is a method that returns a map of all vehicles
+func (r *VehicleSQL) FindAll() (v map[int]internal.Vehicle, err error) {
+	return r.find("")
+}
+
+// FindById is a method that returns the vehicle with the given id
+func (r *VehicleSQL) FindById(id int) (v internal.Vehicle, err error) {
+	row := r.db.QueryRow("SELECT "+vehicleSQLColumns+" FROM vehicles WHERE id = ? AND "+vehicleSQLLive, id)
+	err = scanVehicle(row, &v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, internal.ErrVehicleNotFoundRepo
+	}
+
+	return
+}
+
+// FindByRegistration is a method that returns the vehicle with the given registration
+func (r *VehicleSQL) FindByRegistration(registration string) (v internal.Vehicle, err error) {
+	key := internal.NormalizeRegistration(registration)
+	if key == "" {
+		return v, internal.ErrNoVehicleByRegistrationRepo
+	}
+
+	// the lowest id wins when the registration is shared
+	row := r.db.QueryRow("SELECT "+vehicleSQLColumns+" FROM vehicles WHERE registration_key = ? AND "+vehicleSQLLive+" ORDER BY id LIMIT 1", key)
+	err = scanVehicle(row, &v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, internal.ErrNoVehicleByRegistrationRepo
+	}
+
+	return
+}
+
+func (r *VehicleSQL) Create(v *internal.Vehicle) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// check if vehicle already exists, ids of deleted vehicles stay taken
+	var exists int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM vehicles WHERE id = ?`, v.Id).Scan(&exists)
+	if err != nil {
+		return
+	}
+	if exists > 0 {
+		return internal.ErrVehicleAlreadyExistsRepo
+	}
+	taken, err := r.registrationTaken(tx, v.Registration, v.Id)
+	if err != nil {
+		return
+	}
+	if taken {
+		return &internal.VehicleRegistrationConflictError{Existing: []string{v.Registration}}
+	}
+
+	// add vehicle to db
+	created := *v
+	if created.Id, err = r.nextId(tx, v.Id); err != nil {
+		return
+	}
+	created.Version = 1
+	if created.UpdatedAt, err = r.now(tx); err != nil {
+		return
+	}
+	if err = r.insert(tx, created); err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	*v = created
+	return
+}
+
+func (r *VehicleSQL) GetSpeedAvgByBrand(brand string) (speedAvg float64, err error) {
+	return r.avgByBrand("max_speed", brand)
+}
+
+func (r *VehicleSQL) CreateMultiple(v []internal.Vehicle) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// check every id before inserting any vehicle
+	conflict := &internal.VehicleConflictError{Duplicated: duplicatedIds(v)}
+	conflict.Existing, err = r.existingIds(tx, v)
+	if err != nil {
+		return
+	}
+	if len(conflict.Existing) > 0 || len(conflict.Duplicated) > 0 {
+		return conflict
+	}
+
+	// check every registration
+	registrationConflict := &internal.VehicleRegistrationConflictError{Duplicated: duplicatedRegistrations(v)}
+	for _, value := range v {
+		var taken bool
+		if taken, err = r.registrationTaken(tx, value.Registration, value.Id); err != nil {
+			return
+		}
+		if taken {
+			registrationConflict.Existing = append(registrationConflict.Existing, value.Registration)
+		}
+	}
+	if len(registrationConflict.Existing) > 0 || len(registrationConflict.Duplicated) > 0 {
+		sort.Strings(registrationConflict.Existing)
+		return registrationConflict
+	}
+
+	// allocate ids after the highest id provided, so none of them is taken by the sequence
+	ids := make([]int, len(v))
+	for i, value := range v {
+		if value.Id != 0 {
+			if ids[i], err = r.nextId(tx, value.Id); err != nil {
+				return
+			}
+		}
+	}
+	for i, value := range v {
+		if value.Id == 0 {
+			if ids[i], err = r.nextId(tx, 0); err != nil {
+				return
+			}
+		}
+	}
+
+	// add vehicles to db
+	created := make([]internal.Vehicle, len(v))
+	now, err := r.now(tx)
+	if err != nil {
+		return
+	}
+	for i := range v {
+		created[i] = v[i]
+		created[i].Id = ids[i]
+		created[i].Version = 1
+		created[i].UpdatedAt = now
+		if err = r.insert(tx, created[i]); err != nil {
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	copy(v, created)
+	return
+}
+
+func (r *VehicleSQL) Update(v *internal.Vehicle) (previous internal.Vehicle, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// vehicle as it is, a missing one is reported once the update affects no row
+	var stored internal.Vehicle
+	err = scanVehicle(tx.QueryRow("SELECT "+vehicleSQLColumns+" FROM vehicles WHERE id = ? AND "+vehicleSQLLive, v.Id), &stored)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+
+	// check version first, a stale update is a conflict whatever it changes
+	if err == nil && v.Version != 0 && v.Version != stored.Version {
+		return previous, internal.ErrVehicleVersionConflictRepo
+	}
+
+	// check registration, only when it changes so vehicles sharing one from before can still be updated
+	key := internal.NormalizeRegistration(v.Registration)
+	if err == nil && key != internal.NormalizeRegistration(stored.Registration) {
+		var taken bool
+		if taken, err = r.registrationTaken(tx, v.Registration, v.Id); err != nil {
+			return
+		}
+		if taken {
+			return previous, &internal.VehicleRegistrationConflictError{Existing: []string{v.Registration}}
+		}
+	}
+
+	// update vehicle, only if the version matches
+	updatedAt, err := r.now(tx)
+	if err != nil {
+		return
+	}
+	result, err := tx.Exec(
+		`UPDATE vehicles SET brand = ?, model = ?, registration = ?, color = ?, fabrication_year = ?, capacity = ?,
+			max_speed = ?, fuel_type = ?, transmission = ?, weight = ?, height = ?, length = ?, width = ?,
+			version = version + 1, updated_at = ?, registration_key = ?
+		WHERE id = ? AND `+vehicleSQLLive+` AND (? = 0 OR version = ?)`,
+		v.Brand, v.Model, v.Registration, v.Color, v.FabricationYear, v.Capacity,
+		v.MaxSpeed, v.FuelType, v.Transmission, v.Weight, v.Height, v.Length, v.Width,
+		updatedAt.UnixNano(), key, v.Id, v.Version, v.Version,
+	)
+	if err != nil {
+		return
+	}
+
+	// check if vehicle exists and its version
+	if err = r.affectedVersion(tx, result, v.Id); err != nil {
+		return
+	}
+
+	// new version
+	var version int
+	if err = tx.QueryRow(`SELECT version FROM vehicles WHERE id = ?`, v.Id).Scan(&version); err != nil {
+		return
+	}
+	if err = r.record(tx, v.Id); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	v.Version = version
+	v.UpdatedAt = updatedAt
+
+	return stored, nil
+}
+
+func (r *VehicleSQL) Delete(id int, version int) (v internal.Vehicle, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// move vehicle to trash, only if the version matches
+	now, err := r.now(tx)
+	if err != nil {
+		return
+	}
+	deletedAt := now.UnixNano()
+	result, err := tx.Exec(
+		`UPDATE vehicles SET deleted_at = ?, updated_at = ?, version = version + 1
+		WHERE id = ? AND `+vehicleSQLLive+` AND (? = 0 OR version = ?)`,
+		deletedAt, deletedAt, id, version, version,
+	)
+	if err != nil {
+		return
+	}
+
+	// check if vehicle exists and its version
+	if err = r.affectedVersion(tx, result, id); err != nil {
+		return
+	}
+	if err = r.record(tx, id); err != nil {
+		return
+	}
+
+	// vehicle as it is in the trash
+	var deleted internal.Vehicle
+	if err = scanVehicle(tx.QueryRow("SELECT "+vehicleSQLColumns+" FROM vehicles WHERE id = ?", id), &deleted); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	return deleted, nil
+}
+
+// FindDeleted is a method that returns the vehicles in the trash
+func (r *VehicleSQL) FindDeleted() (v map[int]internal.Vehicle, err error) {
+	return r.query("WHERE NOT " + vehicleSQLLive)
+}
+
+// Restore is a method that moves the vehicle with the given id out of the trash
+func (r *VehicleSQL) Restore(id int) (v internal.Vehicle, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// check if vehicle is in trash
+	err = scanVehicle(tx.QueryRow("SELECT "+vehicleSQLColumns+" FROM vehicles WHERE id = ?", id), &v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, internal.ErrVehicleNotFoundRepo
+	}
+	if err != nil {
+		return
+	}
+	if v.DeletedAt.IsZero() {
+		return internal.Vehicle{}, internal.ErrVehicleNotDeletedRepo
+	}
+
+	// move vehicle out of trash
+	updatedAt, err := r.now(tx)
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(`UPDATE vehicles SET deleted_at = 0, updated_at = ?, version = version + 1 WHERE id = ?`, updatedAt.UnixNano(), id)
+	if err != nil {
+		return
+	}
+	if err = r.record(tx, id); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		return
+	}
+	v.Version++
+	v.UpdatedAt = updatedAt
+	v.DeletedAt = time.Time{}
+
+	return v, nil
+}
+
+// Purge is a method that permanently removes the vehicles deleted before the given time
+// - their versions are kept
+func (r *VehicleSQL) Purge(before time.Time) (ids []int, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	// vehicles to purge
+	rows, err := tx.Query(`SELECT id FROM vehicles WHERE deleted_at <> 0 AND deleted_at < ? ORDER BY id`, before.UnixNano())
+	if err != nil {
+		return
+	}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	// delete vehicles
+	if _, err = tx.Exec(`DELETE FROM vehicles WHERE deleted_at <> 0 AND deleted_at < ?`, before.UnixNano()); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// FindAllAsOf is a method that returns the vehicles as they were at the given time, keyed by id
+// - the version of each vehicle at that time is the last one written up to it
+func (r *VehicleSQL) FindAllAsOf(t time.Time) (v map[int]internal.Vehicle, err error) {
+	if err = r.historyReaches(t); err != nil {
+		return
+	}
+
+	rows, err := r.db.Query(
+		"SELECT "+vehicleSQLColumns+` FROM vehicle_versions
+		WHERE seq IN (SELECT MAX(seq) FROM vehicle_versions WHERE updated_at <= ? GROUP BY id) AND deleted_at = 0`,
+		t.UnixNano(),
+	)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	return scanVehicles(rows)
+}
+
+// FindByIdAsOf is a method that returns the vehicle with the given id as it was at the given time
+func (r *VehicleSQL) FindByIdAsOf(id int, t time.Time) (v internal.Vehicle, err error) {
+	if err = r.historyReaches(t); err != nil {
+		return
+	}
+
+	row := r.db.QueryRow(
+		"SELECT "+vehicleSQLColumns+" FROM vehicle_versions WHERE id = ? AND updated_at <= ? ORDER BY seq DESC LIMIT 1",
+		id, t.UnixNano(),
+	)
+	err = scanVehicle(row, &v)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !v.DeletedAt.IsZero()) {
+		return internal.Vehicle{}, internal.ErrVehicleNotFoundRepo
+	}
+
+	return
+}
+
+// PruneHistory is a method that drops the versions superseded before the given time, returning how many
+// - the version current at before is kept, unless it is a deletion, which is the same as no version
+func (r *VehicleSQL) PruneHistory(before time.Time) (n int, err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		// superseded versions
+		`DELETE FROM vehicle_versions WHERE updated_at <= ?
+		AND seq NOT IN (SELECT MAX(seq) FROM vehicle_versions WHERE updated_at <= ? GROUP BY id)`,
+		// deletions left current at before
+		`DELETE FROM vehicle_versions WHERE updated_at <= ? AND deleted_at <> 0`,
+	}
+	for _, statement := range statements {
+		var result sql.Result
+		if result, err = tx.Exec(statement, before.UnixNano(), before.UnixNano()); err != nil {
+			return
+		}
+		var affected int64
+		if affected, err = result.RowsAffected(); err != nil {
+			return
+		}
+		n += int(affected)
+	}
+
+	// history reaches back to before from now on
+	if _, err = tx.Exec(`UPDATE vehicle_history SET horizon = ? WHERE horizon < ?`, before.UnixNano(), before.UnixNano()); err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return
+}
+
+func (r *VehicleSQL) GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error) {
+	return r.avgByBrand("capacity", brand)
+}
+
+// FindByFilter is a method that returns the vehicles matching the filter
+func (r *VehicleSQL) FindByFilter(f internal.VehicleFilter) (v map[int]internal.Vehicle, err error) {
+	where, args, err := vehicleSQLWhere(f)
+	if err != nil {
+		return
+	}
+
+	return r.find(where, args...)
+}
+
+// GetBrandAggregates is a method that returns the aggregates of every brand
+func (r *VehicleSQL) GetBrandAggregates() (a map[string]internal.BrandAggregate, err error) {
+	rows, err := r.db.Query(`SELECT brand, COUNT(*),
+		SUM(max_speed), MIN(max_speed), MAX(max_speed),
+		SUM(capacity), MIN(capacity), MAX(capacity),
+		SUM(weight), MIN(weight), MAX(weight)
+	FROM vehicles WHERE ` + vehicleSQLLive + ` GROUP BY brand`)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	a = make(map[string]internal.BrandAggregate)
+	for rows.Next() {
+		var b internal.BrandAggregate
+		err = rows.Scan(&b.Brand, &b.Count,
+			&b.MaxSpeed.Sum, &b.MaxSpeed.Min, &b.MaxSpeed.Max,
+			&b.Capacity.Sum, &b.Capacity.Min, &b.Capacity.Max,
+			&b.Weight.Sum, &b.Weight.Min, &b.Weight.Max,
+		)
+		if err != nil {
+			return nil, err
+		}
+		b.MaxSpeed.Count, b.Capacity.Count, b.Weight.Count = b.Count, b.Count, b.Count
+		a[b.Brand] = b
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Aggregate is a method that groups the vehicles and computes the metrics of each group with GROUP BY
+func (r *VehicleSQL) Aggregate(a internal.VehicleAggregation) (rows []internal.VehicleAggregateRow, err error) {
+	// select
+	groupFields := make([]internal.VehicleField, len(a.GroupBy))
+	columns := make([]string, 0, len(a.GroupBy)+len(a.Metrics))
+	for i, name := range a.GroupBy {
+		groupFields[i], _ = internal.LookupVehicleField(name)
+		column, ok := vehicleSQLFieldColumns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		columns = append(columns, column)
+	}
+	groupBy := strings.Join(columns, ", ")
+	for _, m := range a.Metrics {
+		if m.Function == internal.AggregateCount {
+			columns = append(columns, "COUNT(*)")
+			continue
+		}
+		column, ok := vehicleSQLFieldColumns[m.Field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", m.Field)
+		}
+		columns = append(columns, strings.ToUpper(string(m.Function))+"("+column+")")
+	}
+	// trailing count, used to drop the single empty group returned when there is no GROUP BY
+	columns = append(columns, "COUNT(*)")
+
+	query := "SELECT " + strings.Join(columns, ", ") + " FROM vehicles WHERE " + vehicleSQLLive
+	where, args, err := vehicleSQLWhere(a.Filter)
+	if err != nil {
+		return
+	}
+	if where != "" {
+		query += " AND (" + where + ")"
+	}
+	if groupBy != "" {
+		query += " GROUP BY " + groupBy
+	}
+
+	// query
+	result, err := r.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer result.Close()
+
+	for result.Next() {
+		// scan group values typed after their field, and metrics as nullable numbers
+		dest := make([]any, 0, len(columns))
+		for _, field := range groupFields {
+			switch field.Kind {
+			case internal.VehicleFieldString:
+				dest = append(dest, new(string))
+			case internal.VehicleFieldInt:
+				dest = append(dest, new(int))
+			case internal.VehicleFieldFloat:
+				dest = append(dest, new(float64))
+			}
+		}
+		for range a.Metrics {
+			dest = append(dest, new(sql.NullFloat64))
+		}
+		var count int
+		dest = append(dest, &count)
+		if err = result.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		row := internal.VehicleAggregateRow{Group: make([]any, len(groupFields)), Values: make([]float64, len(a.Metrics))}
+		for i := range groupFields {
+			switch value := dest[i].(type) {
+			case *string:
+				row.Group[i] = *value
+			case *int:
+				row.Group[i] = *value
+			case *float64:
+				row.Group[i] = *value
+			}
+		}
+		for i := range a.Metrics {
+			row.Values[i] = dest[len(groupFields)+i].(*sql.NullFloat64).Float64
+		}
+		rows = append(rows, row)
+	}
+	if err = result.Err(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// find is a method that returns the vehicles not deleted matching the given condition, all of them when it is empty
+// - the condition is a WHERE clause without the keyword
+func (r *VehicleSQL) find(where string, args ...any) (v map[int]internal.Vehicle, err error) {
+	if where == "" {
+		return r.query("WHERE " + vehicleSQLLive)
+	}
+	return r.query("WHERE "+vehicleSQLLive+" AND ("+where+")", args...)
+}
+
+// query is a method that returns the vehicles matching the given WHERE clause, deleted ones included
+func (r *VehicleSQL) query(where string, args ...any) (v map[int]internal.Vehicle, err error) {
+	rows, err := r.db.Query("SELECT "+vehicleSQLColumns+" FROM vehicles "+where, args...)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	return scanVehicles(rows)
+}
+
+// historyReaches is a method that returns ErrVehicleHistoryUnavailableRepo when the history does not reach back to t
+func (r *VehicleSQL) historyReaches(t time.Time) (err error) {
+	var horizon int64
+	if err = r.db.QueryRow(`SELECT horizon FROM vehicle_history`).Scan(&horizon); err != nil {
+		return
+	}
+	if t.UnixNano() < horizon {
+		start := time.Unix(0, horizon).UTC()
+		return fmt.Errorf("%w: history starts at %s", internal.ErrVehicleHistoryUnavailableRepo, start.Format(time.RFC3339Nano))
+	}
+	return
+}
+
+// now is a method that returns the modification time of a write within the transaction, never before the last version
+// - the wall clock may be stepped back, the times of the versions must still follow their order for as of queries
+func (r *VehicleSQL) now(tx *sql.Tx) (t time.Time, err error) {
+	var last sql.NullInt64
+	if err = tx.QueryRow(`SELECT MAX(updated_at) FROM vehicle_versions`).Scan(&last); err != nil {
+		return
+	}
+	t = time.Now().UTC()
+	if last.Valid && t.UnixNano() < last.Int64 {
+		t = time.Unix(0, last.Int64).UTC()
+	}
+	return
+}
+
+// record is a method that copies the vehicle with the given id, as it is now, to its versions within the transaction
+func (r *VehicleSQL) record(tx *sql.Tx, id int) (err error) {
+	_, err = tx.Exec("INSERT INTO vehicle_versions ("+vehicleSQLColumns+") SELECT "+vehicleSQLColumns+" FROM vehicles WHERE id = ?", id)
+	return
+}
+
+// avgByBrand is a method that returns the average of the given column for the vehicles of a brand
+func (r *VehicleSQL) avgByBrand(column string, brand string) (avg float64, err error) {
+	var count int
+	var value sql.NullFloat64
+	err = r.db.QueryRow("SELECT COUNT(*), AVG("+column+") FROM vehicles WHERE brand = ? AND "+vehicleSQLLive, brand).Scan(&count, &value)
+	if err != nil {
+		return
+	}
+
+	if count == 0 {
+		return 0, internal.ErrNoVehiclesByBrandRepo
+	}
+
+	return value.Float64, nil
+}
+
+// existingIds is a method that returns the sorted ids of v already present in the table
+func (r *VehicleSQL) existingIds(tx *sql.Tx, v []internal.Vehicle) (ids []int, err error) {
+	// query in chunks to stay below the placeholder limit of the driver
+	const chunk = 500
+	for start := 0; start < len(v); start += chunk {
+		end := min(start+chunk, len(v))
+
+		args := make([]any, 0, end-start)
+		for _, value := range v[start:end] {
+			args = append(args, value.Id)
+		}
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+
+		var rows *sql.Rows
+		rows, err = tx.Query("SELECT id FROM vehicles WHERE id IN ("+placeholders+") ORDER BY id", args...)
+		if err != nil {
+			return
+		}
+		for rows.Next() {
+			var id int
+			if err = rows.Scan(&id); err != nil {
+				rows.Close()
+				return
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return
+		}
+	}
+
+	sort.Ints(ids)
+	return
+}
+
+// Seed is a method that inserts the vehicles as loaded, in a single transaction
+// - ids, versions and modification times are kept and the sequence is moved past the highest id
+// - registrations are not checked, loaded vehicles may share them as they were stored before they were unique
+func (r *VehicleSQL) Seed(v []internal.Vehicle) (err error) {
+	tx, err := r.db.Begin()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	for _, value := range v {
+		if _, err = r.nextId(tx, value.Id); err != nil {
+			return
+		}
+		if err = r.insert(tx, value); err != nil {
+			return
+		}
+	}
+
+	return tx.Commit()
+}
+
+// registrationTaken is a method that returns whether the registration is held by a vehicle other than id, deleted or not
+func (r *VehicleSQL) registrationTaken(tx *sql.Tx, registration string, id int) (taken bool, err error) {
+	key := internal.NormalizeRegistration(registration)
+	if key == "" {
+		return
+	}
+
+	var count int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM vehicles WHERE registration_key = ? AND id <> ?`, key, id).Scan(&count)
+	return count > 0, err
+}
+
+// nextId is a method that returns the id a new vehicle is stored with, within the transaction
+// - 0 allocates the next id of the sequence, any other id is kept and moves the sequence forward
+func (r *VehicleSQL) nextId(tx *sql.Tx, id int) (next int, err error) {
+	if id != 0 {
+		_, err = tx.Exec(`UPDATE vehicle_sequence SET id = ? WHERE id < ?`, id, id)
+		return id, err
+	}
+
+	if _, err = tx.Exec(`UPDATE vehicle_sequence SET id = id + 1`); err != nil {
+		return
+	}
+	err = tx.QueryRow(`SELECT id FROM vehicle_sequence`).Scan(&next)
+	return
+}
+
+// insert is a method that inserts a vehicle within the given transaction, recording it as its first version
+func (r *VehicleSQL) insert(tx *sql.Tx, v internal.Vehicle) (err error) {
+	_, err = tx.Exec(
+		"INSERT INTO vehicles ("+vehicleSQLColumns+", registration_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		v.Id, v.Version, v.Brand, v.Model, v.Registration, v.Color, v.FabricationYear, v.Capacity,
+		v.MaxSpeed, v.FuelType, v.Transmission, v.Weight, v.Height, v.Length, v.Width, unixNano(v.UpdatedAt),
+		unixNano(v.DeletedAt), internal.NormalizeRegistration(v.Registration),
+	)
+	if err != nil {
+		return
+	}
+	return r.record(tx, v.Id)
+}
+
+// affectedVersion is a method that explains why a statement guarded by id and version did not touch any row
+// - ErrVehicleNotFoundRepo when there is no vehicle with the id, ErrVehicleVersionConflictRepo otherwise
+func (r *VehicleSQL) affectedVersion(tx *sql.Tx, result sql.Result, id int) (err error) {
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return
+	}
+
+	var exists int
+	if err = tx.QueryRow(`SELECT COUNT(*) FROM vehicles WHERE id = ? AND `+vehicleSQLLive, id).Scan(&exists); err != nil {
+		return
+	}
+	if exists == 0 {
+		return internal.ErrVehicleNotFoundRepo
+	}
+	return internal.ErrVehicleVersionConflictRepo
+}
+
+// scanVehicle is a function that scans a row selected with vehicleSQLColumns into a vehicle
+func scanVehicle(row interface{ Scan(dest ...any) error }, v *internal.Vehicle) (err error) {
+	var updatedAt, deletedAt int64
+	err = row.Scan(
+		&v.Id, &v.Version, &v.Brand, &v.Model, &v.Registration, &v.Color, &v.FabricationYear, &v.Capacity,
+		&v.MaxSpeed, &v.FuelType, &v.Transmission, &v.Weight, &v.Height, &v.Length, &v.Width, &updatedAt, &deletedAt,
+	)
+	if err == nil && updatedAt != 0 {
+		v.UpdatedAt = time.Unix(0, updatedAt).UTC()
+	}
+	if err == nil && deletedAt != 0 {
+		v.DeletedAt = time.Unix(0, deletedAt).UTC()
+	}
+	return
+}
+
+// scanVehicles is a function that scans every row selected with vehicleSQLColumns, keyed by id
+func scanVehicles(rows *sql.Rows) (v map[int]internal.Vehicle, err error) {
+	v = make(map[int]internal.Vehicle)
+	for rows.Next() {
+		var vh internal.Vehicle
+		if err = scanVehicle(rows, &vh); err != nil {
+			return nil, err
+		}
+		v[vh.Id] = vh
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// unixNano is a function that returns the time in unix nanoseconds, 0 for the zero time
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// vehicleSQLWhere is a function that translates a filter into a WHERE clause (without the keyword) and its arguments
+func vehicleSQLWhere(f internal.VehicleFilter) (where string, args []any, err error) {
+	switch {
+	case f.IsZero():
+		return
+	case f.IsGroup():
+		if len(f.Filters) == 0 {
+			// empty group: and matches everything, or matches nothing
+			if f.Logic == internal.FilterOr {
+				return "1 = 0", nil, nil
+			}
+			return "1 = 1", nil, nil
+		}
+
+		clauses := make([]string, 0, len(f.Filters))
+		for _, sub := range f.Filters {
+			var clause string
+			var subArgs []any
+			clause, subArgs, err = vehicleSQLWhere(sub)
+			if err != nil {
+				return
+			}
+			if clause == "" {
+				clause = "1 = 1"
+			}
+			clauses = append(clauses, "("+clause+")")
+			args = append(args, subArgs...)
+		}
+		where = strings.Join(clauses, " "+strings.ToUpper(string(f.Logic))+" ")
+		return
+	}
+
+	column, ok := vehicleSQLFieldColumns[f.Field]
+	if !ok {
+		return "", nil, fmt.Errorf("unknown field %q", f.Field)
+	}
+	if len(f.Values) == 0 {
+		return "", nil, fmt.Errorf("no values for field %q", f.Field)
+	}
+
+	switch f.Operator {
+	case internal.FilterEq:
+		return column + " = ?", f.Values[:1], nil
+	case internal.FilterNe:
+		return column + " <> ?", f.Values[:1], nil
+	case internal.FilterLt:
+		return column + " < ?", f.Values[:1], nil
+	case internal.FilterLte:
+		return column + " <= ?", f.Values[:1], nil
+	case internal.FilterGt:
+		return column + " > ?", f.Values[:1], nil
+	case internal.FilterGte:
+		return column + " >= ?", f.Values[:1], nil
+	case internal.FilterIn:
+		placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(f.Values)), ", ")
+		return column + " IN (" + placeholders + ")", f.Values, nil
+	case internal.FilterContains:
+		pattern := "%" + escapeSQLLike(fmt.Sprint(f.Values[0])) + "%"
+		return "LOWER(" + column + ") LIKE ? ESCAPE '\\'", []any{pattern}, nil
+	case internal.FilterPrefix:
+		pattern := escapeSQLLike(fmt.Sprint(f.Values[0])) + "%"
+		return "LOWER(" + column + ") LIKE ? ESCAPE '\\'", []any{pattern}, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown operator %q", f.Operator)
+}
+
+// escapeSQLLike is a function that lowers the text and escapes the LIKE wildcards in it
+func escapeSQLLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(text))
+}
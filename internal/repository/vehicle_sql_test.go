@@ -0,0 +1,360 @@
+package repository
+
+import (
+	"app/internal"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openTestSQLite is a function that opens a sqlite database the way the application does, closed with the test
+func openTestSQLite(t *testing.T, dsn string) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestVehicleSQL is a function that returns a VehicleSQL on a temporary file seeded with n vehicles, ids 1 to n
+func newTestVehicleSQL(t *testing.T, n int) *VehicleSQL {
+	t.Helper()
+
+	rp := NewVehicleSQL(openTestSQLite(t, filepath.Join(t.TempDir(), "vehicles.db")))
+	if err := rp.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	seed := make([]internal.Vehicle, 0, n)
+	for _, v := range newTestVehicleMap(n).snapshot() {
+		seed = append(seed, v)
+	}
+	if err := rp.Seed(seed); err != nil {
+		t.Fatal(err)
+	}
+	return rp
+}
+
+func TestVehicleSQL_Migrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vehicles.db")
+	rp := NewVehicleSQL(openTestSQLite(t, path))
+	if err := rp.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	v := newTestVehicle(1)
+	if err := rp.Create(&v); err != nil {
+		t.Fatal(err)
+	}
+
+	// migrating again, e.g. on the next start, keeps the data
+	rp = NewVehicleSQL(openTestSQLite(t, path))
+	if err := rp.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := rp.FindById(v.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Registration != v.Registration || got.Version != 1 {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+}
+
+func TestVehicleSQL_Create(t *testing.T) {
+	rp := newTestVehicleSQL(t, 3)
+
+	// ids are allocated after the highest one
+	v := newTestVehicle(10)
+	if err := rp.Create(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Id != 4 || v.Version != 1 || v.UpdatedAt.IsZero() {
+		t.Errorf("got id %d version %d updated at %v", v.Id, v.Version, v.UpdatedAt)
+	}
+	got, err := rp.FindById(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != v {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+
+	// ids and registrations are unique
+	taken := newTestVehicle(11)
+	taken.Id = 2
+	if err := rp.Create(&taken); !errors.Is(err, internal.ErrVehicleAlreadyExistsRepo) {
+		t.Errorf("got %v, want ErrVehicleAlreadyExistsRepo", err)
+	}
+	shared := newTestVehicle(12)
+	shared.Registration = "reg 00001"
+	var conflict *internal.VehicleRegistrationConflictError
+	if err := rp.Create(&shared); !errors.As(err, &conflict) {
+		t.Errorf("got %v, want *VehicleRegistrationConflictError", err)
+	}
+
+	// lookup by registration is normalized
+	got, err = rp.FindByRegistration("reg-00002")
+	if err != nil || got.Id != 2 {
+		t.Errorf("got %d, %v, want vehicle 2", got.Id, err)
+	}
+}
+
+func TestVehicleSQL_CreateMultiple(t *testing.T) {
+	rp := newTestVehicleSQL(t, 3)
+
+	// conflicts leave the table untouched
+	existing, repeated := newTestVehicle(10), newTestVehicle(11)
+	existing.Id, repeated.Id = 1, 20
+	batch := []internal.Vehicle{existing, repeated, repeated, newTestVehicle(12)}
+	var conflict *internal.VehicleConflictError
+	if err := rp.CreateMultiple(batch); !errors.As(err, &conflict) {
+		t.Fatalf("got %v, want *VehicleConflictError", err)
+	}
+	if !reflect.DeepEqual(conflict.Existing, []int{1}) || !reflect.DeepEqual(conflict.Duplicated, []int{20}) {
+		t.Errorf("got existing %v duplicated %v", conflict.Existing, conflict.Duplicated)
+	}
+	if v, _ := rp.FindAll(); len(v) != 3 {
+		t.Errorf("got %d vehicles, want 3", len(v))
+	}
+
+	// ids are allocated after the highest one provided
+	batch = []internal.Vehicle{newTestVehicle(13), repeated}
+	if err := rp.CreateMultiple(batch); err != nil {
+		t.Fatal(err)
+	}
+	if batch[0].Id != 21 || batch[1].Id != 20 {
+		t.Errorf("got ids %d and %d, want 21 and 20", batch[0].Id, batch[1].Id)
+	}
+}
+
+func TestVehicleSQL_UpdateDeleteRestorePurge(t *testing.T) {
+	rp := newTestVehicleSQL(t, 3)
+
+	// update checks the version
+	v, _ := rp.FindById(1)
+	before := v
+	v.MaxSpeed = 200
+	previous, err := rp.Update(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Version != 2 {
+		t.Errorf("got version %d, want 2", v.Version)
+	}
+	if previous != before {
+		t.Errorf("got previous %+v, want %+v", previous, before)
+	}
+	stale := v
+	stale.Version = 1
+	if _, err := rp.Update(&stale); !errors.Is(err, internal.ErrVehicleVersionConflictRepo) {
+		t.Errorf("got %v, want ErrVehicleVersionConflictRepo", err)
+	}
+
+	// the version is checked before the registration, as VehicleMap does
+	stale.Registration = "REG-00002"
+	if _, err := rp.Update(&stale); !errors.Is(err, internal.ErrVehicleVersionConflictRepo) {
+		t.Errorf("got %v, want ErrVehicleVersionConflictRepo", err)
+	}
+	stale.Version = 9
+	if _, err := newTestVehicleMap(3).Update(&stale); !errors.Is(err, internal.ErrVehicleVersionConflictRepo) {
+		t.Errorf("got %v from VehicleMap, want ErrVehicleVersionConflictRepo", err)
+	}
+
+	// delete moves to the trash
+	if _, err := rp.Delete(1, 1); !errors.Is(err, internal.ErrVehicleVersionConflictRepo) {
+		t.Errorf("got %v, want ErrVehicleVersionConflictRepo", err)
+	}
+	removed, err := rp.Delete(1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.FindById(1); !errors.Is(err, internal.ErrVehicleNotFoundRepo) {
+		t.Errorf("got %v, want ErrVehicleNotFoundRepo", err)
+	}
+	deleted, _ := rp.FindDeleted()
+	if deleted[1].DeletedAt.IsZero() || deleted[1].Version != 3 || removed != deleted[1] {
+		t.Errorf("got %+v in the trash, %+v returned", deleted[1], removed)
+	}
+
+	// restore moves it back
+	restored, err := rp.Restore(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Version != 4 || !restored.DeletedAt.IsZero() || restored.MaxSpeed != 200 {
+		t.Errorf("got %+v restored", restored)
+	}
+	if _, err = rp.Restore(1); !errors.Is(err, internal.ErrVehicleNotDeletedRepo) {
+		t.Errorf("got %v, want ErrVehicleNotDeletedRepo", err)
+	}
+
+	// purge removes the vehicles deleted before the given time
+	if _, err = rp.Delete(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := rp.Purge(time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []int{2}) {
+		t.Errorf("got purged %v, want [2]", ids)
+	}
+	if deleted, _ = rp.FindDeleted(); len(deleted) != 0 {
+		t.Errorf("got %d vehicles in the trash, want 0", len(deleted))
+	}
+}
+
+// TestVehicleSQL_SameResultsAsVehicleMap runs the same queries on both repositories seeded alike
+func TestVehicleSQL_SameResultsAsVehicleMap(t *testing.T) {
+	const n = 300
+	rpSQL := newTestVehicleSQL(t, n)
+	rpMap := newTestVehicleMap(n)
+
+	queries := map[string]func(rp internal.VehicleRepository) (any, error){
+		"FindAll": func(rp internal.VehicleRepository) (any, error) { return rp.FindAll() },
+		"FindByFilter": func(rp internal.VehicleRepository) (any, error) {
+			return rp.FindByFilter(internal.VehicleFilter{Logic: internal.FilterOr, Filters: []internal.VehicleFilter{
+				{Field: "brand", Operator: internal.FilterIn, Values: []any{"Ford", "Toyota"}},
+				{Field: "model", Operator: internal.FilterPrefix, Values: []any{"model 1"}},
+			}})
+		},
+		"FindByFilter indexed": func(rp internal.VehicleRepository) (any, error) {
+			return rp.FindByFilter(internal.VehicleFilter{Logic: internal.FilterAnd, Filters: []internal.VehicleFilter{
+				{Field: "brand", Operator: internal.FilterEq, Values: []any{"Fiat"}},
+				{Field: "year", Operator: internal.FilterGte, Values: []any{2003}},
+				{Field: "year", Operator: internal.FilterLte, Values: []any{2011}},
+			}})
+		},
+		"GetSpeedAvgByBrand": func(rp internal.VehicleRepository) (any, error) {
+			return rp.GetSpeedAvgByBrand("Ford")
+		},
+		"GetAverageCapacityByBrand": func(rp internal.VehicleRepository) (any, error) {
+			return rp.GetAverageCapacityByBrand("Toyota")
+		},
+		"GetBrandAggregates": func(rp internal.VehicleRepository) (any, error) { return rp.GetBrandAggregates() },
+	}
+	for name, query := range queries {
+		t.Run(name, func(t *testing.T) {
+			want, err := query(rpMap)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := query(rpSQL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("got %v, want %v", got, want)
+			}
+		})
+	}
+
+	// no matches
+	if _, err := rpSQL.GetSpeedAvgByBrand("Unknown"); !errors.Is(err, internal.ErrNoVehiclesByBrandRepo) {
+		t.Errorf("got %v, want ErrNoVehiclesByBrandRepo", err)
+	}
+}
+
+func TestVehicleSQL_AsOf(t *testing.T) {
+	rp := newTestVehicleSQL(t, 2)
+
+	v, _ := rp.FindById(1)
+	before := time.Now()
+	v.MaxSpeed = 250
+	if _, err := rp.Update(&v); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rp.Delete(2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the state before the writes
+	past, err := rp.FindAllAsOf(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(past) != 2 || past[1].MaxSpeed == 250 {
+		t.Errorf("got %+v as of before the writes", past)
+	}
+	if _, err = rp.FindByIdAsOf(2, time.Now()); !errors.Is(err, internal.ErrVehicleNotFoundRepo) {
+		t.Errorf("got %v, want ErrVehicleNotFoundRepo", err)
+	}
+	if _, err = rp.FindAllAsOf(before.Add(-time.Hour)); !errors.Is(err, internal.ErrVehicleHistoryUnavailableRepo) {
+		t.Errorf("got %v, want ErrVehicleHistoryUnavailableRepo", err)
+	}
+}
+
+// TestVehicleSQL_ClockSteppedBack writes after the wall clock went back and checks the versions stay ordered
+func TestVehicleSQL_ClockSteppedBack(t *testing.T) {
+	rp := newTestVehicleSQL(t, 2)
+
+	// the versions were written an hour ahead of the wall clock
+	ahead := time.Now().UTC().Add(time.Hour)
+	if _, err := rp.db.Exec(`UPDATE vehicle_versions SET updated_at = ?`, ahead.UnixNano()); err != nil {
+		t.Fatal(err)
+	}
+
+	v, _ := rp.FindById(1)
+	v.MaxSpeed = 250
+	if _, err := rp.Update(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v.UpdatedAt.Before(ahead) {
+		t.Errorf("got updated at %v, want not before %v", v.UpdatedAt, ahead)
+	}
+	got, err := rp.FindByIdAsOf(1, v.UpdatedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Version != v.Version || got.MaxSpeed != 250 {
+		t.Errorf("got %+v, want %+v", got, v)
+	}
+}
+
+// TestVehicleSQL_ConcurrentInMemory reads and writes an in-memory database from many goroutines
+// - every connection to an in-memory database opens its own, so the pool must be a single connection
+func TestVehicleSQL_ConcurrentInMemory(t *testing.T) {
+	rp := NewVehicleSQL(openTestSQLite(t, ""))
+	if err := rp.Migrate(); err != nil {
+		t.Fatal(err)
+	}
+	batch := make([]internal.Vehicle, 500)
+	for i := range batch {
+		batch[i] = newTestVehicle(i)
+	}
+	if err := rp.CreateMultiple(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			if _, err := rp.FindAll(); err != nil {
+				t.Error(err)
+			}
+			v := newTestVehicle(len(batch) + i)
+			if err := rp.Create(&v); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	if v, _ := rp.FindAll(); len(v) != len(batch)+64 {
+		t.Errorf("got %d vehicles, want %d", len(v), len(batch)+64)
+	}
+}
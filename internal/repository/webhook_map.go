@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"app/internal"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// NewWebhookMap is a function that returns a new instance of WebhookMap
+// - logSize is how many succeeded deliveries are kept per webhook, 0 keeps all of them
+func NewWebhookMap(logSize int) *WebhookMap {
+	return &WebhookMap{
+		webhooks:   make(map[int]internal.Webhook),
+		deliveries: make(map[int]internal.WebhookDelivery),
+		logSize:    logSize,
+	}
+}
+
+// WebhookMap is a struct that represents a webhook repository kept in memory
+// - it is safe for concurrent use
+// - the oldest succeeded deliveries are dropped past logSize, pending and dead ones are always kept
+type WebhookMap struct {
+	// mu guards every other field
+	mu sync.RWMutex
+	// webhooks is a map of webhooks
+	webhooks map[int]internal.Webhook
+	// deliveries is a map of deliveries
+	deliveries map[int]internal.WebhookDelivery
+	// logSize is how many succeeded deliveries are kept per webhook
+	logSize int
+	// seq is the last webhook id allocated
+	seq int
+	// deliverySeq is the last delivery id allocated
+	deliverySeq int
+}
+
+// Create is a method that stores a new webhook, assigning its id
+func (r *WebhookMap) Create(w *internal.Webhook) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.seq++
+	w.Id = r.seq
+	w.Events = slices.Clone(w.Events)
+	r.webhooks[w.Id] = *w
+	return
+}
+
+// FindAll is a method that returns every webhook sorted by id
+func (r *WebhookMap) FindAll() (w []internal.Webhook, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w = make([]internal.Webhook, 0, len(r.webhooks))
+	for _, value := range r.webhooks {
+		w = append(w, value)
+	}
+	sort.Slice(w, func(i, j int) bool { return w[i].Id < w[j].Id })
+	return
+}
+
+// FindById is a method that returns the webhook with the given id
+func (r *WebhookMap) FindById(id int) (w internal.Webhook, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	w, ok := r.webhooks[id]
+	if !ok {
+		return w, internal.ErrWebhookNotFoundRepo
+	}
+	return
+}
+
+// Delete is a method that removes the webhook with the given id and its deliveries
+func (r *WebhookMap) Delete(id int) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.webhooks[id]; !ok {
+		return internal.ErrWebhookNotFoundRepo
+	}
+	delete(r.webhooks, id)
+	for key, value := range r.deliveries {
+		if value.WebhookId == id {
+			delete(r.deliveries, key)
+		}
+	}
+	return
+}
+
+// CreateDelivery is a method that stores a new delivery, assigning its id
+func (r *WebhookMap) CreateDelivery(d *internal.WebhookDelivery) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.webhooks[d.WebhookId]; !ok {
+		return internal.ErrWebhookNotFoundRepo
+	}
+	r.deliverySeq++
+	d.Id = r.deliverySeq
+	r.deliveries[d.Id] = *d
+	return
+}
+
+// UpdateDelivery is a method that replaces the delivery with the same id
+// - succeeded deliveries past the log size of the webhook are dropped, the oldest first
+func (r *WebhookMap) UpdateDelivery(d internal.WebhookDelivery) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.deliveries[d.Id]; !ok {
+		return internal.ErrWebhookDeliveryNotFoundRepo
+	}
+	d.Attempts = slices.Clone(d.Attempts)
+	r.deliveries[d.Id] = d
+
+	if d.Status == internal.WebhookDeliverySucceeded && r.logSize > 0 {
+		r.trim(d.WebhookId)
+	}
+	return
+}
+
+// UpdateDeliveryFrom is a method that replaces the delivery with the same id only while its status is the given one
+func (r *WebhookMap) UpdateDeliveryFrom(d internal.WebhookDelivery, status string) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.deliveries[d.Id]
+	if !ok {
+		return internal.ErrWebhookDeliveryNotFoundRepo
+	}
+	if stored.Status != status {
+		return internal.ErrWebhookDeliveryChangedRepo
+	}
+	d.Attempts = slices.Clone(d.Attempts)
+	r.deliveries[d.Id] = d
+
+	if d.Status == internal.WebhookDeliverySucceeded && r.logSize > 0 {
+		r.trim(d.WebhookId)
+	}
+	return
+}
+
+// FindDeliveryById is a method that returns the delivery with the given id
+func (r *WebhookMap) FindDeliveryById(id int) (d internal.WebhookDelivery, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	d, ok := r.deliveries[id]
+	if !ok {
+		return d, internal.ErrWebhookDeliveryNotFoundRepo
+	}
+	return
+}
+
+// FindDeliveries is a method that returns the deliveries of the webhook sorted by id
+func (r *WebhookMap) FindDeliveries(webhookId int) (d []internal.WebhookDelivery, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.webhooks[webhookId]; !ok {
+		return nil, internal.ErrWebhookNotFoundRepo
+	}
+	return r.filter(func(value internal.WebhookDelivery) bool { return value.WebhookId == webhookId }), nil
+}
+
+// FindDeliveriesByStatus is a method that returns the deliveries with the given status sorted by id
+func (r *WebhookMap) FindDeliveriesByStatus(status string) (d []internal.WebhookDelivery, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.filter(func(value internal.WebhookDelivery) bool { return value.Status == status }), nil
+}
+
+// filter is a method that returns the deliveries matching the predicate sorted by id
+// - it is expected to be called with the lock held
+func (r *WebhookMap) filter(match func(d internal.WebhookDelivery) bool) (d []internal.WebhookDelivery) {
+	d = make([]internal.WebhookDelivery, 0)
+	for _, value := range r.deliveries {
+		if match(value) {
+			d = append(d, value)
+		}
+	}
+	sort.Slice(d, func(i, j int) bool { return d[i].Id < d[j].Id })
+	return
+}
+
+// trim is a method that drops the oldest succeeded deliveries of the webhook past the log size
+// - it is expected to be called with the lock held
+func (r *WebhookMap) trim(webhookId int) {
+	succeeded := r.filter(func(value internal.WebhookDelivery) bool {
+		return value.WebhookId == webhookId && value.Status == internal.WebhookDeliverySucceeded
+	})
+	for i := 0; i < len(succeeded)-r.logSize; i++ {
+		delete(r.deliveries, succeeded[i].Id)
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"app/internal"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// maxGroupBy is the maximum number of fields an aggregation can be grouped by
+const maxGroupBy = 4
+
+// Aggregate is a method that validates the aggregation, groups the vehicles and computes the metrics of each group
+func (s *VehicleDefault) Aggregate(a internal.VehicleAggregation) (rows []internal.VehicleAggregateRow, err error) {
+	// validate aggregation
+	a, err = validateVehicleAggregation(a)
+	if err != nil {
+		return nil, wrapError("aggregate", err)
+	}
+
+	// aggregate in repository
+	rows, err = s.rp.Aggregate(a)
+	if err != nil {
+		return nil, wrapError("aggregate", err)
+	}
+
+	// sort rows by group values
+	sort.Slice(rows, func(i, j int) bool {
+		for k := range rows[i].Group {
+			if c := internal.CompareVehicleFieldValues(rows[i].Group[k], rows[j].Group[k]); c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+
+	return rows, nil
+}
+
+// validateVehicleAggregation is a function that validates the aggregation
+// - group by fields must exist and not repeat
+// - metrics must use a known function on a numeric field, count takes no field
+func validateVehicleAggregation(a internal.VehicleAggregation) (validated internal.VehicleAggregation, err error) {
+	// filter
+	validated.Filter, err = validateVehicleFilter(a.Filter)
+	if err != nil {
+		return validated, fmt.Errorf("%w: %w", internal.ErrInvalidFilterService, err)
+	}
+
+	// group by
+	if len(a.GroupBy) > maxGroupBy {
+		return validated, fmt.Errorf("%w: at most %d group by fields", internal.ErrInvalidAggregationService, maxGroupBy)
+	}
+	seen := make(map[string]bool, len(a.GroupBy))
+	for _, name := range a.GroupBy {
+		if _, ok := internal.LookupVehicleField(name); !ok {
+			return validated, fmt.Errorf("%w: unknown group by field %q", internal.ErrInvalidAggregationService, name)
+		}
+		if seen[name] {
+			return validated, fmt.Errorf("%w: repeated group by field %q", internal.ErrInvalidAggregationService, name)
+		}
+		seen[name] = true
+	}
+	validated.GroupBy = a.GroupBy
+
+	// metrics
+	if len(a.Metrics) == 0 {
+		return validated, fmt.Errorf("%w: at least one metric is required", internal.ErrInvalidAggregationService)
+	}
+	validated.Metrics = make([]internal.VehicleMetric, 0, len(a.Metrics))
+	for _, m := range a.Metrics {
+		m.Function = internal.AggregateFunction(strings.ToLower(string(m.Function)))
+		switch m.Function {
+		case internal.AggregateCount:
+			if m.Field != "" {
+				return validated, fmt.Errorf("%w: count takes no field", internal.ErrInvalidAggregationService)
+			}
+		case internal.AggregateSum, internal.AggregateAvg, internal.AggregateMin, internal.AggregateMax:
+			field, ok := internal.LookupVehicleField(m.Field)
+			if !ok {
+				return validated, fmt.Errorf("%w: unknown metric field %q", internal.ErrInvalidAggregationService, m.Field)
+			}
+			if field.Kind == internal.VehicleFieldString {
+				return validated, fmt.Errorf("%w: %s requires a numeric field", internal.ErrInvalidAggregationService, m)
+			}
+		default:
+			return validated, fmt.Errorf("%w: unknown function %q", internal.ErrInvalidAggregationService, m.Function)
+		}
+		validated.Metrics = append(validated.Metrics, m)
+	}
+
+	return
+}
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"app/internal"
+	"context"
+	"fmt"
+	"time"
+)
+
+// FindAudit is a method that returns the audit entries matching the query, none when the service has no audit trail
+func (s *VehicleDefault) FindAudit(q internal.AuditQuery) (e []internal.AuditEntry, err error) {
+	if s.au == nil {
+		return
+	}
+
+	e, err = s.au.Find(q)
+	if err != nil {
+		return nil, wrapError("find audit", err)
+	}
+
+	return e, nil
+}
+
+// record is a method that appends the entries to the audit trail, if the service has one
+// - the entries are stamped with the current time and the actor of the context
+// - the changes are already done, a failure to record them is returned as ErrVehicleAuditUnrecordedService
+// so the caller knows the trail misses them
+func (s *VehicleDefault) record(ctx context.Context, entries ...internal.AuditEntry) (err error) {
+	if s.au == nil || len(entries) == 0 {
+		return
+	}
+
+	actor := internal.ActorFrom(ctx)
+	now := time.Now().UTC()
+	for i := range entries {
+		entries[i].Time = now
+		entries[i].Actor = actor
+	}
+
+	if err = s.au.Append(entries); err != nil {
+		return fmt.Errorf("%w: %w", internal.ErrVehicleAuditUnrecordedService, err)
+	}
+	return
+}
+
+// createdEntry is a function that returns the audit entry of the creation of the vehicle
+func createdEntry(v internal.Vehicle) internal.AuditEntry {
+	return internal.AuditEntry{
+		Operation: internal.AuditOpCreate,
+		VehicleId: v.Id,
+		Version:   v.Version,
+		Changes:   internal.DiffVehicles(nil, &v),
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"app/internal"
+	"app/internal/repository"
+	"context"
+	"errors"
+	"testing"
+)
+
+// auditStub is a struct that implements internal.AuditRepository keeping the entries appended
+type auditStub struct {
+	// entries are the entries appended
+	entries []internal.AuditEntry
+	// err is returned by Append when set
+	err error
+}
+
+// Append is a method that keeps the entries, or returns err
+func (a *auditStub) Append(e []internal.AuditEntry) (err error) {
+	if a.err != nil {
+		return a.err
+	}
+	a.entries = append(a.entries, e...)
+	return
+}
+
+// Find is a method that returns every entry
+func (a *auditStub) Find(q internal.AuditQuery) (e []internal.AuditEntry, err error) {
+	return a.entries, nil
+}
+
+// newTestVehicle is a function that returns a vehicle stored with the given id
+func newTestVehicle(id int) internal.Vehicle {
+	return internal.Vehicle{Id: id, Version: 1, VehicleAttributes: internal.VehicleAttributes{
+		Brand: "Ford", Model: "Fiesta", Registration: "REG-1", Color: "red", FabricationYear: 2010,
+		Capacity: 5, MaxSpeed: 180, FuelType: "gasoline", Transmission: "manual", Weight: 1100,
+		Dimensions: internal.Dimensions{Height: 1.5, Length: 4, Width: 1.7},
+	}}
+}
+
+func TestVehicleDefault_RecordsPreviousValues(t *testing.T) {
+	au := &auditStub{}
+	sv := NewVehicleDefault(repository.NewVehicleMap(map[int]internal.Vehicle{1: newTestVehicle(1)}), nil, au, nil)
+	ctx := context.Background()
+
+	v := newTestVehicle(1)
+	v.MaxSpeed = 200
+	if err := sv.Update(ctx, &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := sv.Delete(ctx, 1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(au.entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(au.entries))
+	}
+	update, del := au.entries[0], au.entries[1]
+	if len(update.Changes) != 1 || update.Changes[0].Before != 180.0 || update.Changes[0].After != 200.0 {
+		t.Errorf("got update changes %+v", update.Changes)
+	}
+	if del.Version != 3 || len(del.Changes) == 0 {
+		t.Errorf("got delete entry %+v", del)
+	}
+	for _, c := range del.Changes {
+		if c.Field == "max_speed" && c.Before != 200.0 {
+			t.Errorf("got max_speed before delete %v, want 200", c.Before)
+		}
+	}
+}
+
+func TestVehicleDefault_ReturnsAuditFailures(t *testing.T) {
+	au := &auditStub{err: errors.New("audit table locked")}
+	rp := repository.NewVehicleMap(map[int]internal.Vehicle{1: newTestVehicle(1)})
+	sv := NewVehicleDefault(rp, nil, au, nil)
+
+	v := newTestVehicle(1)
+	v.MaxSpeed = 200
+	err := sv.Update(context.Background(), &v)
+	if !errors.Is(err, internal.ErrVehicleAuditUnrecordedService) || !errors.Is(err, internal.ErrClassInternal) {
+		t.Fatalf("got %v, want ErrVehicleAuditUnrecordedService", err)
+	}
+
+	// the change is done all the same
+	if stored, _ := rp.FindById(1); stored.MaxSpeed != 200 {
+		t.Errorf("got max speed %v, want 200", stored.MaxSpeed)
+	}
+}
@@ -0,0 +1,102 @@
+package service
+
+import (
+	"app/internal"
+	"sync"
+)
+
+// vehicleSubscriberBuffer is the number of events a subscriber can fall behind before it is dropped
+const vehicleSubscriberBuffer = 64
+
+// NewVehicleBroker is a function that returns a new instance of VehicleBroker
+// - size is the number of events kept for replay, at least 1
+func NewVehicleBroker(size int) *VehicleBroker {
+	return &VehicleBroker{size: max(size, 1), subscribers: make(map[chan internal.VehicleEvent]struct{})}
+}
+
+// VehicleBroker is a struct that represents a vehicle event broker kept in memory
+// - it is safe for concurrent use
+// - the last size events are buffered so subscribers can resume after a disconnection
+// - publishing never blocks, a subscriber whose channel is full is dropped and expected to resume
+// - ids start over at 1 when the application restarts, so ids ahead of the last one are reported as missed
+type VehicleBroker struct {
+	// mu guards every other field
+	mu sync.Mutex
+	// size is the maximum number of events buffered
+	size int
+	// buffer are the last events published in order, only the last size of them are replayed
+	buffer []internal.VehicleEvent
+	// seq is the id of the last event published
+	seq int
+	// subscribers are the channels of the current subscriptions
+	subscribers map[chan internal.VehicleEvent]struct{}
+}
+
+// Publish is a method that assigns the next ids to the events and delivers them to the subscribers
+func (b *VehicleBroker) Publish(e ...internal.VehicleEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range e {
+		b.seq++
+		event.Id = b.seq
+
+		// buffer, dropping the oldest events once it holds twice the size so they are copied once in a while
+		b.buffer = append(b.buffer, event)
+		if len(b.buffer) >= 2*b.size {
+			b.buffer = append([]internal.VehicleEvent(nil), b.buffer[len(b.buffer)-b.size:]...)
+		}
+
+		// deliver
+		for ch := range b.subscribers {
+			select {
+			case ch <- event:
+			default:
+				delete(b.subscribers, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Subscribe is a method that returns a new subscription, replaying the buffered events after the given id
+func (b *VehicleBroker) Subscribe(after int) (s internal.VehicleSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// replay
+	s.Last = b.seq
+	if after >= 0 {
+		switch {
+		case after > b.seq:
+			s.Missed = true
+		case after < b.seq:
+			buffered := b.buffer[max(len(b.buffer)-b.size, 0):]
+			// - a subscriber that missed events must resync, the older events buffered would follow the reset
+			s.Missed = after < buffered[0].Id-1
+			if s.Missed {
+				break
+			}
+			for _, event := range buffered {
+				if event.Id > after {
+					s.Replay = append(s.Replay, event)
+				}
+			}
+		}
+	}
+
+	// subscribe
+	ch := make(chan internal.VehicleEvent, vehicleSubscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+	s.Events = ch
+	s.Cancel = func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+	return
+}
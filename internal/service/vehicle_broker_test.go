@@ -0,0 +1,88 @@
+package service
+
+import (
+	"app/internal"
+	"app/internal/repository"
+	"context"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// yieldingVehicleMap is a struct that implements internal.VehicleRepository yielding after every update
+// - it widens the window between a write and the publication of its event
+type yieldingVehicleMap struct {
+	*repository.VehicleMap
+}
+
+// Update is a method that updates the vehicle, then yields the processor
+func (r yieldingVehicleMap) Update(v *internal.Vehicle) (previous internal.Vehicle, err error) {
+	previous, err = r.VehicleMap.Update(v)
+	runtime.Gosched()
+	return
+}
+
+func TestVehicleBroker_SubscribeReplays(t *testing.T) {
+	b := NewVehicleBroker(3)
+	for i := 1; i <= 5; i++ {
+		b.Publish(internal.VehicleEvent{Type: internal.VehicleEventUpdated, Vehicle: newTestVehicle(i)})
+	}
+
+	// the buffered events after the id
+	sub := b.Subscribe(3)
+	defer sub.Cancel()
+	if sub.Missed || sub.Last != 5 || len(sub.Replay) != 2 || sub.Replay[0].Id != 4 || sub.Replay[1].Id != 5 {
+		t.Errorf("got %+v, want events 4 and 5 replayed", sub)
+	}
+
+	// events no longer buffered are missed, nothing older than the reset is replayed
+	sub = b.Subscribe(1)
+	defer sub.Cancel()
+	if !sub.Missed || sub.Last != 5 || len(sub.Replay) != 0 {
+		t.Errorf("got %+v, want missed up to 5 and nothing replayed", sub)
+	}
+
+	// ids ahead of the last one, e.g. from before a restart, are missed
+	sub = b.Subscribe(9)
+	defer sub.Cancel()
+	if !sub.Missed || sub.Last != 5 || len(sub.Replay) != 0 {
+		t.Errorf("got %+v, want missed up to 5 and nothing replayed", sub)
+	}
+}
+
+// TestVehicleDefault_EventsInVersionOrder updates a vehicle from many goroutines and checks the events follow its versions
+func TestVehicleDefault_EventsInVersionOrder(t *testing.T) {
+	const n = 200
+	b := NewVehicleBroker(n)
+	rp := yieldingVehicleMap{repository.NewVehicleMap(map[int]internal.Vehicle{1: newTestVehicle(1)})}
+	sv := NewVehicleDefault(rp, nil, nil, b)
+
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			v := newTestVehicle(1)
+			v.Version = 0
+			if err := sv.Update(context.Background(), &v); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	// replayed from the broker buffer, the subscriber ones are smaller than n
+	replayed := b.Subscribe(0)
+	defer replayed.Cancel()
+	if len(replayed.Replay) != n {
+		t.Fatalf("got %d events, want %d", len(replayed.Replay), n)
+	}
+	for i, e := range replayed.Replay {
+		if e.Vehicle.Version != i+2 {
+			t.Fatalf("got version %d in event %d, want %d", e.Vehicle.Version, e.Id, i+2)
+		}
+	}
+}
@@ -2,187 +2,298 @@ package service
 
 import (
 	"app/internal"
+	"context"
 	"errors"
 	"fmt"
-	"math"
+	"sync"
+	"time"
 )
 
 // NewVehicleDefault is a function that returns a new instance of VehicleDefault
-func NewVehicleDefault(rp internal.VehicleRepository) *VehicleDefault {
-	return &VehicleDefault{rp: rp}
+// - vl validates the vehicles before they are created or updated, nil disables validation
+// - au records the changes made through the service, nil disables the audit trail
+// - ev publishes the changes made through the service, nil disables the events
+func NewVehicleDefault(rp internal.VehicleRepository, vl internal.VehicleValidator, au internal.AuditRepository, ev internal.VehicleEventBroker) *VehicleDefault {
+	return &VehicleDefault{rp: rp, vl: vl, au: au, ev: ev}
 }
 
 // VehicleDefault is a struct that represents the default service for vehicles
 type VehicleDefault struct {
 	// rp is the repository that will be used by the service
 	rp internal.VehicleRepository
+	// vl is the validator of the vehicles written through the service
+	vl internal.VehicleValidator
+	// au is the audit trail of the changes made through the service
+	au internal.AuditRepository
+	// ev is the broker the changes made through the service are published to
+	ev internal.VehicleEventBroker
+	// wr serializes the writes with the publication of their events
+	wr sync.Mutex
 }
 
 // FindAll is a method that returns a map of all vehicles
 func (s *VehicleDefault) FindAll() (v map[int]internal.Vehicle, err error) {
 	v, err = s.rp.FindAll()
-	return
+	if err != nil {
+		return nil, wrapError("find all", err)
+	}
+
+	return v, nil
 }
 
-func (s *VehicleDefault) Create(v internal.Vehicle) (err error) {
-	// create vehicle in repository
-	if err = s.rp.Create(v); err != nil {
-		// check error type
-		switch {
-		case errors.Is(err, internal.ErrVehicleAlreadyExistsRepo):
-			return fmt.Errorf("%w: %w", internal.ErrVehicleAlreadyExistsService, err)
-		}
+// FindById is a method that returns the vehicle with the given id
+func (s *VehicleDefault) FindById(id int) (v internal.Vehicle, err error) {
+	v, err = s.rp.FindById(id)
+	if err != nil {
+		return v, wrapError("find by id", err)
 	}
-	// return nil error
-	return nil
-}
 
-func (s *VehicleDefault) GetByColorAndYear(color string, year int) (v map[int]internal.Vehicle, err error) {
-	// get vehicles by color and year from repository
-	v, err = s.rp.GetByColorAndYear(color, year)
+	return v, nil
+}
 
-	// check error type
+// FindAllAsOf is a method that returns the vehicles as they were at the given time, including the ones deleted since
+func (s *VehicleDefault) FindAllAsOf(t time.Time) (v map[int]internal.Vehicle, err error) {
+	v, err = s.rp.FindAllAsOf(t)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByColorYearRepo):
-			return nil, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return nil, wrapError("find all as of", err)
 	}
 
-	// return vehicles
 	return v, nil
 }
 
-func (s *VehicleDefault) GetByBrandBetweenYears(brand string, yearStart int, yearEnd int) (v map[int]internal.Vehicle, err error) {
-	// get vehicles by brand and years from repository
-	v, err = s.rp.GetByBrandBetweenYears(brand, yearStart, yearEnd)
-
-	// check error type
+// FindByIdAsOf is a method that returns the vehicle with the given id as it was at the given time
+func (s *VehicleDefault) FindByIdAsOf(id int, t time.Time) (v internal.Vehicle, err error) {
+	v, err = s.rp.FindByIdAsOf(id, t)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByBrandYearsRepo):
-			return nil, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return v, wrapError("find by id as of", err)
 	}
 
-	// return vehicles
 	return v, nil
 }
 
-func (s *VehicleDefault) GetSpeedAvgByBrand(brand string) (speedAvg float64, err error) {
-	speedAvg, err = s.rp.GetSpeedAvgByBrand(brand)
-
+// FindByRegistration is a method that returns the vehicle with the given registration
+func (s *VehicleDefault) FindByRegistration(registration string) (v internal.Vehicle, err error) {
+	v, err = s.rp.FindByRegistration(registration)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByBrandRepo):
-			return 0, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return v, wrapError("find by registration", err)
 	}
 
-	return speedAvg, nil
+	return v, nil
 }
 
-func (s *VehicleDefault) CreateMultiple(v map[int]internal.Vehicle) (err error) {
-	err = s.rp.CreateMultiple(v)
+func (s *VehicleDefault) Create(ctx context.Context, v *internal.Vehicle) (err error) {
+	// validate vehicle
+	if err = s.validate(*v); err != nil {
+		return wrapError("create", err)
+	}
 
+	// create vehicle in repository and publish
+	err = s.write(internal.VehicleEventCreated, func() (w []internal.Vehicle, err error) {
+		err = s.rp.Create(v)
+		return []internal.Vehicle{*v}, err
+	})
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrVehicleAlreadyExistsRepo):
-			return fmt.Errorf("%w: %w", internal.ErrVehicleAlreadyExistsService, err)
-		}
+		return wrapError("create", err)
+	}
+
+	// record in audit trail
+	if err = s.record(ctx, createdEntry(*v)); err != nil {
+		return wrapError("create", err)
 	}
 
+	// return nil error
 	return nil
 }
 
-func (s *VehicleDefault) ListByWeightRange(weightMin, weightMax float64) (v map[int]internal.Vehicle, err error) {
-	v, err = s.rp.ListByWeightRange(weightMin, weightMax)
+func (s *VehicleDefault) GetSpeedAvgByBrand(brand string) (speedAvg float64, err error) {
+	speedAvg, err = s.rp.GetSpeedAvgByBrand(brand)
 
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByWeightRangeRepo):
-			return nil, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return 0, wrapError("get speed avg by brand", err)
 	}
 
-	return v, nil
+	return speedAvg, nil
 }
 
-func (s *VehicleDefault) ListByDimensions(d map[string]float64) (v map[int]internal.Vehicle, err error) {
+func (s *VehicleDefault) CreateMultiple(ctx context.Context, v []internal.Vehicle) (err error) {
+	// validate every vehicle, naming fields after their position in the batch
+	var invalid internal.VehicleValidationError
+	for i, value := range v {
+		var ve *internal.VehicleValidationError
+		if err = s.validate(value); errors.As(err, &ve) {
+			for _, fe := range ve.Errors {
+				fe.Field = fmt.Sprintf("vehicles[%d].%s", i, fe.Field)
+				invalid.Errors = append(invalid.Errors, fe)
+			}
+		}
+	}
+	if len(invalid.Errors) > 0 {
+		return wrapError("create multiple", &invalid)
+	}
 
-	//Dimensions modifier
-	var minLength = 0.0
-	var maxLength = math.MaxFloat64
-	var minWidth = 0.0
-	var maxWidth = math.MaxFloat64
+	err = s.write(internal.VehicleEventCreated, func() (w []internal.Vehicle, err error) {
+		err = s.rp.CreateMultiple(v)
+		return v, err
+	})
 
-	if _, ok := d["min_length"]; ok {
-		minLength = d["min_length"]
+	if err != nil {
+		return wrapError("create multiple", err)
 	}
 
-	if _, ok := d["max_length"]; ok {
-		maxLength = d["max_length"]
+	// record in audit trail, one entry per vehicle
+	entries := make([]internal.AuditEntry, 0, len(v))
+	for _, value := range v {
+		entries = append(entries, createdEntry(value))
 	}
-
-	if _, ok := d["min_width"]; ok {
-		minWidth = d["min_width"]
+	if err = s.record(ctx, entries...); err != nil {
+		return wrapError("create multiple", err)
 	}
 
-	if _, ok := d["max_width"]; ok {
-		maxWidth = d["max_width"]
+	return nil
+}
+
+func (s *VehicleDefault) Update(ctx context.Context, v *internal.Vehicle) (err error) {
+	if err = s.validate(*v); err != nil {
+		return wrapError("update", err)
 	}
 
-	v, err = s.rp.ListByDimensions(minLength, maxLength, minWidth, maxWidth)
+	var before internal.Vehicle
+	err = s.write(internal.VehicleEventUpdated, func() (w []internal.Vehicle, err error) {
+		before, err = s.rp.Update(v)
+		return []internal.Vehicle{*v}, err
+	})
 
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByDimensionsRepo):
-			return nil, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return wrapError("update", err)
 	}
 
-	return v, nil
+	err = s.record(ctx, internal.AuditEntry{
+		Operation: internal.AuditOpUpdate,
+		VehicleId: v.Id,
+		Version:   v.Version,
+		Changes:   internal.DiffVehicles(&before, v),
+	})
+	if err != nil {
+		return wrapError("update", err)
+	}
+
+	return nil
 }
 
-func (s *VehicleDefault) Update(v *internal.Vehicle) (err error) {
+func (s *VehicleDefault) Delete(ctx context.Context, id int, version int) (err error) {
+	var deleted internal.Vehicle
+	err = s.write(internal.VehicleEventDeleted, func() (w []internal.Vehicle, err error) {
+		deleted, err = s.rp.Delete(id, version)
+		return []internal.Vehicle{deleted}, err
+	})
 
-	err = s.rp.Update(v)
+	if err != nil {
+		return wrapError("delete", err)
+	}
 
+	err = s.record(ctx, internal.AuditEntry{
+		Operation: internal.AuditOpDelete,
+		VehicleId: id,
+		Version:   deleted.Version,
+		Changes:   internal.DiffVehicles(&deleted, nil),
+	})
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrVehicleNotFoundRepo):
-			return fmt.Errorf("%w: %w", internal.ErrVehicleNotFoundService, err)
-		}
+		return wrapError("delete", err)
 	}
 
 	return nil
+}
+
+// FindDeleted is a method that returns the vehicles in the trash
+func (s *VehicleDefault) FindDeleted() (v map[int]internal.Vehicle, err error) {
+	v, err = s.rp.FindDeleted()
+	if err != nil {
+		return nil, wrapError("find deleted", err)
+	}
 
+	return v, nil
 }
 
-func (s *VehicleDefault) Delete(id int) (err error) {
+// Restore is a method that moves the vehicle out of the trash
+func (s *VehicleDefault) Restore(ctx context.Context, id int) (v internal.Vehicle, err error) {
+	err = s.write(internal.VehicleEventCreated, func() (w []internal.Vehicle, err error) {
+		v, err = s.rp.Restore(id)
+		return []internal.Vehicle{v}, err
+	})
+	if err != nil {
+		return v, wrapError("restore", err)
+	}
+
+	err = s.record(ctx, internal.AuditEntry{
+		Operation: internal.AuditOpRestore,
+		VehicleId: id,
+		Version:   v.Version,
+		Changes:   internal.DiffVehicles(nil, &v),
+	})
+	if err != nil {
+		return v, wrapError("restore", err)
+	}
 
-	err = s.rp.Delete(id)
+	return v, nil
+}
 
+// Purge is a method that permanently removes the vehicles deleted before the given time
+func (s *VehicleDefault) Purge(ctx context.Context, before time.Time) (ids []int, err error) {
+	ids, err = s.rp.Purge(before)
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrVehicleNotFoundRepo):
-			return fmt.Errorf("%w: %w", internal.ErrVehicleNotFoundService, err)
-		}
+		return nil, wrapError("purge", err)
 	}
 
-	return nil
+	entries := make([]internal.AuditEntry, 0, len(ids))
+	for _, id := range ids {
+		entries = append(entries, internal.AuditEntry{Operation: internal.AuditOpPurge, VehicleId: id})
+	}
+	if err = s.record(ctx, entries...); err != nil {
+		return ids, wrapError("purge", err)
+	}
 
+	return ids, nil
 }
 
 func (s *VehicleDefault) GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error) {
 	capacityAvg, err = s.rp.GetAverageCapacityByBrand(brand)
 
 	if err != nil {
-		switch {
-		case errors.Is(err, internal.ErrNoVehiclesByBrandRepo):
-			return 0, fmt.Errorf("%w: %w", internal.ErrVehiclesNotFoundByCriteria, err)
-		}
+		return 0, wrapError("get average capacity by brand", err)
 	}
 
 	return capacityAvg, nil
 }
+
+func (s *VehicleDefault) FindByFilter(f internal.VehicleFilter) (v map[int]internal.Vehicle, err error) {
+	// validate filter
+	f, err = validateVehicleFilter(f)
+	if err != nil {
+		return nil, wrapError("find by filter", fmt.Errorf("%w: %w", internal.ErrInvalidFilterService, err))
+	}
+
+	// get vehicles matching the filter from repository
+	v, err = s.rp.FindByFilter(f)
+	if err != nil {
+		return nil, wrapError("find by filter", err)
+	}
+
+	return v, nil
+}
+
+func (s *VehicleDefault) GetBrandAggregates() (a map[string]internal.BrandAggregate, err error) {
+	a, err = s.rp.GetBrandAggregates()
+	if err != nil {
+		return nil, wrapError("get brand aggregates", err)
+	}
+
+	return a, nil
+}
+
+// validate is a method that validates the vehicle, if the service has a validator
+func (s *VehicleDefault) validate(v internal.Vehicle) (err error) {
+	if s.vl == nil {
+		return
+	}
+	return s.vl.Validate(v)
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"app/internal"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+)
+
+// repositoryErrorKinds maps the repository sentinels to the service sentinel and class they are reported as
+var repositoryErrorKinds = []struct {
+	// repo is the repository sentinel
+	repo error
+	// kind is the service sentinel
+	kind error
+	// class is the class of the error
+	class error
+}{
+	{repo: internal.ErrVehicleNotFoundRepo, kind: internal.ErrVehicleNotFoundService, class: internal.ErrClassNotFound},
+	{repo: internal.ErrNoVehicleByRegistrationRepo, kind: internal.ErrVehicleNotFoundService, class: internal.ErrClassNotFound},
+	{repo: internal.ErrNoVehiclesByBrandRepo, kind: internal.ErrVehiclesNotFoundByCriteria, class: internal.ErrClassNotFound},
+	{repo: internal.ErrVehicleAlreadyExistsRepo, kind: internal.ErrVehicleAlreadyExistsService, class: internal.ErrClassConflict},
+	{repo: internal.ErrVehicleVersionConflictRepo, kind: internal.ErrVehicleVersionConflict, class: internal.ErrClassConflict},
+	{repo: internal.ErrVehicleRegistrationExistsRepo, kind: internal.ErrVehicleRegistrationExistsService, class: internal.ErrClassConflict},
+	{repo: internal.ErrVehicleNotDeletedRepo, kind: internal.ErrVehicleNotDeletedService, class: internal.ErrClassConflict},
+	{repo: internal.ErrVehicleHistoryUnavailableRepo, kind: internal.ErrVehicleHistoryUnavailableService, class: internal.ErrClassValidation},
+	{repo: internal.ErrWebhookNotFoundRepo, kind: internal.ErrWebhookNotFoundService, class: internal.ErrClassNotFound},
+	{repo: internal.ErrWebhookDeliveryNotFoundRepo, kind: internal.ErrWebhookDeliveryNotFoundService, class: internal.ErrClassNotFound},
+}
+
+// serviceErrorClasses maps the errors raised by the service itself to their class
+var serviceErrorClasses = []struct {
+	// err is the service sentinel
+	err error
+	// class is the class of the error
+	class error
+}{
+	{err: internal.ErrVehiclesNotFoundByCriteria, class: internal.ErrClassNotFound},
+	{err: internal.ErrVehicleInvalidService, class: internal.ErrClassValidation},
+	{err: internal.ErrInvalidFilterService, class: internal.ErrClassValidation},
+	{err: internal.ErrInvalidPercentileService, class: internal.ErrClassValidation},
+	{err: internal.ErrInvalidAggregationService, class: internal.ErrClassValidation},
+	{err: internal.ErrVehicleEventsUnavailableService, class: internal.ErrClassUnavailable},
+	{err: internal.ErrVehicleAuditUnrecordedService, class: internal.ErrClassInternal},
+	{err: internal.ErrWebhookInvalidService, class: internal.ErrClassValidation},
+	{err: internal.ErrWebhookDeliveryNotDeadService, class: internal.ErrClassConflict},
+}
+
+// wrapError is a function that returns the error as a classified *internal.VehicleServiceError of the operation
+// - errors raised by the service keep their sentinel, repository sentinels are translated to service ones
+// - storage failures, timeouts and broken connections are unavailable, anything else is internal
+func wrapError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	// already classified by another method of the service
+	var classified *internal.VehicleServiceError
+	if errors.As(err, &classified) {
+		return err
+	}
+
+	se := &internal.VehicleServiceError{Op: op, Err: err}
+	for _, c := range serviceErrorClasses {
+		if errors.Is(err, c.err) {
+			se.Class = c.class
+			return se
+		}
+	}
+	for _, k := range repositoryErrorKinds {
+		if errors.Is(err, k.repo) {
+			se.Class, se.Kind = k.class, k.kind
+			return se
+		}
+	}
+	switch {
+	case errors.Is(err, internal.ErrVehicleStorageRepo),
+		errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled),
+		errors.Is(err, driver.ErrBadConn),
+		errors.Is(err, sql.ErrConnDone):
+		se.Class = internal.ErrClassUnavailable
+	default:
+		se.Class = internal.ErrClassInternal
+	}
+	return se
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"app/internal"
+	"time"
+)
+
+// Subscribe is a method that returns a new subscription to the changes made through the service
+// - a negative id subscribes to the changes made from now on only, see internal.VehicleEventBroker
+func (s *VehicleDefault) Subscribe(after int) (sub internal.VehicleSubscription, err error) {
+	if s.ev == nil {
+		return sub, wrapError("subscribe", internal.ErrVehicleEventsUnavailableService)
+	}
+
+	return s.ev.Subscribe(after), nil
+}
+
+// write is a method that applies a change through the repository and publishes an event of the given type for each vehicle it returns
+// - writes are serialized with their publication, so the events are in the order the changes were applied
+// - nothing is published when the change fails
+func (s *VehicleDefault) write(typ string, apply func() (v []internal.Vehicle, err error)) (err error) {
+	s.wr.Lock()
+	defer s.wr.Unlock()
+
+	v, err := apply()
+	if err != nil {
+		return
+	}
+	s.publish(typ, v...)
+	return
+}
+
+// publish is a method that publishes an event of the given type for each vehicle, if the service has a broker
+func (s *VehicleDefault) publish(typ string, v ...internal.Vehicle) {
+	if s.ev == nil || len(v) == 0 {
+		return
+	}
+
+	now := time.Now().UTC()
+	events := make([]internal.VehicleEvent, 0, len(v))
+	for _, value := range v {
+		events = append(events, internal.VehicleEvent{Type: typ, Time: now, Vehicle: value})
+	}
+	s.ev.Publish(events...)
+}
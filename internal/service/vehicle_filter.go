@@ -0,0 +1,103 @@
+package service
+
+import (
+	"app/internal"
+	"fmt"
+	"strconv"
+)
+
+// validateVehicleFilter is a function that validates the filter and types its values after the kind of each field
+// - every field must exist and every operator must apply to the kind of its field
+// - values given as strings are parsed into int or float64 for numeric fields
+func validateVehicleFilter(f internal.VehicleFilter) (validated internal.VehicleFilter, err error) {
+	switch {
+	case f.IsZero():
+		return f, nil
+	case f.IsGroup():
+		if f.Logic != internal.FilterAnd && f.Logic != internal.FilterOr {
+			return f, fmt.Errorf("unknown logical operator %q", f.Logic)
+		}
+
+		validated = internal.VehicleFilter{Logic: f.Logic, Filters: make([]internal.VehicleFilter, 0, len(f.Filters))}
+		for _, sub := range f.Filters {
+			var v internal.VehicleFilter
+			if v, err = validateVehicleFilter(sub); err != nil {
+				return f, err
+			}
+			validated.Filters = append(validated.Filters, v)
+		}
+		return
+	}
+
+	// field
+	field, ok := internal.LookupVehicleField(f.Field)
+	if !ok {
+		return f, fmt.Errorf("unknown field %q", f.Field)
+	}
+
+	// operator
+	switch f.Operator {
+	case internal.FilterEq, internal.FilterNe, internal.FilterLt, internal.FilterLte, internal.FilterGt, internal.FilterGte:
+		if len(f.Values) != 1 {
+			return f, fmt.Errorf("operator %q on field %q expects one value", f.Operator, f.Field)
+		}
+	case internal.FilterIn:
+		if len(f.Values) == 0 {
+			return f, fmt.Errorf("operator %q on field %q expects at least one value", f.Operator, f.Field)
+		}
+	case internal.FilterContains, internal.FilterPrefix:
+		if field.Kind != internal.VehicleFieldString {
+			return f, fmt.Errorf("operator %q does not apply to numeric field %q", f.Operator, f.Field)
+		}
+		if len(f.Values) != 1 {
+			return f, fmt.Errorf("operator %q on field %q expects one value", f.Operator, f.Field)
+		}
+	default:
+		return f, fmt.Errorf("unknown operator %q on field %q", f.Operator, f.Field)
+	}
+
+	// values
+	validated = internal.VehicleFilter{Field: f.Field, Operator: f.Operator, Values: make([]any, 0, len(f.Values))}
+	for _, value := range f.Values {
+		var typed any
+		if typed, err = typeVehicleFieldValue(field, value); err != nil {
+			return f, err
+		}
+		validated.Values = append(validated.Values, typed)
+	}
+	return
+}
+
+// typeVehicleFieldValue is a function that converts the value to the type held by the field
+func typeVehicleFieldValue(field internal.VehicleField, value any) (typed any, err error) {
+	switch value := value.(type) {
+	case string:
+		switch field.Kind {
+		case internal.VehicleFieldString:
+			return value, nil
+		case internal.VehicleFieldInt:
+			if typed, err = strconv.Atoi(value); err != nil {
+				return nil, fmt.Errorf("invalid integer %q for field %q", value, field.Name)
+			}
+			return
+		case internal.VehicleFieldFloat:
+			if typed, err = strconv.ParseFloat(value, 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q for field %q", value, field.Name)
+			}
+			return
+		}
+	case int:
+		switch field.Kind {
+		case internal.VehicleFieldInt:
+			return value, nil
+		case internal.VehicleFieldFloat:
+			return float64(value), nil
+		}
+	case float64:
+		if field.Kind == internal.VehicleFieldFloat {
+			return value, nil
+		}
+	}
+
+	return nil, fmt.Errorf("invalid value %v for field %q", value, field.Name)
+}
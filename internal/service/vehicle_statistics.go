@@ -0,0 +1,98 @@
+package service
+
+import (
+	"app/internal"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// VehicleStatisticsFields are the fields described by GetStatistics
+var VehicleStatisticsFields = []string{"max_speed", "passengers", "weight", "height", "length", "width"}
+
+// GetStatistics is a method that returns the statistics of VehicleStatisticsFields for the vehicles matching the filter
+// - percentiles are between 0 and 100 and interpolated linearly between the closest ranks
+func (s *VehicleDefault) GetStatistics(f internal.VehicleFilter, percentiles []float64) (st map[string]internal.VehicleStatistics, err error) {
+	// validate percentiles
+	for _, p := range percentiles {
+		if math.IsNaN(p) || p < 0 || p > 100 {
+			return nil, wrapError("get statistics", fmt.Errorf("%w: %v", internal.ErrInvalidPercentileService, p))
+		}
+	}
+
+	// get vehicles matching the filter
+	v, err := s.FindByFilter(f)
+	if err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, wrapError("get statistics", internal.ErrVehiclesNotFoundByCriteria)
+	}
+
+	// describe each field
+	st = make(map[string]internal.VehicleStatistics, len(VehicleStatisticsFields))
+	values := make([]float64, 0, len(v))
+	for _, name := range VehicleStatisticsFields {
+		field, _ := internal.LookupVehicleField(name)
+
+		values = values[:0]
+		for _, vh := range v {
+			switch value := field.Value(vh).(type) {
+			case int:
+				values = append(values, float64(value))
+			case float64:
+				values = append(values, value)
+			}
+		}
+
+		st[name] = describe(values, percentiles)
+	}
+
+	return
+}
+
+// describe is a function that returns the statistics of the values, which are sorted in place
+func describe(values []float64, percentiles []float64) (st internal.VehicleStatistics) {
+	sort.Float64s(values)
+
+	st.Count = len(values)
+	if st.Count == 0 {
+		return
+	}
+	st.Min = values[0]
+	st.Max = values[len(values)-1]
+
+	// mean
+	var sum float64
+	for _, value := range values {
+		sum += value
+	}
+	st.Mean = sum / float64(st.Count)
+
+	// standard deviation
+	var squares float64
+	for _, value := range values {
+		squares += (value - st.Mean) * (value - st.Mean)
+	}
+	st.StdDev = math.Sqrt(squares / float64(st.Count))
+
+	// percentiles
+	st.Median = percentile(values, 50)
+	st.Percentiles = make(map[float64]float64, len(percentiles))
+	for _, p := range percentiles {
+		st.Percentiles[p] = percentile(values, p)
+	}
+
+	return
+}
+
+// percentile is a function that returns the p-th percentile of the sorted values, interpolating between ranks
+func percentile(sorted []float64, p float64) float64 {
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
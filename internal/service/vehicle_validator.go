@@ -0,0 +1,156 @@
+package service
+
+import (
+	"app/internal"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// VehicleRange is a struct that represents the range of values accepted for a numeric field
+type VehicleRange struct {
+	// Min is the lowest value accepted
+	Min float64
+	// Max is the highest value accepted
+	Max float64
+	// MinExclusive is whether Min itself is rejected
+	MinExclusive bool
+}
+
+// VehicleRules is a struct that represents the rules a vehicle must follow to be stored
+type VehicleRules struct {
+	// Required are the text fields that cannot be empty, named as in internal.VehicleFields
+	Required []string
+	// MinYear is the oldest fabrication year accepted
+	MinYear int
+	// MaxYearsAhead is how many years after the current one the fabrication year may be (model years)
+	MaxYearsAhead int
+	// Capacity is the range of passengers accepted
+	Capacity VehicleRange
+	// MaxSpeed is the range of maximum speeds accepted
+	MaxSpeed VehicleRange
+	// Weight is the range of weights accepted
+	Weight VehicleRange
+	// Height is the range of heights accepted
+	Height VehicleRange
+	// Length is the range of lengths accepted
+	Length VehicleRange
+	// Width is the range of widths accepted
+	Width VehicleRange
+	// FuelTypes are the fuel types accepted, any when empty
+	FuelTypes []string
+	// Transmissions are the transmissions accepted, any when empty
+	Transmissions []string
+}
+
+// DefaultVehicleRules is a function that returns the rules applied when none are configured
+// - the length of the seed vehicles is unknown (0), so 0 is accepted as a length
+func DefaultVehicleRules() VehicleRules {
+	return VehicleRules{
+		Required:      []string{"brand", "model", "registration", "color"},
+		MinYear:       1900,
+		MaxYearsAhead: 1,
+		Capacity:      VehicleRange{Min: 1, Max: 100},
+		MaxSpeed:      VehicleRange{Min: 0, Max: 400, MinExclusive: true},
+		Weight:        VehicleRange{Min: 0, Max: 50000, MinExclusive: true},
+		Height:        VehicleRange{Min: 0, Max: 1000, MinExclusive: true},
+		Length:        VehicleRange{Min: 0, Max: 5000},
+		Width:         VehicleRange{Min: 0, Max: 1000, MinExclusive: true},
+		FuelTypes:     []string{"gas", "gasoline", "diesel", "biodiesel", "electric", "hybrid"},
+		Transmissions: []string{"automatic", "manual", "semi-automatic"},
+	}
+}
+
+// NewVehicleRulesValidator is a function that returns a new instance of VehicleRulesValidator
+func NewVehicleRulesValidator(rules VehicleRules) *VehicleRulesValidator {
+	return &VehicleRulesValidator{rules: rules, now: time.Now}
+}
+
+// VehicleRulesValidator is a struct that represents a validator checking vehicles against configurable rules
+type VehicleRulesValidator struct {
+	// rules are the rules checked
+	rules VehicleRules
+	// now returns the current time, used to bound the fabrication year
+	now func() time.Time
+}
+
+// Validate is a method that returns a *internal.VehicleValidationError listing every rule the vehicle breaks
+func (vl *VehicleRulesValidator) Validate(v internal.Vehicle) (err error) {
+	errs := vl.check(v)
+	if len(errs) > 0 {
+		return &internal.VehicleValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// check is a method that returns the rules the vehicle breaks, in field order
+func (vl *VehicleRulesValidator) check(v internal.Vehicle) (errs []internal.VehicleFieldError) {
+	// id, 0 is assigned by the repository on creation
+	if v.Id < 0 {
+		errs = append(errs, internal.VehicleFieldError{Field: "id", Rule: "min", Message: "must not be negative"})
+	}
+
+	// required text fields
+	for _, name := range vl.rules.Required {
+		field, ok := internal.LookupVehicleField(name)
+		if !ok || field.Kind != internal.VehicleFieldString {
+			continue
+		}
+		if strings.TrimSpace(field.Value(v).(string)) == "" {
+			errs = append(errs, internal.VehicleFieldError{Field: name, Rule: "required", Message: "is required"})
+		}
+	}
+
+	// year
+	maxYear := vl.now().Year() + vl.rules.MaxYearsAhead
+	if v.FabricationYear < vl.rules.MinYear || v.FabricationYear > maxYear {
+		errs = append(errs, internal.VehicleFieldError{
+			Field:   "year",
+			Rule:    "range",
+			Message: fmt.Sprintf("must be between %d and %d", vl.rules.MinYear, maxYear),
+		})
+	}
+
+	// ranges
+	errs = appendRangeError(errs, "passengers", float64(v.Capacity), vl.rules.Capacity)
+	errs = appendRangeError(errs, "max_speed", v.MaxSpeed, vl.rules.MaxSpeed)
+	errs = appendRangeError(errs, "weight", v.Weight, vl.rules.Weight)
+	errs = appendRangeError(errs, "height", v.Height, vl.rules.Height)
+	errs = appendRangeError(errs, "length", v.Length, vl.rules.Length)
+	errs = appendRangeError(errs, "width", v.Width, vl.rules.Width)
+
+	// enumerations
+	errs = appendEnumError(errs, "fuel_type", v.FuelType, vl.rules.FuelTypes)
+	errs = appendEnumError(errs, "transmission", v.Transmission, vl.rules.Transmissions)
+
+	return
+}
+
+// appendRangeError is a function that appends an error when the value is out of the range
+func appendRangeError(errs []internal.VehicleFieldError, name string, value float64, r VehicleRange) []internal.VehicleFieldError {
+	if value < r.Min || value > r.Max || (r.MinExclusive && value == r.Min) {
+		message := fmt.Sprintf("must be between %g and %g", r.Min, r.Max)
+		if r.MinExclusive {
+			message = fmt.Sprintf("must be greater than %g and at most %g", r.Min, r.Max)
+		}
+		errs = append(errs, internal.VehicleFieldError{Field: name, Rule: "range", Message: message})
+	}
+	return errs
+}
+
+// appendEnumError is a function that appends an error when the value is not one of the allowed ones
+func appendEnumError(errs []internal.VehicleFieldError, name, value string, allowed []string) []internal.VehicleFieldError {
+	if len(allowed) == 0 {
+		return errs
+	}
+	for _, a := range allowed {
+		if value == a {
+			return errs
+		}
+	}
+	return append(errs, internal.VehicleFieldError{
+		Field:   name,
+		Rule:    "enum",
+		Message: fmt.Sprintf("must be one of %s", strings.Join(allowed, ", ")),
+	})
+}
@@ -0,0 +1,485 @@
+package service
+
+import (
+	"app/internal"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Headers of the requests posted to the webhooks
+const (
+	// WebhookHeaderDelivery is the id of the delivery, the same on every attempt
+	WebhookHeaderDelivery = "X-Webhook-Delivery"
+	// WebhookHeaderEvent is the type of the event
+	WebhookHeaderEvent = "X-Webhook-Event"
+	// WebhookHeaderTimestamp is when the attempt was made, in unix seconds
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	// WebhookHeaderSignature is the signature of the attempt, see SignWebhookPayload
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+// webhookEventTypes are the types of the events a webhook can subscribe to
+var webhookEventTypes = []string{internal.VehicleEventCreated, internal.VehicleEventUpdated, internal.VehicleEventDeleted}
+
+// WebhookConfig is a struct that represents the configuration of the deliveries of WebhookDefault
+type WebhookConfig struct {
+	// Timeout is how long a webhook has to respond to an attempt (default 10 seconds)
+	Timeout time.Duration
+	// AllowedNetworks are the internal networks the webhooks may reach (default none)
+	// - loopback, private, link-local, multicast and unspecified addresses are refused otherwise
+	AllowedNetworks []netip.Prefix
+	// Workers is how many deliveries are attempted at once (default 8)
+	Workers int
+	// MaxAttempts is how many times a delivery is attempted before it is dead (default 6)
+	MaxAttempts int
+	// Backoff is the delay before the second attempt, doubled for each of the next ones (default 1 second)
+	Backoff time.Duration
+	// MaxBackoff is the maximum delay between two attempts (default 5 minutes)
+	MaxBackoff time.Duration
+	// Encode returns the payload posted for an event (default its encoding/json encoding)
+	Encode func(e internal.VehicleEvent) (payload []byte, err error)
+}
+
+// NewWebhookDefault is a function that returns a new instance of WebhookDefault
+func NewWebhookDefault(rp internal.WebhookRepository, cfg WebhookConfig) *WebhookDefault {
+	// default values
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 8
+	}
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 6
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 5 * time.Minute
+	}
+	if cfg.Encode == nil {
+		cfg.Encode = func(e internal.VehicleEvent) ([]byte, error) { return json.Marshal(e) }
+	}
+
+	s := &WebhookDefault{rp: rp, cfg: cfg, queue: make(chan int, cfg.Workers), queued: make(map[int]struct{})}
+
+	// client, checking every address dialed once resolved, redirects and proxies included
+	// - proxies are not used, the address checked would be the one of the proxy
+	dialer := &net.Dialer{Timeout: cfg.Timeout, Control: s.control}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	s.client = &http.Client{Timeout: cfg.Timeout, Transport: transport}
+
+	// workers and the scheduler of the deliveries left out of the queue
+	for i := 0; i < cfg.Workers; i++ {
+		go s.work()
+	}
+	go s.schedule()
+
+	return s
+}
+
+// WebhookDefault is a struct that represents the default service for webhooks
+// - the events are posted as JSON, signed with the secret of the webhook, see SignWebhookPayload
+// - a delivery is attempted until a 2xx response or MaxAttempts, waiting Backoff doubled after each failure,
+// then it is dead until it is redelivered
+// - the deliveries are made in the background by Workers goroutines, so receivers should order events by their id
+// - a delivery is stored as pending before it is queued, and queuing never blocks: the deliveries left out
+// while the queue is full are queued by the scheduler, which looks for the pending ones due every Backoff
+// - a delivery waiting for its next attempt holds no worker, it is queued again once the delay is over
+// - webhooks reaching internal addresses are refused, unless allowed by AllowedNetworks
+// - they are not resumed when the application restarts
+type WebhookDefault struct {
+	// rp is the repository of the webhooks and their deliveries
+	rp internal.WebhookRepository
+	// cfg is the configuration of the deliveries
+	cfg WebhookConfig
+	// client is the client the events are posted with
+	client *http.Client
+	// queue are the ids of the deliveries waiting for a worker
+	queue chan int
+	// mu guards queued
+	mu sync.Mutex
+	// queued are the ids of the deliveries queued or being attempted, so none is queued twice
+	queued map[int]struct{}
+}
+
+// Create is a method that creates a webhook, generating its secret when it has none
+func (s *WebhookDefault) Create(w *internal.Webhook) (err error) {
+	// validate webhook
+	if err = validateWebhook(w, s.cfg.AllowedNetworks); err != nil {
+		return wrapError("create webhook", err)
+	}
+
+	// secret
+	if w.Secret == "" {
+		key := make([]byte, 32)
+		if _, err = rand.Read(key); err != nil {
+			return wrapError("create webhook", err)
+		}
+		w.Secret = hex.EncodeToString(key)
+	}
+
+	w.CreatedAt = time.Now().UTC()
+	if err = s.rp.Create(w); err != nil {
+		return wrapError("create webhook", err)
+	}
+
+	return nil
+}
+
+// FindAll is a method that returns every webhook sorted by id
+func (s *WebhookDefault) FindAll() (w []internal.Webhook, err error) {
+	w, err = s.rp.FindAll()
+	if err != nil {
+		return nil, wrapError("find webhooks", err)
+	}
+
+	return w, nil
+}
+
+// FindById is a method that returns the webhook with the given id
+func (s *WebhookDefault) FindById(id int) (w internal.Webhook, err error) {
+	w, err = s.rp.FindById(id)
+	if err != nil {
+		return w, wrapError("find webhook", err)
+	}
+
+	return w, nil
+}
+
+// Delete is a method that removes the webhook with the given id, its pending deliveries are abandoned
+func (s *WebhookDefault) Delete(id int) (err error) {
+	if err = s.rp.Delete(id); err != nil {
+		return wrapError("delete webhook", err)
+	}
+
+	return nil
+}
+
+// FindDeliveries is a method that returns the delivery log of the webhook with the given id
+func (s *WebhookDefault) FindDeliveries(webhookId int) (d []internal.WebhookDelivery, err error) {
+	d, err = s.rp.FindDeliveries(webhookId)
+	if err != nil {
+		return nil, wrapError("find webhook deliveries", err)
+	}
+
+	return d, nil
+}
+
+// FindDeadLetters is a method that returns the deliveries whose attempts all failed
+func (s *WebhookDefault) FindDeadLetters() (d []internal.WebhookDelivery, err error) {
+	d, err = s.rp.FindDeliveriesByStatus(internal.WebhookDeliveryDead)
+	if err != nil {
+		return nil, wrapError("find dead letters", err)
+	}
+
+	return d, nil
+}
+
+// Redeliver is a method that moves a dead delivery back to pending and attempts it again right away
+// - it is given MaxAttempts new attempts
+// - the status is changed only while it is dead, so concurrent calls redeliver it once
+func (s *WebhookDefault) Redeliver(id int) (d internal.WebhookDelivery, err error) {
+	d, err = s.rp.FindDeliveryById(id)
+	if err != nil {
+		return d, wrapError("redeliver", err)
+	}
+
+	d.Status = internal.WebhookDeliveryPending
+	d.AttemptsLeft = s.cfg.MaxAttempts
+	d.NextAttemptAt = time.Now().UTC()
+	if err = s.rp.UpdateDeliveryFrom(d, internal.WebhookDeliveryDead); err != nil {
+		if errors.Is(err, internal.ErrWebhookDeliveryChangedRepo) {
+			err = internal.ErrWebhookDeliveryNotDeadService
+		}
+		return d, wrapError("redeliver", err)
+	}
+	s.enqueue(d.Id)
+
+	return d, nil
+}
+
+// Run is a method that delivers the events published to the broker to the webhooks, it never returns
+// - when the subscription falls behind it resumes after the last event dispatched
+func (s *WebhookDefault) Run(ev internal.VehicleEventBroker) {
+	last := -1
+	for {
+		sub := ev.Subscribe(last)
+		if last < 0 {
+			last = sub.Last
+		}
+		if sub.Missed {
+			log.Println("webhooks: some vehicle events were no longer buffered and are not delivered")
+			last = sub.Last
+		}
+
+		for _, e := range sub.Replay {
+			s.dispatch(e)
+			last = e.Id
+		}
+		for e := range sub.Events {
+			s.dispatch(e)
+			last = e.Id
+		}
+		sub.Cancel()
+	}
+}
+
+// dispatch is a method that creates a delivery of the event for each webhook accepting it and queues them
+func (s *WebhookDefault) dispatch(e internal.VehicleEvent) {
+	webhooks, err := s.rp.FindAll()
+	if err != nil {
+		log.Println(wrapError("dispatch webhooks", err))
+		return
+	}
+
+	var payload []byte
+	for _, w := range webhooks {
+		if !w.Accepts(e.Type) {
+			continue
+		}
+
+		// encoded once for every webhook
+		if payload == nil {
+			if payload, err = s.cfg.Encode(e); err != nil {
+				log.Println(wrapError("dispatch webhooks", err))
+				return
+			}
+		}
+
+		now := time.Now().UTC()
+		d := internal.WebhookDelivery{
+			WebhookId:     w.Id,
+			EventId:       e.Id,
+			EventType:     e.Type,
+			Payload:       payload,
+			Status:        internal.WebhookDeliveryPending,
+			AttemptsLeft:  s.cfg.MaxAttempts,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if err = s.rp.CreateDelivery(&d); err != nil {
+			// the webhook was deleted in between
+			continue
+		}
+		s.enqueue(d.Id)
+	}
+}
+
+// enqueue is a method that hands the delivery with the given id to the workers, without blocking
+// - a delivery already queued or being attempted is not queued again
+// - when the queue is full the delivery is left pending, the scheduler queues it later
+func (s *WebhookDefault) enqueue(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queued[id]; ok {
+		return
+	}
+	select {
+	case s.queue <- id:
+		s.queued[id] = struct{}{}
+	default:
+	}
+}
+
+// schedule is a method that queues the pending deliveries due every Backoff, it never returns
+func (s *WebhookDefault) schedule() {
+	ticker := time.NewTicker(s.cfg.Backoff)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		d, err := s.rp.FindDeliveriesByStatus(internal.WebhookDeliveryPending)
+		if err != nil {
+			log.Println(wrapError("schedule webhooks", err))
+			continue
+		}
+		now := time.Now()
+		for _, value := range d {
+			if !value.NextAttemptAt.After(now) {
+				s.enqueue(value.Id)
+			}
+		}
+	}
+}
+
+// work is a method that attempts the deliveries queued, queueing the next attempt after the backoff, it never returns
+func (s *WebhookDefault) work() {
+	for id := range s.queue {
+		next, retry := s.deliver(id)
+
+		s.mu.Lock()
+		delete(s.queued, id)
+		s.mu.Unlock()
+
+		if retry {
+			time.AfterFunc(time.Until(next), func() { s.enqueue(id) })
+		}
+	}
+}
+
+// deliver is a method that makes the next attempt of the delivery with the given id and records it
+// - it returns when the delivery is attempted again, if it is still pending
+// - it stops when the webhook or the delivery is deleted, or when the delivery is no longer pending
+func (s *WebhookDefault) deliver(id int) (next time.Time, retry bool) {
+	d, err := s.rp.FindDeliveryById(id)
+	if err != nil || d.Status != internal.WebhookDeliveryPending {
+		return
+	}
+	w, err := s.rp.FindById(d.WebhookId)
+	if err != nil {
+		return
+	}
+
+	a := s.attempt(w, d)
+	d.Attempts = append(d.Attempts, a)
+	d.AttemptsLeft--
+	switch {
+	case a.Error == "":
+		d.Status, d.NextAttemptAt = internal.WebhookDeliverySucceeded, time.Time{}
+	case d.AttemptsLeft <= 0:
+		d.Status, d.NextAttemptAt = internal.WebhookDeliveryDead, time.Time{}
+		log.Printf("webhooks: delivery %d to webhook %d is dead: %s", d.Id, d.WebhookId, a.Error)
+	default:
+		d.NextAttemptAt = time.Now().UTC().Add(s.backoff(s.cfg.MaxAttempts - d.AttemptsLeft))
+	}
+	if err = s.rp.UpdateDelivery(d); err != nil || d.Status != internal.WebhookDeliveryPending {
+		return
+	}
+	return d.NextAttemptAt, true
+}
+
+// attempt is a method that posts the payload of the delivery to the webhook once
+func (s *WebhookDefault) attempt(w internal.Webhook, d internal.WebhookDelivery) (a internal.WebhookAttempt) {
+	a.Time = time.Now().UTC()
+	defer func() { a.Duration = time.Since(a.Time) }()
+
+	// request
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(d.Payload))
+	if err != nil {
+		a.Error = err.Error()
+		return
+	}
+	timestamp := strconv.FormatInt(a.Time.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookHeaderDelivery, strconv.Itoa(d.Id))
+	req.Header.Set(WebhookHeaderEvent, d.EventType)
+	req.Header.Set(WebhookHeaderTimestamp, timestamp)
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(w.Secret, timestamp, d.Payload))
+
+	// response, only the status matters
+	res, err := s.client.Do(req)
+	if err != nil {
+		a.Error = err.Error()
+		return
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	a.StatusCode = res.StatusCode
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		a.Error = fmt.Sprintf("unexpected status %d", res.StatusCode)
+	}
+	return
+}
+
+// backoff is a method that returns the delay after the given failed attempt, Backoff doubled for each previous one
+func (s *WebhookDefault) backoff(attempt int) (delay time.Duration) {
+	delay = s.cfg.Backoff
+	for i := 1; i < attempt && delay < s.cfg.MaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, s.cfg.MaxBackoff)
+}
+
+// SignWebhookPayload is a function that returns the signature of a request posted to a webhook
+// - "sha256=" followed by the hex HMAC-SHA256, keyed by the secret, of the timestamp header, a dot and the body
+// - receivers recompute it to check the request comes from us and reject old timestamps to prevent replays
+func SignWebhookPayload(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// control is a method that refuses the connections to internal addresses not allowed, see WebhookConfig.AllowedNetworks
+// - it is called once the host is resolved, so names resolving to internal addresses are refused too
+func (s *WebhookDefault) control(network, address string, c syscall.RawConn) (err error) {
+	addr, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return
+	}
+	if !webhookAddrAllowed(addr.Addr(), s.cfg.AllowedNetworks) {
+		return fmt.Errorf("webhooks: the internal address %s is not allowed", addr.Addr())
+	}
+	return
+}
+
+// webhookAddrAllowed is a function that returns whether a webhook may reach the address
+// - public addresses are, internal ones only when in one of the allowed networks
+func webhookAddrAllowed(addr netip.Addr, allowed []netip.Prefix) bool {
+	addr = addr.Unmap()
+	internalAddr := addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified()
+	if !internalAddr {
+		return true
+	}
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateWebhook is a function that checks the URL and the event types of the webhook, removing repeated types
+// - hosts that are internal addresses, or localhost, are refused unless in the allowed networks
+// - other hosts are checked once resolved, when the deliveries are attempted
+func validateWebhook(w *internal.Webhook, allowed []netip.Prefix) (err error) {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", internal.ErrWebhookInvalidService)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		host = "127.0.0.1"
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !webhookAddrAllowed(addr, allowed) {
+		return fmt.Errorf("%w: url must not reach the internal address %s", internal.ErrWebhookInvalidService, addr)
+	}
+
+	var events []string
+	for _, event := range w.Events {
+		if !slices.Contains(webhookEventTypes, event) {
+			return fmt.Errorf("%w: unknown event %q, expected one of %q", internal.ErrWebhookInvalidService, event, webhookEventTypes)
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	w.Events = events
+
+	return nil
+}
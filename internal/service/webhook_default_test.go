@@ -0,0 +1,342 @@
+package service
+
+import (
+	"app/internal"
+	"app/internal/repository"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testReceiver is a struct that represents a webhook receiver recording the requests posted to it
+type testReceiver struct {
+	// mu guards requests and bodies
+	mu sync.Mutex
+	// requests are the requests received, in order
+	requests []*http.Request
+	// bodies are the bodies of the requests received, in order
+	bodies [][]byte
+	// status returns the status of the response to the request with the given number, starting at 1
+	status func(n int) int
+}
+
+// ServeHTTP is a method that records the request and responds with its status
+func (rc *testReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	n := len(rc.requests)
+	rc.mu.Unlock()
+
+	w.WriteHeader(rc.status(n))
+}
+
+// newTestWebhooks is a function that returns a webhook service allowed to reach the loopback receivers, retrying right away
+func newTestWebhooks(t *testing.T, maxAttempts int) (s *WebhookDefault, rp *repository.WebhookMap) {
+	t.Helper()
+
+	rp = repository.NewWebhookMap(0)
+	s = NewWebhookDefault(rp, WebhookConfig{
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")},
+		MaxAttempts:     maxAttempts,
+		Backoff:         time.Millisecond,
+		MaxBackoff:      time.Millisecond,
+	})
+	return
+}
+
+// newTestWebhook is a function that creates a webhook posting to the URL
+func newTestWebhook(t *testing.T, s *WebhookDefault, url string) internal.Webhook {
+	t.Helper()
+
+	w := internal.Webhook{URL: url, Secret: "secret"}
+	if err := s.Create(&w); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// waitDelivery is a function that waits until the delivery of the event to the webhook is no longer pending
+func waitDelivery(t *testing.T, rp *repository.WebhookMap, webhookId int, eventId int) (d internal.WebhookDelivery) {
+	t.Helper()
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		deliveries, err := rp.FindDeliveries(webhookId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d = range deliveries {
+			if d.EventId == eventId && d.Status != internal.WebhookDeliveryPending {
+				return
+			}
+		}
+	}
+	t.Fatalf("delivery of event %d to webhook %d still pending", eventId, webhookId)
+	return
+}
+
+func TestWebhookDefault_Signature(t *testing.T) {
+	rc := &testReceiver{status: func(n int) int { return http.StatusNoContent }}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, rp := newTestWebhooks(t, 1)
+	w := newTestWebhook(t, s, srv.URL)
+
+	s.dispatch(internal.VehicleEvent{Id: 7, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+	d := waitDelivery(t, rp, w.Id, 7)
+	if d.Status != internal.WebhookDeliverySucceeded {
+		t.Fatalf("got %+v, want succeeded", d)
+	}
+
+	// the headers identify the delivery, the signature covers the timestamp and the body
+	r, body := rc.requests[0], rc.bodies[0]
+	if r.Header.Get(WebhookHeaderDelivery) != strconv.Itoa(d.Id) || r.Header.Get(WebhookHeaderEvent) != internal.VehicleEventCreated {
+		t.Errorf("got headers %v", r.Header)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(r.Header.Get(WebhookHeaderTimestamp) + "." + string(body)))
+	if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); r.Header.Get(WebhookHeaderSignature) != want {
+		t.Errorf("got signature %q, want %q", r.Header.Get(WebhookHeaderSignature), want)
+	}
+	if string(body) != string(d.Payload) {
+		t.Errorf("got body %s, want %s", body, d.Payload)
+	}
+}
+
+func TestWebhookDefault_Retries(t *testing.T) {
+	// the first two attempts fail
+	rc := &testReceiver{status: func(n int) int {
+		if n <= 2 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, rp := newTestWebhooks(t, 3)
+	w := newTestWebhook(t, s, srv.URL)
+
+	s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventUpdated, Vehicle: newTestVehicle(1)})
+	d := waitDelivery(t, rp, w.Id, 1)
+
+	// every attempt is in the delivery log
+	if d.Status != internal.WebhookDeliverySucceeded || len(d.Attempts) != 3 {
+		t.Fatalf("got %+v, want succeeded after 3 attempts", d)
+	}
+	for i, want := range []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusOK} {
+		if a := d.Attempts[i]; a.StatusCode != want || (a.Error == "") != (want == http.StatusOK) {
+			t.Errorf("got attempt %d %+v, want status %d", i+1, a, want)
+		}
+	}
+
+	// the same delivery on every attempt
+	for _, r := range rc.requests {
+		if r.Header.Get(WebhookHeaderDelivery) != strconv.Itoa(d.Id) {
+			t.Errorf("got delivery %s, want %d", r.Header.Get(WebhookHeaderDelivery), d.Id)
+		}
+	}
+}
+
+func TestWebhookDefault_DeadLetters(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	rc := &testReceiver{status: func(n int) int {
+		if failing.Load() {
+			return http.StatusInternalServerError
+		}
+		return http.StatusOK
+	}}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, rp := newTestWebhooks(t, 2)
+	w := newTestWebhook(t, s, srv.URL)
+
+	// dead after every attempt failed
+	s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventDeleted, Vehicle: newTestVehicle(1)})
+	d := waitDelivery(t, rp, w.Id, 1)
+	if d.Status != internal.WebhookDeliveryDead || len(d.Attempts) != 2 {
+		t.Fatalf("got %+v, want dead after 2 attempts", d)
+	}
+	dead, err := s.FindDeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 || dead[0].Id != d.Id {
+		t.Errorf("got dead letters %+v, want delivery %d", dead, d.Id)
+	}
+
+	// redelivered once the receiver is back
+	failing.Store(false)
+	if _, err = s.Redeliver(d.Id); err != nil {
+		t.Fatal(err)
+	}
+	d = waitDelivery(t, rp, w.Id, 1)
+	if d.Status != internal.WebhookDeliverySucceeded || len(d.Attempts) != 3 {
+		t.Errorf("got %+v, want succeeded on the third attempt", d)
+	}
+	if _, err = s.Redeliver(d.Id); !errors.Is(err, internal.ErrWebhookDeliveryNotDeadService) {
+		t.Errorf("got %v, want ErrWebhookDeliveryNotDeadService", err)
+	}
+}
+
+func TestWebhookDefault_InternalAddressesRefused(t *testing.T) {
+	rc := &testReceiver{status: func(n int) int { return http.StatusOK }}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	rp := repository.NewWebhookMap(0)
+	s := NewWebhookDefault(rp, WebhookConfig{MaxAttempts: 1})
+
+	// internal hosts are refused when created
+	for _, url := range []string{"http://localhost:8080/", "http://127.0.0.1/", "http://[::1]/", "http://10.0.0.1/", "http://169.254.169.254/", "http://0.0.0.0/"} {
+		w := internal.Webhook{URL: url}
+		if err := s.Create(&w); !errors.Is(err, internal.ErrWebhookInvalidService) {
+			t.Errorf("got %v for %s, want ErrWebhookInvalidService", err, url)
+		}
+	}
+
+	// the addresses are checked again when dialed, e.g. for names resolving to internal addresses
+	w := internal.Webhook{URL: srv.URL}
+	if err := rp.Create(&w); err != nil {
+		t.Fatal(err)
+	}
+	s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+	d := waitDelivery(t, rp, w.Id, 1)
+	if d.Status != internal.WebhookDeliveryDead || !strings.Contains(d.Attempts[0].Error, "not allowed") {
+		t.Errorf("got %+v, want dead refused", d)
+	}
+	if len(rc.requests) != 0 {
+		t.Errorf("got %d requests received, want 0", len(rc.requests))
+	}
+}
+
+func TestWebhookDefault_WorkersBounded(t *testing.T) {
+	// the receiver holds the requests a while, counting them
+	var mu sync.Mutex
+	var inFlight, peak int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}))
+	defer srv.Close()
+	rp := repository.NewWebhookMap(0)
+	s := NewWebhookDefault(rp, WebhookConfig{
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Workers:         2,
+		Backoff:         10 * time.Millisecond,
+	})
+	webhooks := make([]internal.Webhook, 10)
+	for i := range webhooks {
+		webhooks[i] = newTestWebhook(t, s, srv.URL)
+	}
+
+	s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+	for _, w := range webhooks {
+		if d := waitDelivery(t, rp, w.Id, 1); d.Status != internal.WebhookDeliverySucceeded {
+			t.Errorf("got %+v, want succeeded", d)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if peak > 2 {
+		t.Errorf("got %d deliveries at once, want at most 2", peak)
+	}
+}
+
+func TestWebhookDefault_DispatchDoesNotBlock(t *testing.T) {
+	// the receiver holds every request until released
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { <-release }))
+	defer srv.Close()
+	rp := repository.NewWebhookMap(0)
+	s := NewWebhookDefault(rp, WebhookConfig{
+		AllowedNetworks: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		Workers:         1,
+		Backoff:         10 * time.Millisecond,
+	})
+	webhooks := make([]internal.Webhook, 10)
+	for i := range webhooks {
+		webhooks[i] = newTestWebhook(t, s, srv.URL)
+	}
+
+	// every delivery is stored while the worker is busy
+	done := make(chan struct{})
+	go func() {
+		s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+		s.dispatch(internal.VehicleEvent{Id: 2, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked while the worker was busy")
+	}
+	pending, _ := rp.FindDeliveriesByStatus(internal.WebhookDeliveryPending)
+	if len(pending) != 2*len(webhooks) {
+		t.Errorf("got %d pending deliveries, want %d", len(pending), 2*len(webhooks))
+	}
+
+	// the ones left out of the queue are delivered once the worker is free
+	close(release)
+	for _, w := range webhooks {
+		for _, eventId := range []int{1, 2} {
+			if d := waitDelivery(t, rp, w.Id, eventId); d.Status != internal.WebhookDeliverySucceeded || len(d.Attempts) != 1 {
+				t.Errorf("got %+v, want succeeded on the first attempt", d)
+			}
+		}
+	}
+}
+
+func TestWebhookDefault_ConcurrentRedeliver(t *testing.T) {
+	rc := &testReceiver{status: func(n int) int { return http.StatusInternalServerError }}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s, rp := newTestWebhooks(t, 1)
+	w := newTestWebhook(t, s, srv.URL)
+	s.dispatch(internal.VehicleEvent{Id: 1, Type: internal.VehicleEventCreated, Vehicle: newTestVehicle(1)})
+	d := waitDelivery(t, rp, w.Id, 1)
+
+	// only one of the calls redelivers it
+	var redelivered atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.Redeliver(d.Id)
+			switch {
+			case err == nil:
+				redelivered.Add(1)
+			case !errors.Is(err, internal.ErrWebhookDeliveryNotDeadService):
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if redelivered.Load() != 1 {
+		t.Errorf("got %d redeliveries, want 1", redelivered.Load())
+	}
+	if d = waitDelivery(t, rp, w.Id, 1); len(d.Attempts) != 2 {
+		t.Errorf("got %d attempts, want 2", len(d.Attempts))
+	}
+}
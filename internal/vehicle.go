@@ -1,5 +1,11 @@
 package internal
 
+import (
+	"strings"
+	"time"
+	"unicode"
+)
+
 // Dimensions is a struct that represents a dimension in 3d
 type Dimensions struct {
 	// Height is the height of the dimension
@@ -40,7 +46,29 @@ type VehicleAttributes struct {
 type Vehicle struct {
 	// Id is the unique identifier of the vehicle
 	Id int
+	// Version is the revision of the vehicle, set to 1 when it is created and incremented on every update
+	Version int
+	// UpdatedAt is the time the vehicle was last created or updated, zero when unknown
+	UpdatedAt time.Time
+	// DeletedAt is the time the vehicle was moved to the trash, zero when it is not deleted
+	DeletedAt time.Time
 
 	// VehicleAttribue is the attributes of a vehicle
 	VehicleAttributes
 }
+
+// NormalizeRegistration is a function that returns the form registrations are compared in
+// - letters are upper-cased and anything but letters and digits (spaces, hyphens, dots) is dropped,
+// so "ab-123 c" and "AB123C" are the same registration
+func NormalizeRegistration(registration string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r):
+			return unicode.ToUpper(r)
+		case unicode.IsDigit(r):
+			return r
+		default:
+			return -1
+		}
+	}, registration)
+}
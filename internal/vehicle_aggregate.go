@@ -0,0 +1,100 @@
+package internal
+
+// MetricAggregate is a struct that represents the running aggregates of a numeric metric
+type MetricAggregate struct {
+	// Count is the number of values aggregated
+	Count int
+	// Sum is the sum of the values
+	Sum float64
+	// Min is the smallest value
+	Min float64
+	// Max is the largest value
+	Max float64
+}
+
+// Avg is a method that returns the average of the values, 0 when there are none
+func (a MetricAggregate) Avg() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// BrandAggregate is a struct that represents the aggregates of the vehicles of a brand
+type BrandAggregate struct {
+	// Brand is the brand of the vehicles
+	Brand string
+	// Count is the number of vehicles of the brand
+	Count int
+	// MaxSpeed are the aggregates of the maximum speed
+	MaxSpeed MetricAggregate
+	// Capacity are the aggregates of the capacity of people
+	Capacity MetricAggregate
+	// Weight are the aggregates of the weight
+	Weight MetricAggregate
+}
+
+// VehicleStatistics is a struct that represents descriptive statistics of a numeric field over a set of vehicles
+type VehicleStatistics struct {
+	// Count is the number of vehicles
+	Count int
+	// Min is the smallest value
+	Min float64
+	// Max is the largest value
+	Max float64
+	// Mean is the arithmetic mean
+	Mean float64
+	// Median is the 50th percentile
+	Median float64
+	// StdDev is the population standard deviation
+	StdDev float64
+	// Percentiles maps each requested percentile (0 to 100) to its value
+	Percentiles map[float64]float64
+}
+
+// AggregateFunction is the function applied by a metric to the vehicles of a group
+type AggregateFunction string
+
+const (
+	// AggregateCount counts the vehicles
+	AggregateCount AggregateFunction = "count"
+	// AggregateSum sums the field
+	AggregateSum AggregateFunction = "sum"
+	// AggregateAvg averages the field
+	AggregateAvg AggregateFunction = "avg"
+	// AggregateMin returns the smallest value of the field
+	AggregateMin AggregateFunction = "min"
+	// AggregateMax returns the largest value of the field
+	AggregateMax AggregateFunction = "max"
+)
+
+// VehicleMetric is a struct that represents a metric computed for each group of vehicles
+type VehicleMetric struct {
+	// Function is the aggregate function
+	Function AggregateFunction
+	// Field is the numeric field aggregated, empty for count
+	Field string
+}
+
+// String is a method that returns the metric as written in a query, e.g. avg(max_speed)
+func (m VehicleMetric) String() string {
+	return string(m.Function) + "(" + m.Field + ")"
+}
+
+// VehicleAggregation is a struct that represents a group by aggregation over vehicles
+type VehicleAggregation struct {
+	// Filter selects the vehicles aggregated
+	Filter VehicleFilter
+	// GroupBy are the fields the vehicles are grouped by, none aggregates every vehicle in a single group
+	GroupBy []string
+	// Metrics are the metrics computed for each group
+	Metrics []VehicleMetric
+}
+
+// VehicleAggregateRow is a struct that represents the result of an aggregation for a group
+type VehicleAggregateRow struct {
+	// Group are the values of the GroupBy fields, in the same order
+	Group []any
+	// Values are the values of the Metrics, in the same order
+	Values []float64
+}
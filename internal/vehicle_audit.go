@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+// Operations recorded in the audit trail
+const (
+	AuditOpCreate  = "create"
+	AuditOpUpdate  = "update"
+	AuditOpDelete  = "delete"
+	AuditOpRestore = "restore"
+	AuditOpPurge   = "purge"
+)
+
+const (
+	// AnonymousActor is the actor of the changes made without one
+	AnonymousActor = "anonymous"
+	// SystemActor is the actor of the changes made by the application itself, e.g. purging the trash
+	SystemActor = "system"
+)
+
+// AuditChange is a struct that represents the change of a field of a vehicle
+type AuditChange struct {
+	// Field is the name of the field, see VehicleFields
+	Field string
+	// Before is the value before the operation, nil when the vehicle did not exist
+	Before any
+	// After is the value after the operation, nil when the vehicle was deleted
+	After any
+}
+
+// AuditEntry is a struct that represents an operation on a vehicle recorded in the audit trail
+type AuditEntry struct {
+	// Id is the position of the entry in the audit trail, assigned when it is appended
+	Id int
+	// Time is when the operation was done
+	Time time.Time
+	// Actor is who did the operation
+	Actor string
+	// Operation is one of the AuditOp* operations
+	Operation string
+	// VehicleId is the id of the vehicle
+	VehicleId int
+	// Version is the version of the vehicle after the operation, 0 when it was purged
+	Version int
+	// Changes are the fields changed by the operation
+	Changes []AuditChange
+}
+
+// AuditQuery is a struct that represents the criteria the audit entries are looked up by, zero values match anything
+type AuditQuery struct {
+	// VehicleId is the id of the vehicle
+	VehicleId int
+	// Actor is who did the operations
+	Actor string
+	// From is the earliest time of the operations, inclusive
+	From time.Time
+	// To is the latest time of the operations, exclusive
+	To time.Time
+	// Limit is the maximum number of entries, the latest ones are kept
+	Limit int
+}
+
+// Match is a method that returns whether the entry matches the query, ignoring its limit
+func (q AuditQuery) Match(e AuditEntry) bool {
+	switch {
+	case q.VehicleId != 0 && e.VehicleId != q.VehicleId:
+		return false
+	case q.Actor != "" && e.Actor != q.Actor:
+		return false
+	case !q.From.IsZero() && e.Time.Before(q.From):
+		return false
+	case !q.To.IsZero() && !e.Time.Before(q.To):
+		return false
+	}
+	return true
+}
+
+// AuditRepository is an interface that represents the storage of the audit trail
+type AuditRepository interface {
+	// Append is a method that stores the entries at the end of the audit trail, setting their ids
+	Append(e []AuditEntry) (err error)
+	// Find is a method that returns the entries matching the query in the order they were appended
+	Find(q AuditQuery) (e []AuditEntry, err error)
+}
+
+// DiffVehicles is a function that returns the changes of the fields of VehicleFields from before to after
+// - a nil vehicle is one that does not exist, so every field of the other one is a change
+// - the id and the fields derived from others are left out
+func DiffVehicles(before, after *Vehicle) (changes []AuditChange) {
+	for _, field := range VehicleFields {
+		if field.Name == "id" || field.Name == "decade" {
+			continue
+		}
+
+		var b, a any
+		if before != nil {
+			b = field.Value(*before)
+		}
+		if after != nil {
+			a = field.Value(*after)
+		}
+		if b != a {
+			changes = append(changes, AuditChange{Field: field.Name, Before: b, After: a})
+		}
+	}
+	return
+}
+
+// actorKey is the key of the actor in a context
+type actorKey struct{}
+
+// WithActor is a function that returns a copy of the context carrying the actor
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom is a function that returns the actor carried by the context, AnonymousActor when there is none
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+	return AnonymousActor
+}
@@ -0,0 +1,47 @@
+package internal
+
+import "time"
+
+// Types of the events published when vehicles change
+// - a restored vehicle is published as created, a purged one is not published as it was already deleted
+const (
+	VehicleEventCreated = "created"
+	VehicleEventUpdated = "updated"
+	VehicleEventDeleted = "deleted"
+)
+
+// VehicleEvent is a struct that represents a change of a vehicle applied by the service
+type VehicleEvent struct {
+	// Id is the position of the event in the feed, assigned when it is published and increasing by one
+	Id int
+	// Type is one of the VehicleEvent* types
+	Type string
+	// Time is when the change was applied
+	Time time.Time
+	// Vehicle is the vehicle after the change, or as it was when deleted
+	Vehicle Vehicle
+}
+
+// VehicleSubscription is a struct that represents a subscriber of the vehicle events
+type VehicleSubscription struct {
+	// Replay are the buffered events published after the id subscribed from, in order, none when some were missed
+	Replay []VehicleEvent
+	// Missed is whether some events after the id subscribed from are no longer buffered, so the subscriber must resync up to Last
+	Missed bool
+	// Last is the id of the last event published when subscribing, 0 when there is none
+	Last int
+	// Events receives the events published after subscribing
+	// - it is closed when the subscription is cancelled or when the subscriber falls too far behind
+	Events <-chan VehicleEvent
+	// Cancel stops the subscription, it must be called once the subscriber is done
+	Cancel func()
+}
+
+// VehicleEventBroker is an interface that represents the feed of the vehicle events
+type VehicleEventBroker interface {
+	// Publish is a method that assigns the next ids to the events and delivers them to the subscribers
+	Publish(e ...VehicleEvent)
+	// Subscribe is a method that returns a new subscription, replaying the buffered events after the given id
+	// - a negative id subscribes to the events published from now on only
+	Subscribe(after int) (s VehicleSubscription)
+}
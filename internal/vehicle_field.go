@@ -0,0 +1,82 @@
+package internal
+
+import "cmp"
+
+// VehicleFieldKind is the kind of value held by a vehicle field
+type VehicleFieldKind int
+
+const (
+	// VehicleFieldString is a text field
+	VehicleFieldString VehicleFieldKind = iota
+	// VehicleFieldInt is an integer field
+	VehicleFieldInt
+	// VehicleFieldFloat is a decimal field
+	VehicleFieldFloat
+)
+
+// VehicleField is a struct that represents a field of a vehicle that can be queried
+type VehicleField struct {
+	// Name is the name of the field, the same used in the JSON representation of a vehicle
+	Name string
+	// Kind is the kind of value held by the field
+	Kind VehicleFieldKind
+	// value returns the value of the field for a vehicle (string, int or float64 depending on Kind)
+	value func(v Vehicle) any
+}
+
+// Value is a method that returns the value of the field for the given vehicle
+// - the dynamic type is string, int or float64 depending on the kind of the field
+func (f VehicleField) Value(v Vehicle) any {
+	return f.value(v)
+}
+
+// VehicleFields is the list of the queryable fields of a vehicle
+var VehicleFields = []VehicleField{
+	{Name: "id", Kind: VehicleFieldInt, value: func(v Vehicle) any { return v.Id }},
+	{Name: "brand", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.Brand }},
+	{Name: "model", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.Model }},
+	{Name: "registration", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.Registration }},
+	{Name: "color", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.Color }},
+	{Name: "year", Kind: VehicleFieldInt, value: func(v Vehicle) any { return v.FabricationYear }},
+	{Name: "passengers", Kind: VehicleFieldInt, value: func(v Vehicle) any { return v.Capacity }},
+	{Name: "max_speed", Kind: VehicleFieldFloat, value: func(v Vehicle) any { return v.MaxSpeed }},
+	{Name: "fuel_type", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.FuelType }},
+	{Name: "transmission", Kind: VehicleFieldString, value: func(v Vehicle) any { return v.Transmission }},
+	{Name: "weight", Kind: VehicleFieldFloat, value: func(v Vehicle) any { return v.Weight }},
+	{Name: "height", Kind: VehicleFieldFloat, value: func(v Vehicle) any { return v.Height }},
+	{Name: "length", Kind: VehicleFieldFloat, value: func(v Vehicle) any { return v.Length }},
+	{Name: "width", Kind: VehicleFieldFloat, value: func(v Vehicle) any { return v.Width }},
+	// decade is derived from the fabrication year, e.g. 1995 -> 1990
+	{Name: "decade", Kind: VehicleFieldInt, value: func(v Vehicle) any { return v.FabricationYear / 10 * 10 }},
+}
+
+// LookupVehicleField is a function that returns the vehicle field with the given name
+func LookupVehicleField(name string) (f VehicleField, ok bool) {
+	for _, field := range VehicleFields {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return
+}
+
+// CompareVehicleFieldValues is a function that compares two values of the same vehicle field
+// - it returns -1, 0 or 1 when a is less than, equal to or greater than b
+// - values of different types compare as equal
+func CompareVehicleFieldValues(a, b any) int {
+	switch a := a.(type) {
+	case string:
+		if b, ok := b.(string); ok {
+			return cmp.Compare(a, b)
+		}
+	case int:
+		if b, ok := b.(int); ok {
+			return cmp.Compare(a, b)
+		}
+	case float64:
+		if b, ok := b.(float64); ok {
+			return cmp.Compare(a, b)
+		}
+	}
+	return 0
+}
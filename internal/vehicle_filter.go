@@ -0,0 +1,64 @@
+package internal
+
+// FilterOperator is the operator used by a filter condition to compare a field with its values
+type FilterOperator string
+
+const (
+	// FilterEq matches when the field is equal to the value
+	FilterEq FilterOperator = "eq"
+	// FilterNe matches when the field is not equal to the value
+	FilterNe FilterOperator = "ne"
+	// FilterLt matches when the field is less than the value
+	FilterLt FilterOperator = "lt"
+	// FilterLte matches when the field is less than or equal to the value
+	FilterLte FilterOperator = "lte"
+	// FilterGt matches when the field is greater than the value
+	FilterGt FilterOperator = "gt"
+	// FilterGte matches when the field is greater than or equal to the value
+	FilterGte FilterOperator = "gte"
+	// FilterIn matches when the field is equal to any of the values
+	FilterIn FilterOperator = "in"
+	// FilterContains matches when the text field contains the value, ignoring case
+	FilterContains FilterOperator = "contains"
+	// FilterPrefix matches when the text field starts with the value, ignoring case
+	FilterPrefix FilterOperator = "prefix"
+)
+
+// FilterLogic is the logical operator used by a filter group to combine its filters
+type FilterLogic string
+
+const (
+	// FilterAnd matches when every filter of the group matches
+	FilterAnd FilterLogic = "and"
+	// FilterOr matches when any filter of the group matches
+	FilterOr FilterLogic = "or"
+)
+
+// VehicleFilter is a struct that represents a filter expression over vehicles
+// - a condition (Logic empty) compares Field with Values using Operator
+// - a group (Logic set) combines Filters with Logic
+// - the zero value matches every vehicle
+type VehicleFilter struct {
+	// Field is the name of the field compared by a condition (see VehicleFields)
+	Field string
+	// Operator is the operator of a condition
+	Operator FilterOperator
+	// Values are the values compared by a condition
+	// - once validated by the service their dynamic type matches the kind of the field (string, int or float64)
+	Values []any
+
+	// Logic is the logical operator of a group
+	Logic FilterLogic
+	// Filters are the filters combined by a group
+	Filters []VehicleFilter
+}
+
+// IsGroup is a method that returns whether the filter is a group of filters
+func (f VehicleFilter) IsGroup() bool {
+	return f.Logic != ""
+}
+
+// IsZero is a method that returns whether the filter is empty and matches every vehicle
+func (f VehicleFilter) IsZero() bool {
+	return f.Logic == "" && f.Field == ""
+}
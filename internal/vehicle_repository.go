@@ -2,30 +2,132 @@ package internal
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 var (
-	ErrVehicleAlreadyExistsRepo    = errors.New("Vehicle ID already present")
-	ErrNoVehiclesByColorYearRepo   = errors.New("No vehicles found with the given color and year")
-	ErrNoVehiclesByBrandYearsRepo  = errors.New("No vehicles found with the given brand and years")
-	ErrNoVehiclesByBrandRepo       = errors.New("No vehicles found with the given brand")
-	ErrNoVehiclesByWeightRangeRepo = errors.New("No vehicles found with the given weight range")
-	ErrNoVehiclesByDimensionsRepo  = errors.New("No vehicles found with the given dimensions")
-	ErrVehicleNotFoundRepo         = errors.New("Vehicle with the provided ID not found")
+	ErrVehicleAlreadyExistsRepo      = errors.New("Vehicle ID already present")
+	ErrNoVehiclesByBrandRepo         = errors.New("No vehicles found with the given brand")
+	ErrVehicleNotFoundRepo           = errors.New("Vehicle with the provided ID not found")
+	ErrNoVehicleByRegistrationRepo   = errors.New("No vehicle found with the given registration")
+	ErrVehicleStorageRepo            = errors.New("Vehicles could not be persisted")
+	ErrVehicleVersionConflictRepo    = errors.New("Vehicle was modified by another request")
+	ErrVehicleRegistrationExistsRepo = errors.New("Vehicle registration already present")
+	ErrVehicleNotDeletedRepo         = errors.New("Vehicle with the provided ID is not deleted")
+	ErrVehicleHistoryUnavailableRepo = errors.New("Vehicle history not available at the given time")
 )
 
 // VehicleRepository is an interface that represents a vehicle repository
+// - deleted vehicles are kept in a trash, every method but FindDeleted, Restore and Purge ignores them,
+// except that their ids and registrations stay taken until they are purged, so they can always be restored
+// - every version written is kept in a history, so past states can be read until the history is pruned
 type VehicleRepository interface {
 	// FindAll is a method that returns a map of all vehicles
 	FindAll() (v map[int]Vehicle, err error)
-	Create(v Vehicle) (err error)
-	GetByColorAndYear(color string, year int) (v map[int]Vehicle, err error)
-	GetByBrandBetweenYears(brand string, yearStart int, yearEnd int) (v map[int]Vehicle, err error)
+	// FindById is a method that returns the vehicle with the given id
+	FindById(id int) (v Vehicle, err error)
+	// FindByRegistration is a method that returns the vehicle with the given registration, compared normalized
+	// - registrations stored before they were unique may be shared, the vehicle with the lowest id is returned
+	FindByRegistration(registration string) (v Vehicle, err error)
+	// Create is a method that stores a new vehicle
+	// - when v.Id is 0 the repository assigns the next id of its sequence, otherwise the id is kept (imports)
+	// - on success v.Id, v.Version and v.UpdatedAt hold the stored values
+	// - a registration already held by another vehicle returns a *VehicleRegistrationConflictError
+	Create(v *Vehicle) (err error)
 	GetSpeedAvgByBrand(brand string) (speedAvg float64, err error)
-	CreateMultiple(v map[int]Vehicle) (err error)
-	ListByWeightRange(weightMin, weightMax float64) (v map[int]Vehicle, err error)
-	ListByDimensions(minLength, maxLength, minWidth, maxWidth float64) (v map[int]Vehicle, err error)
-	Update(v *Vehicle) (err error)
-	Delete(id int) (err error)
+	// CreateMultiple is a method that creates all the vehicles or none of them
+	// - it returns a *VehicleConflictError listing every id that prevented the creation
+	// - vehicles with id 0 are assigned the next ids of the sequence, in order
+	// - it returns a *VehicleRegistrationConflictError listing every registration that prevented the creation
+	CreateMultiple(v []Vehicle) (err error)
+	// Update is a method that replaces the vehicle with the same id and increments its version
+	// - when v.Version is not 0 it must match the stored version, otherwise ErrVehicleVersionConflictRepo is returned
+	// - on success v.Version and v.UpdatedAt hold the new version and modification time
+	// - changing the registration to one held by another vehicle returns a *VehicleRegistrationConflictError
+	// - previous is the vehicle as it was right before this update, read within the same write
+	Update(v *Vehicle) (previous Vehicle, err error)
+	// Delete is a method that moves the vehicle with the given id to the trash, setting DeletedAt and incrementing its version
+	// - when version is not 0 it must match the stored version, otherwise ErrVehicleVersionConflictRepo is returned
+	// - v is the vehicle as it is in the trash, its attributes are the ones it had right before the delete
+	Delete(id int, version int) (v Vehicle, err error)
+	// FindDeleted is a method that returns the vehicles in the trash
+	FindDeleted() (v map[int]Vehicle, err error)
+	// Restore is a method that moves the vehicle with the given id out of the trash, incrementing its version
+	// - ErrVehicleNotDeletedRepo is returned when the vehicle is not in the trash
+	Restore(id int) (v Vehicle, err error)
+	// Purge is a method that permanently removes the vehicles deleted before the given time, returning their sorted ids
+	// - their history is kept, it is only dropped by PruneHistory
+	Purge(before time.Time) (ids []int, err error)
+	// FindAllAsOf is a method that returns the vehicles as they were at the given time, keyed by id
+	// - vehicles deleted or purged since then are included, the ones already deleted at that time are not
+	// - ErrVehicleHistoryUnavailableRepo is returned when the history does not reach back to t
+	FindAllAsOf(t time.Time) (v map[int]Vehicle, err error)
+	// FindByIdAsOf is a method that returns the vehicle with the given id as it was at the given time
+	// - ErrVehicleNotFoundRepo is returned when it did not exist or was deleted at that time
+	// - ErrVehicleHistoryUnavailableRepo is returned when the history does not reach back to t
+	FindByIdAsOf(id int, t time.Time) (v Vehicle, err error)
+	// PruneHistory is a method that drops the versions superseded before the given time, returning how many
+	// - afterwards the history only reaches back to before
+	PruneHistory(before time.Time) (n int, err error)
 	GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error)
+	// FindByFilter is a method that returns the vehicles matching the filter
+	// - the filter is expected to be validated, no matches is not an error
+	FindByFilter(f VehicleFilter) (v map[int]Vehicle, err error)
+	// GetBrandAggregates is a method that returns the aggregates of every brand, keyed by brand
+	GetBrandAggregates() (a map[string]BrandAggregate, err error)
+	// Aggregate is a method that groups the vehicles and computes the metrics of each group
+	// - the aggregation is expected to be validated, rows are returned in no particular order
+	Aggregate(a VehicleAggregation) (rows []VehicleAggregateRow, err error)
+}
+
+// VehicleConflictError is an error that lists the ids that prevented a batch of vehicles from being created
+type VehicleConflictError struct {
+	// Existing are the ids of the batch that are already present in the repository
+	Existing []int
+	// Duplicated are the ids that appear more than once within the batch
+	Duplicated []int
+}
+
+// Error is a method that returns the error message
+func (e *VehicleConflictError) Error() string {
+	var parts []string
+	if len(e.Existing) > 0 {
+		parts = append(parts, fmt.Sprintf("existing ids: %v", e.Existing))
+	}
+	if len(e.Duplicated) > 0 {
+		parts = append(parts, fmt.Sprintf("duplicated ids: %v", e.Duplicated))
+	}
+	return fmt.Sprintf("%s (%s)", ErrVehicleAlreadyExistsRepo, strings.Join(parts, ", "))
+}
+
+// Unwrap is a method that returns ErrVehicleAlreadyExistsRepo, so the error can be checked with errors.Is
+func (e *VehicleConflictError) Unwrap() error {
+	return ErrVehicleAlreadyExistsRepo
+}
+
+// VehicleRegistrationConflictError is an error that lists the registrations that prevented vehicles from being written
+type VehicleRegistrationConflictError struct {
+	// Existing are the registrations already held by other vehicles, as requested
+	Existing []string
+	// Duplicated are the registrations that appear more than once within the batch, as requested
+	Duplicated []string
+}
+
+// Error is a method that returns the error message
+func (e *VehicleRegistrationConflictError) Error() string {
+	var parts []string
+	if len(e.Existing) > 0 {
+		parts = append(parts, fmt.Sprintf("existing registrations: %q", e.Existing))
+	}
+	if len(e.Duplicated) > 0 {
+		parts = append(parts, fmt.Sprintf("duplicated registrations: %q", e.Duplicated))
+	}
+	return fmt.Sprintf("%s (%s)", ErrVehicleRegistrationExistsRepo, strings.Join(parts, ", "))
+}
+
+// Unwrap is a method that returns ErrVehicleRegistrationExistsRepo, so the error can be checked with errors.Is
+func (e *VehicleRegistrationConflictError) Unwrap() error {
+	return ErrVehicleRegistrationExistsRepo
 }
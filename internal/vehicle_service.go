@@ -1,26 +1,70 @@
 package internal
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 var (
-	ErrVehicleAlreadyExistsService = errors.New("Vehicle already exists")
-	ErrVehiclesNotFoundByCriteria  = errors.New("No vehicles found with the given criteria")
-	ErrVehicleNotFoundService      = errors.New("Vehicle with the provided ID not found")
-	ErrNoVehiclesByBrandService    = errors.New("No vehicles found with the given brand")
+	ErrVehicleAlreadyExistsService      = errors.New("Vehicle already exists")
+	ErrVehiclesNotFoundByCriteria       = errors.New("No vehicles found with the given criteria")
+	ErrVehicleNotFoundService           = errors.New("Vehicle with the provided ID not found")
+	ErrNoVehiclesByBrandService         = errors.New("No vehicles found with the given brand")
+	ErrInvalidFilterService             = errors.New("Invalid filter")
+	ErrInvalidPercentileService         = errors.New("Invalid percentile, it must be between 0 and 100")
+	ErrInvalidAggregationService        = errors.New("Invalid aggregation")
+	ErrVehicleVersionConflict           = errors.New("Vehicle version does not match")
+	ErrVehicleInvalidService            = errors.New("Vehicle is not valid")
+	ErrVehicleRegistrationExistsService = errors.New("Vehicle registration already exists")
+	ErrVehicleNotDeletedService         = errors.New("Vehicle is not in the trash")
+	ErrVehicleHistoryUnavailableService = errors.New("Vehicle history does not reach back to the given time")
+	ErrVehicleEventsUnavailableService  = errors.New("Vehicle events are not published")
+	ErrVehicleAuditUnrecordedService    = errors.New("Vehicle change done but not recorded in the audit trail")
 )
 
 // VehicleService is an interface that represents a vehicle service
+// - the methods changing vehicles take the context carrying the actor recorded in the audit trail, see WithActor
 type VehicleService interface {
 	// FindAll is a method that returns a map of all vehicles
 	FindAll() (v map[int]Vehicle, err error)
-	Create(v Vehicle) (err error)
-	GetByColorAndYear(color string, year int) (v map[int]Vehicle, err error)
-	GetByBrandBetweenYears(brand string, yearStart int, yearEnd int) (v map[int]Vehicle, err error)
+	// FindById is a method that returns the vehicle with the given id
+	FindById(id int) (v Vehicle, err error)
+	// FindAllAsOf is a method that returns the vehicles as they were at the given time, including the ones deleted since
+	FindAllAsOf(t time.Time) (v map[int]Vehicle, err error)
+	// FindByIdAsOf is a method that returns the vehicle with the given id as it was at the given time
+	FindByIdAsOf(id int, t time.Time) (v Vehicle, err error)
+	// FindByRegistration is a method that returns the vehicle with the given registration
+	FindByRegistration(registration string) (v Vehicle, err error)
+	// Create is a method that creates a vehicle, assigning its id when v.Id is 0
+	Create(ctx context.Context, v *Vehicle) (err error)
 	GetSpeedAvgByBrand(brand string) (speedAvg float64, err error)
-	CreateMultiple(v map[int]Vehicle) (err error)
-	ListByWeightRange(weightMin, weightMax float64) (v map[int]Vehicle, err error)
-	ListByDimensions(d map[string]float64) (v map[int]Vehicle, err error)
-	Update(v *Vehicle) (err error)
-	Delete(id int) (err error)
+	// CreateMultiple is a method that creates all the vehicles or none of them
+	CreateMultiple(ctx context.Context, v []Vehicle) (err error)
+	// Update is a method that replaces the vehicle, checking its version when it is not 0
+	Update(ctx context.Context, v *Vehicle) (err error)
+	// Delete is a method that moves the vehicle to the trash, checking its version when it is not 0
+	Delete(ctx context.Context, id int, version int) (err error)
+	// FindDeleted is a method that returns the vehicles in the trash
+	FindDeleted() (v map[int]Vehicle, err error)
+	// Restore is a method that moves the vehicle out of the trash
+	Restore(ctx context.Context, id int) (v Vehicle, err error)
+	// Purge is a method that permanently removes the vehicles deleted before the given time
+	Purge(ctx context.Context, before time.Time) (ids []int, err error)
+	// FindAudit is a method that returns the audit entries matching the query, in the order they were recorded
+	FindAudit(q AuditQuery) (e []AuditEntry, err error)
+	// Subscribe is a method that returns a new subscription to the changes, replaying the buffered ones after the given id
+	// - a negative id subscribes to the changes made from now on only
+	Subscribe(after int) (s VehicleSubscription, err error)
 	GetAverageCapacityByBrand(brand string) (capacityAvg float64, err error)
+	// FindByFilter is a method that validates the filter and returns the vehicles matching it
+	FindByFilter(f VehicleFilter) (v map[int]Vehicle, err error)
+	// GetBrandAggregates is a method that returns the aggregates of every brand, keyed by brand
+	GetBrandAggregates() (a map[string]BrandAggregate, err error)
+	// GetStatistics is a method that returns descriptive statistics of the numeric fields of the vehicles matching
+	// the filter, keyed by field name
+	GetStatistics(f VehicleFilter, percentiles []float64) (s map[string]VehicleStatistics, err error)
+	// Aggregate is a method that validates the aggregation, groups the vehicles and computes the metrics of each group
+	// - rows are sorted by their group values
+	Aggregate(a VehicleAggregation) (rows []VehicleAggregateRow, err error)
 }
@@ -0,0 +1,53 @@
+package internal
+
+import "errors"
+
+// Classes of the errors returned by the vehicle service, every error it returns wraps exactly one of them
+var (
+	// ErrClassNotFound is the class of the errors caused by a vehicle or criteria matching nothing
+	ErrClassNotFound = errors.New("not found")
+	// ErrClassConflict is the class of the errors caused by the current state of the vehicles (existing id, stale version)
+	ErrClassConflict = errors.New("conflict")
+	// ErrClassValidation is the class of the errors caused by invalid input (vehicle, filter, aggregation)
+	ErrClassValidation = errors.New("validation")
+	// ErrClassUnavailable is the class of the errors caused by a storage that cannot be reached for now, worth retrying
+	ErrClassUnavailable = errors.New("unavailable")
+	// ErrClassInternal is the class of any other error
+	ErrClassInternal = errors.New("internal")
+)
+
+// VehicleServiceError is an error returned by the vehicle service
+// - it wraps the class of the failure, the service sentinel it is reported as (if any) and the underlying error,
+// so each of them can be checked with errors.Is and errors.As
+type VehicleServiceError struct {
+	// Op is the operation that failed, e.g. "update"
+	Op string
+	// Class is one of the ErrClass* errors
+	Class error
+	// Kind is the service sentinel the error is reported as, nil when the underlying error is already one
+	Kind error
+	// Err is the underlying error
+	Err error
+}
+
+// Error is a method that returns the error message, prefixed with the operation
+func (e *VehicleServiceError) Error() string {
+	switch {
+	case e.Kind == nil:
+		return e.Op + ": " + e.Err.Error()
+	case e.Err.Error() == e.Kind.Error():
+		// the repository sentinel says the same as the service one
+		return e.Op + ": " + e.Kind.Error()
+	default:
+		return e.Op + ": " + e.Kind.Error() + ": " + e.Err.Error()
+	}
+}
+
+// Unwrap is a method that returns the class, the kind and the underlying error
+func (e *VehicleServiceError) Unwrap() []error {
+	errs := []error{e.Class}
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	return append(errs, e.Err)
+}
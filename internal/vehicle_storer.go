@@ -0,0 +1,7 @@
+package internal
+
+// VehicleStorer is an interface that represents the storer for vehicles
+type VehicleStorer interface {
+	// Save is a method that saves the vehicles, replacing the previously saved ones
+	Save(v map[int]Vehicle) (err error)
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VehicleValidator is an interface that represents a validator of vehicles
+type VehicleValidator interface {
+	// Validate is a method that returns a *VehicleValidationError listing every rule the vehicle breaks, nil if none
+	Validate(v Vehicle) (err error)
+}
+
+// VehicleFieldError is a struct that represents a rule broken by a field of a vehicle
+type VehicleFieldError struct {
+	// Field is the name of the field, as in VehicleFields (e.g. max_speed), prefixed with the position for batches
+	Field string
+	// Rule is the name of the rule broken: required, min, range or enum
+	Rule string
+	// Message is the human readable description of the rule
+	Message string
+}
+
+// VehicleValidationError is an error that lists the rules broken by one or more vehicles
+type VehicleValidationError struct {
+	// Errors are the rules broken, in field order
+	Errors []VehicleFieldError
+}
+
+// Error is a method that returns the error message
+func (e *VehicleValidationError) Error() string {
+	parts := make([]string, 0, len(e.Errors))
+	for _, fe := range e.Errors {
+		parts = append(parts, fmt.Sprintf("%s %s", fe.Field, fe.Message))
+	}
+	return fmt.Sprintf("%s (%s)", ErrVehicleInvalidService, strings.Join(parts, "; "))
+}
+
+// Unwrap is a method that returns ErrVehicleInvalidService, so the error can be checked with errors.Is
+func (e *VehicleValidationError) Unwrap() error {
+	return ErrVehicleInvalidService
+}
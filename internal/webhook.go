@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrWebhookNotFoundRepo            = errors.New("Webhook with the provided ID not found")
+	ErrWebhookDeliveryNotFoundRepo    = errors.New("Webhook delivery with the provided ID not found")
+	ErrWebhookDeliveryChangedRepo     = errors.New("Webhook delivery status has changed")
+	ErrWebhookNotFoundService         = errors.New("Webhook not found")
+	ErrWebhookInvalidService          = errors.New("Webhook is not valid")
+	ErrWebhookDeliveryNotFoundService = errors.New("Webhook delivery not found")
+	ErrWebhookDeliveryNotDeadService  = errors.New("Webhook delivery is not in the dead-letter list")
+)
+
+// Statuses of the deliveries of a webhook
+const (
+	// WebhookDeliveryPending is the status of a delivery waiting for its next attempt
+	WebhookDeliveryPending = "pending"
+	// WebhookDeliverySucceeded is the status of a delivery acknowledged by the receiver with a 2xx response
+	WebhookDeliverySucceeded = "succeeded"
+	// WebhookDeliveryDead is the status of a delivery whose attempts all failed, it is in the dead-letter list
+	WebhookDeliveryDead = "dead"
+)
+
+// Webhook is a struct that represents a subscription of a receiver to the vehicle events
+type Webhook struct {
+	// Id is the unique identifier of the webhook, assigned when it is created
+	Id int
+	// URL is where the events are posted
+	URL string
+	// Events are the VehicleEvent* types delivered, every type when empty
+	Events []string
+	// Secret is the key the deliveries are signed with
+	Secret string
+	// CreatedAt is when the webhook was created
+	CreatedAt time.Time
+}
+
+// Accepts is a method that returns whether the events of the given type are delivered to the webhook
+func (w Webhook) Accepts(typ string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, event := range w.Events {
+		if event == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookAttempt is a struct that represents an attempt to deliver an event to a webhook
+type WebhookAttempt struct {
+	// Time is when the attempt started
+	Time time.Time
+	// StatusCode is the status of the response, 0 when there was none
+	StatusCode int
+	// Error is why the attempt failed, empty when it succeeded
+	Error string
+	// Duration is how long the attempt took
+	Duration time.Duration
+}
+
+// WebhookDelivery is a struct that represents the delivery of an event to a webhook
+type WebhookDelivery struct {
+	// Id is the unique identifier of the delivery, assigned when it is created
+	Id int
+	// WebhookId is the id of the webhook
+	WebhookId int
+	// EventId is the id of the event, see VehicleEvent
+	EventId int
+	// EventType is the type of the event
+	EventType string
+	// Payload is the body posted, kept so the delivery can be retried
+	Payload []byte
+	// Status is one of the WebhookDelivery* statuses
+	Status string
+	// Attempts are the attempts made so far, in order
+	Attempts []WebhookAttempt
+	// AttemptsLeft is how many attempts are left before the delivery is dead
+	AttemptsLeft int
+	// NextAttemptAt is when the delivery is attempted again, zero when it is not pending
+	NextAttemptAt time.Time
+	// CreatedAt is when the delivery was created
+	CreatedAt time.Time
+}
+
+// WebhookRepository is an interface that represents the storage of the webhooks and their delivery log
+type WebhookRepository interface {
+	// Create is a method that stores a new webhook, assigning its id
+	Create(w *Webhook) (err error)
+	// FindAll is a method that returns every webhook sorted by id
+	FindAll() (w []Webhook, err error)
+	// FindById is a method that returns the webhook with the given id
+	FindById(id int) (w Webhook, err error)
+	// Delete is a method that removes the webhook with the given id and its deliveries
+	Delete(id int) (err error)
+	// CreateDelivery is a method that stores a new delivery, assigning its id
+	CreateDelivery(d *WebhookDelivery) (err error)
+	// UpdateDelivery is a method that replaces the delivery with the same id
+	UpdateDelivery(d WebhookDelivery) (err error)
+	// UpdateDeliveryFrom is a method that replaces the delivery with the same id only while its status is the given one
+	// - it returns ErrWebhookDeliveryChangedRepo when the status is another one
+	UpdateDeliveryFrom(d WebhookDelivery, status string) (err error)
+	// FindDeliveryById is a method that returns the delivery with the given id
+	FindDeliveryById(id int) (d WebhookDelivery, err error)
+	// FindDeliveries is a method that returns the deliveries of the webhook sorted by id
+	FindDeliveries(webhookId int) (d []WebhookDelivery, err error)
+	// FindDeliveriesByStatus is a method that returns the deliveries with the given status sorted by id
+	FindDeliveriesByStatus(status string) (d []WebhookDelivery, err error)
+}
+
+// WebhookService is an interface that represents the management of the webhooks
+// - the events are delivered in the background, see service.WebhookDefault.Run
+type WebhookService interface {
+	// Create is a method that creates a webhook, generating its secret when it has none
+	Create(w *Webhook) (err error)
+	// FindAll is a method that returns every webhook sorted by id
+	FindAll() (w []Webhook, err error)
+	// FindById is a method that returns the webhook with the given id
+	FindById(id int) (w Webhook, err error)
+	// Delete is a method that removes the webhook with the given id, its pending deliveries are abandoned
+	Delete(id int) (err error)
+	// FindDeliveries is a method that returns the delivery log of the webhook with the given id
+	FindDeliveries(webhookId int) (d []WebhookDelivery, err error)
+	// FindDeadLetters is a method that returns the deliveries whose attempts all failed
+	FindDeadLetters() (d []WebhookDelivery, err error)
+	// Redeliver is a method that moves a dead delivery back to pending and attempts it again right away
+	Redeliver(id int) (d WebhookDelivery, err error)
+}